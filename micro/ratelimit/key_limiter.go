@@ -0,0 +1,249 @@
+package ratelimit
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Result 一次限流判定的结果
+type Result struct {
+	Allowed bool
+	// Limit 一个窗口内的阈值，对于令牌桶来说是桶的容量
+	Limit int
+	// Remaining 当前窗口内还剩下多少请求可以通过
+	Remaining int
+	// RetryAfter 被限流之后，最少要等多久再重试
+	RetryAfter time.Duration
+	// Reset 多久之后额度完全恢复
+	Reset time.Duration
+}
+
+// KeyLimiter 按照 key 来限流，例如按照 IP 或者用户 ID
+// 它和具体的协议无关，所以 gRPC 和 HTTP 都可以复用同一套算法
+type KeyLimiter interface {
+	Allow(ctx context.Context, key string) (Result, error)
+}
+
+var (
+	_ KeyLimiter = &LocalKeyLimiter{}
+	_ KeyLimiter = &RedisFixWindowLimiter{}
+	_ KeyLimiter = &RedisSlidingWindowLimiter{}
+)
+
+// algorithm 某一个 key 上的限流状态，由 LocalKeyLimiter 保证并发安全
+type algorithm interface {
+	allow(now time.Time) Result
+	// idle 在 now 这个时刻，状态是否已经恢复到初始状态，可以被回收
+	idle(now time.Time) bool
+}
+
+// LocalKeyLimiter 基于本地内存的 KeyLimiter
+// 每个 key 都有自己独立的状态，长期没有请求的 key 会被回收，
+// 避免按照 IP 限流的时候内存无限增长
+type LocalKeyLimiter struct {
+	mutex    sync.Mutex
+	states   map[string]algorithm
+	newState func() algorithm
+
+	// 每隔 sweepInterval 清理一次已经恢复到初始状态的 key
+	sweepInterval time.Duration
+	lastSweep     time.Time
+}
+
+func newLocalKeyLimiter(sweepInterval time.Duration, newState func() algorithm) *LocalKeyLimiter {
+	return &LocalKeyLimiter{
+		states:        make(map[string]algorithm, 16),
+		newState:      newState,
+		sweepInterval: sweepInterval,
+		lastSweep:     time.Now(),
+	}
+}
+
+// NewTokenBucketKeyLimiter 令牌桶，每个 key 最多缓存 buffer 个令牌，每隔 interval 产生一个令牌
+// 和 TokenBucketLimiter 不同，这里不会为每个 key 启动 goroutine，而是在请求到来的时候计算令牌数
+func NewTokenBucketKeyLimiter(buffer int, interval time.Duration) *LocalKeyLimiter {
+	return newLocalKeyLimiter(interval*time.Duration(buffer), func() algorithm {
+		return &tokenBucket{buffer: buffer, interval: interval, tokens: buffer}
+	})
+}
+
+// NewLeakyBucketKeyLimiter 漏桶，每个 key 每隔 interval 才允许通过一个请求
+// 和 LeakyBucketLimiter 不同，这里不会等待，而是直接拒绝
+func NewLeakyBucketKeyLimiter(interval time.Duration) *LocalKeyLimiter {
+	return newLocalKeyLimiter(interval, func() algorithm {
+		return &leakyBucket{interval: interval}
+	})
+}
+
+// NewFixWindowKeyLimiter 固定窗口，每个 key 在 interval 内最多允许 rate 个请求
+func NewFixWindowKeyLimiter(rate int, interval time.Duration) *LocalKeyLimiter {
+	return newLocalKeyLimiter(interval, func() algorithm {
+		return &fixWindow{rate: rate, interval: interval}
+	})
+}
+
+// NewSlideWindowKeyLimiter 滑动窗口，每个 key 在任意的 interval 内最多允许 rate 个请求
+func NewSlideWindowKeyLimiter(rate int, interval time.Duration) *LocalKeyLimiter {
+	return newLocalKeyLimiter(interval, func() algorithm {
+		return &slideWindow{rate: rate, interval: interval, queue: list.New()}
+	})
+}
+
+func (l *LocalKeyLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	now := time.Now()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if now.Sub(l.lastSweep) >= l.sweepInterval {
+		l.sweep(now)
+	}
+	state, ok := l.states[key]
+	if !ok {
+		state = l.newState()
+		l.states[key] = state
+	}
+	return state.allow(now), nil
+}
+
+func (l *LocalKeyLimiter) sweep(now time.Time) {
+	for key, state := range l.states {
+		if state.idle(now) {
+			delete(l.states, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+type tokenBucket struct {
+	buffer   int
+	interval time.Duration
+	tokens   int
+	// last 上一次产生令牌的时间
+	last time.Time
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if b.last.IsZero() {
+		b.last = now
+		return
+	}
+	produced := int(now.Sub(b.last) / b.interval)
+	if produced <= 0 {
+		return
+	}
+	b.tokens += produced
+	b.last = b.last.Add(time.Duration(produced) * b.interval)
+	if b.tokens >= b.buffer {
+		b.tokens = b.buffer
+		b.last = now
+	}
+}
+
+func (b *tokenBucket) allow(now time.Time) Result {
+	b.refill(now)
+	res := Result{Limit: b.buffer}
+	nextToken := b.interval - now.Sub(b.last)
+	if b.tokens > 0 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = nextToken
+	}
+	res.Remaining = b.tokens
+	if missing := b.buffer - b.tokens; missing > 0 {
+		res.Reset = nextToken + time.Duration(missing-1)*b.interval
+	}
+	return res
+}
+
+func (b *tokenBucket) idle(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.buffer
+}
+
+type leakyBucket struct {
+	interval time.Duration
+	// next 下一个请求最早可以通过的时间
+	next time.Time
+}
+
+func (b *leakyBucket) allow(now time.Time) Result {
+	res := Result{Limit: 1}
+	if now.Before(b.next) {
+		res.RetryAfter = b.next.Sub(now)
+		res.Reset = res.RetryAfter
+		return res
+	}
+	b.next = now.Add(b.interval)
+	res.Allowed = true
+	res.Reset = b.interval
+	return res
+}
+
+func (b *leakyBucket) idle(now time.Time) bool {
+	return !now.Before(b.next)
+}
+
+type fixWindow struct {
+	rate     int
+	interval time.Duration
+	count    int
+	start    time.Time
+}
+
+func (w *fixWindow) allow(now time.Time) Result {
+	if now.Sub(w.start) >= w.interval {
+		w.start = now
+		w.count = 0
+	}
+	res := Result{Limit: w.rate, Reset: w.start.Add(w.interval).Sub(now)}
+	if w.count < w.rate {
+		w.count++
+		res.Allowed = true
+	} else {
+		res.RetryAfter = res.Reset
+	}
+	res.Remaining = w.rate - w.count
+	return res
+}
+
+func (w *fixWindow) idle(now time.Time) bool {
+	return now.Sub(w.start) >= w.interval
+}
+
+type slideWindow struct {
+	rate     int
+	interval time.Duration
+	// queue 窗口内每个请求的时间
+	queue *list.List
+}
+
+func (w *slideWindow) evict(now time.Time) {
+	boundary := now.Add(-w.interval)
+	for e := w.queue.Front(); e != nil && !e.Value.(time.Time).After(boundary); e = w.queue.Front() {
+		w.queue.Remove(e)
+	}
+}
+
+func (w *slideWindow) allow(now time.Time) Result {
+	w.evict(now)
+	res := Result{Limit: w.rate}
+	if w.queue.Len() < w.rate {
+		w.queue.PushBack(now)
+		res.Allowed = true
+	} else if front := w.queue.Front(); front != nil {
+		// 要等窗口内最早的请求滑出去
+		res.RetryAfter = front.Value.(time.Time).Add(w.interval).Sub(now)
+	}
+	res.Remaining = w.rate - w.queue.Len()
+	if back := w.queue.Back(); back != nil {
+		res.Reset = back.Value.(time.Time).Add(w.interval).Sub(now)
+	}
+	return res
+}
+
+func (w *slideWindow) idle(now time.Time) bool {
+	w.evict(now)
+	return w.queue.Len() == 0
+}
@@ -0,0 +1,96 @@
+package ratelimit
+
+import (
+	"container/list"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestAlgorithm_allow(t *testing.T) {
+	start := time.Now()
+	at := func(d time.Duration) time.Time {
+		return start.Add(d)
+	}
+	type step struct {
+		at   time.Time
+		want Result
+	}
+	testCases := []struct {
+		name  string
+		state algorithm
+		steps []step
+	}{
+		{
+			name:  "token bucket",
+			state: &tokenBucket{buffer: 2, interval: time.Second, tokens: 2},
+			steps: []step{
+				{at: at(0), want: Result{Allowed: true, Limit: 2, Remaining: 1, Reset: time.Second}},
+				{at: at(0), want: Result{Allowed: true, Limit: 2, Remaining: 0, Reset: 2 * time.Second}},
+				{at: at(500 * time.Millisecond), want: Result{Limit: 2,
+					RetryAfter: 500 * time.Millisecond, Reset: 1500 * time.Millisecond}},
+				{at: at(time.Second), want: Result{Allowed: true, Limit: 2, Remaining: 0, Reset: 2 * time.Second}},
+				{at: at(5 * time.Second), want: Result{Allowed: true, Limit: 2, Remaining: 1, Reset: time.Second}},
+			},
+		},
+		{
+			name:  "leaky bucket",
+			state: &leakyBucket{interval: time.Second},
+			steps: []step{
+				{at: at(0), want: Result{Allowed: true, Limit: 1, Reset: time.Second}},
+				{at: at(300 * time.Millisecond), want: Result{Limit: 1,
+					RetryAfter: 700 * time.Millisecond, Reset: 700 * time.Millisecond}},
+				{at: at(time.Second), want: Result{Allowed: true, Limit: 1, Reset: time.Second}},
+			},
+		},
+		{
+			name:  "fix window",
+			state: &fixWindow{rate: 2, interval: time.Second},
+			steps: []step{
+				{at: at(0), want: Result{Allowed: true, Limit: 2, Remaining: 1, Reset: time.Second}},
+				{at: at(100 * time.Millisecond), want: Result{Allowed: true, Limit: 2,
+					Remaining: 0, Reset: 900 * time.Millisecond}},
+				{at: at(200 * time.Millisecond), want: Result{Limit: 2,
+					RetryAfter: 800 * time.Millisecond, Reset: 800 * time.Millisecond}},
+				{at: at(time.Second), want: Result{Allowed: true, Limit: 2, Remaining: 1, Reset: time.Second}},
+			},
+		},
+		{
+			name:  "slide window",
+			state: &slideWindow{rate: 2, interval: time.Second, queue: list.New()},
+			steps: []step{
+				{at: at(0), want: Result{Allowed: true, Limit: 2, Remaining: 1, Reset: time.Second}},
+				{at: at(600 * time.Millisecond), want: Result{Allowed: true, Limit: 2,
+					Remaining: 0, Reset: time.Second}},
+				{at: at(800 * time.Millisecond), want: Result{Limit: 2,
+					RetryAfter: 200 * time.Millisecond, Reset: 800 * time.Millisecond}},
+				// 第一个请求滑出去了
+				{at: at(time.Second), want: Result{Allowed: true, Limit: 2, Remaining: 0, Reset: time.Second}},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for i, s := range tc.steps {
+				assert.Equal(t, s.want, tc.state.allow(s.at), "step %d", i)
+			}
+		})
+	}
+}
+
+func TestLocalKeyLimiter_Allow(t *testing.T) {
+	l := NewFixWindowKeyLimiter(1, time.Hour)
+	res, err := l.Allow(context.Background(), "a")
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+	res, _ = l.Allow(context.Background(), "a")
+	assert.False(t, res.Allowed)
+	// 不同的 key 互不影响
+	res, _ = l.Allow(context.Background(), "b")
+	assert.True(t, res.Allowed)
+
+	// 恢复到初始状态的 key 会被回收
+	l.sweep(time.Now().Add(2 * time.Hour))
+	assert.Empty(t, l.states)
+}
@@ -1,21 +1,23 @@
+-- 参数（按照顺序）：窗口大小（毫秒），阈值
+-- 返回：是否限流（1 限流，0 不限流），窗口内的请求数，窗口剩余时间（毫秒）
 local val = redis.call('get', KEYS[1])
 local limit = tonumber(ARGV[2])
 if val == false then
     if limit < 1 then
         -- 执行限流
-        return "true"
+        return {1, 0, tonumber(ARGV[1])}
     else
         -- key 不存在，设置初始值 1，并且设置过期时间
         redis.call('set', KEYS[1], 1, 'PX', ARGV[1])
         -- 不执行限流
-        return "false"
+        return {0, 1, tonumber(ARGV[1])}
     end
 elseif tonumber(val) < limit then
     -- 自增 1
-    redis.call('incr', KEYS[1])
+    local cnt = redis.call('incr', KEYS[1])
     -- 不需要限流
-    return "false"
+    return {0, cnt, redis.call('pttl', KEYS[1])}
 else
     -- 限流
-    return "true"
-end
\ No newline at end of file
+    return {1, tonumber(val), redis.call('pttl', KEYS[1])}
+end
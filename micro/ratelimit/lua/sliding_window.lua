@@ -1,9 +1,12 @@
 -- KEY 只有一个
--- 参数（按照顺序）：阈值，窗口大小（毫秒），当前时间戳（毫秒）。
+-- 参数（按照顺序）：阈值，窗口大小（毫秒），当前时间戳（毫秒），本次请求的唯一标识。
 -- 你们也可以考虑使用秒或者纳秒作为单位，差异不大
+-- 返回：是否限流（1 限流，0 不限流），窗口内的请求数，多久之后可以重试（毫秒）
 local threshold = tonumber(ARGV[1])
 local window = tonumber(ARGV[2])
 local now = tonumber(ARGV[3])
+-- 同一毫秒内可能有多个请求，所以成员不能直接用时间戳，否则会被去重
+local member = ARGV[4] or ARGV[3]
 
 local min = now - window
 local key = KEYS[1]
@@ -15,14 +18,18 @@ redis.call('ZREMRANGEBYSCORE', key, '-inf', min)
 local cnt = redis.call('ZCOUNT', key, '-inf', '+inf')
 
 if cnt >= threshold then
-    -- 限流
-    return "true"
+    -- 限流，要等窗口内最早的请求滑出去
+    local retry = window
+    local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+    if oldest[2] ~= nil then
+        retry = tonumber(oldest[2]) + window - now
+    end
+    return {1, cnt, retry}
 else
-    -- 值和优先级我们都设置成当前时间戳
-    redis.call('ZADD', key, now, now)
+    -- 优先级设置成当前时间戳
+    redis.call('ZADD', key, now, member)
     -- 这里设不设置过期时间影响不大，设置了过期时间可以防止长期没有人访问的 key 正常被删除
     redis.call('PEXPIRE', key, window)
     -- 不限流
-    return "false"
+    return {0, cnt + 1, 0}
 end
-
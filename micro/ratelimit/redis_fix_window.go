@@ -3,6 +3,7 @@ package ratelimit
 import (
 	"context"
 	_ "embed"
+	"errors"
 	"github.com/go-redis/redis/v9"
 	"google.golang.org/grpc"
 	"time"
@@ -57,5 +58,42 @@ func (l *RedisFixWindowLimiter) LimitUnary() grpc.UnaryServerInterceptor {
 }
 
 func (l *RedisFixWindowLimiter) limit(ctx context.Context) (bool, error) {
-	return l.client.Eval(ctx, luaFixWindow, []string{l.key}, l.interval.Milliseconds(), l.rate).Bool()
+	res, err := l.eval(ctx, l.key)
+	return !res.Allowed, err
+}
+
+// Allow 实现 KeyLimiter，key 会被拼接在 l.key 后面，例如 my-service:127.0.0.1
+func (l *RedisFixWindowLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	return l.eval(ctx, joinKey(l.key, key))
+}
+
+func (l *RedisFixWindowLimiter) eval(ctx context.Context, key string) (Result, error) {
+	vals, err := l.client.Eval(ctx, luaFixWindow, []string{key}, l.interval.Milliseconds(), l.rate).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	if len(vals) != 3 {
+		return Result{}, errLuaResult
+	}
+	res := Result{
+		Allowed: vals[0] == 0,
+		Limit:   l.rate,
+		Reset:   time.Duration(vals[2]) * time.Millisecond,
+	}
+	if remaining := l.rate - int(vals[1]); remaining > 0 {
+		res.Remaining = remaining
+	}
+	if !res.Allowed {
+		res.RetryAfter = res.Reset
+	}
+	return res, nil
+}
+
+var errLuaResult = errors.New("micro: 限流脚本返回了非法的结果")
+
+func joinKey(prefix string, key string) string {
+	if key == "" {
+		return prefix
+	}
+	return prefix + ":" + key
 }
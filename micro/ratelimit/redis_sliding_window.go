@@ -5,6 +5,8 @@ import (
 	_ "embed"
 	"github.com/go-redis/redis/v9"
 	"google.golang.org/grpc"
+	"strconv"
+	"sync/atomic"
 	"time"
 )
 
@@ -12,6 +14,9 @@ import (
 var luaSlidingWindow string
 
 type RedisSlidingWindowLimiter struct {
+	// seq 用于生成请求的唯一标识，避免同一毫秒内的请求被 ZADD 去重
+	// 64 位的原子操作在 32 位平台上要求 8 字节对齐，所以必须放在第一个字段
+	seq uint64
 	key string
 	// 窗口内的流量阈值
 	rate int
@@ -48,6 +53,36 @@ func (l *RedisSlidingWindowLimiter) LimitUnary() grpc.UnaryServerInterceptor {
 }
 
 func (l *RedisSlidingWindowLimiter) limit(ctx context.Context) (bool, error) {
+	res, err := l.eval(ctx, l.key)
+	return !res.Allowed, err
+}
+
+// Allow 实现 KeyLimiter，key 会被拼接在 l.key 后面，例如 my-service:127.0.0.1
+func (l *RedisSlidingWindowLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	return l.eval(ctx, joinKey(l.key, key))
+}
+
+func (l *RedisSlidingWindowLimiter) eval(ctx context.Context, key string) (Result, error) {
 	now := time.Now()
-	return l.client.Eval(ctx, luaSlidingWindow, []string{l.key}, l.rate, l.interval, now.UnixMilli()).Bool()
+	member := strconv.FormatInt(now.UnixNano(), 10) + "-" +
+		strconv.FormatUint(atomic.AddUint64(&l.seq, 1), 10)
+	vals, err := l.client.Eval(ctx, luaSlidingWindow, []string{key},
+		l.rate, l.interval, now.UnixMilli(), member).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	if len(vals) != 3 {
+		return Result{}, errLuaResult
+	}
+	res := Result{
+		Allowed:    vals[0] == 0,
+		Limit:      l.rate,
+		RetryAfter: time.Duration(vals[2]) * time.Millisecond,
+		// 最坏的情况下，要等一个完整的窗口额度才会完全恢复
+		Reset: time.Duration(l.interval) * time.Millisecond,
+	}
+	if remaining := l.rate - int(vals[1]); remaining > 0 {
+		res.Remaining = remaining
+	}
+	return res, nil
 }
@@ -8,6 +8,7 @@ import (
 	"gitee.com/geektime-geekbang/geektime-go/micro/rpc/message"
 	"gitee.com/geektime-geekbang/geektime-go/micro/rpc/serialize"
 	"gitee.com/geektime-geekbang/geektime-go/micro/rpc/serialize/json"
+	"gitee.com/geektime-geekbang/geektime-go/requestid"
 	"github.com/gotomicro/ekit/bean/option"
 	"github.com/silenceper/pool"
 	"net"
@@ -152,6 +153,10 @@ func setFuncField(s serialize.Serializer, c compress.Compressor, val Service, pr
 					// 传输字符串，需要更加多的空间
 					meta["deadline"] = strconv.FormatInt(deadline.UnixMilli(), 10)
 				}
+				// 把请求 ID 传给服务端，这样整条链路的日志都能串起来
+				if id := requestid.FromContext(ctx); id != "" {
+					meta[requestid.MetaKey] = id
+				}
 				req := message.GetRequest()
 				defer message.PutRequest(req)
 				req.Meta = meta
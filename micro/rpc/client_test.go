@@ -5,6 +5,7 @@ import (
 	"gitee.com/geektime-geekbang/geektime-go/micro/rpc/compress"
 	"gitee.com/geektime-geekbang/geektime-go/micro/rpc/message"
 	"gitee.com/geektime-geekbang/geektime-go/micro/rpc/serialize/json"
+	"gitee.com/geektime-geekbang/geektime-go/requestid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"testing"
@@ -39,6 +40,7 @@ func Test_setFuncField(t *testing.T) {
 					ServiceName: "user-service",
 					Method:      "GetById",
 					Serializer:  serializer.Code(),
+					Meta:        map[string]string{},
 					Data:        []byte(`{"msg":"这是GetById"}`),
 				},
 				resp: &message.Response{
@@ -49,6 +51,41 @@ func Test_setFuncField(t *testing.T) {
 				Msg: "这是GetById的响应",
 			},
 		},
+		{
+			name: "request id",
+			s: func() *mockService {
+				s := &UserServiceClient{}
+				return &mockService{
+					s: s,
+					do: func() (any, error) {
+						ctx := requestid.NewContext(context.Background(), "req-1")
+						return s.GetById(ctx, &AnyRequest{Msg: "这是GetById"})
+					},
+				}
+			}(),
+			proxy: &mockProxy{
+				t: t,
+				req: func() *message.Request {
+					req := &message.Request{
+						BodyLength:  23,
+						MessageId:   2,
+						ServiceName: "user-service",
+						Method:      "GetById",
+						Serializer:  serializer.Code(),
+						Meta:        map[string]string{"request-id": "req-1"},
+						Data:        []byte(`{"msg":"这是GetById"}`),
+					}
+					req.SetHeadLength()
+					return req
+				}(),
+				resp: &message.Response{
+					Data: []byte(`{"msg":"这是GetById的响应"}`),
+				},
+			},
+			wantResp: &AnyResponse{
+				Msg: "这是GetById的响应",
+			},
+		},
 	}
 
 	s := json.Serializer{}
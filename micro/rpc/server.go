@@ -7,6 +7,7 @@ import (
 	"gitee.com/geektime-geekbang/geektime-go/micro/rpc/message"
 	"gitee.com/geektime-geekbang/geektime-go/micro/rpc/serialize"
 	"gitee.com/geektime-geekbang/geektime-go/micro/rpc/serialize/json"
+	"gitee.com/geektime-geekbang/geektime-go/requestid"
 	"net"
 	"reflect"
 	"strconv"
@@ -61,6 +62,9 @@ func (s *Server) handleConnection(conn net.Conn) {
 		if err == nil {
 			ctx, cancel = context.WithDeadline(ctx, time.UnixMilli(deadline))
 		}
+		if id := req.Meta[requestid.MetaKey]; id != "" {
+			ctx = requestid.NewContext(ctx, id)
+		}
 		resp, er := s.Invoke(ctx, req)
 		if req.Meta["one-way"] == "true" {
 			// 什么也不需要处理。
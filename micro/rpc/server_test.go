@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"gitee.com/geektime-geekbang/geektime-go/micro/rpc/message"
 	json2 "gitee.com/geektime-geekbang/geektime-go/micro/rpc/serialize/json"
+	"gitee.com/geektime-geekbang/geektime-go/requestid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"io"
@@ -27,6 +28,26 @@ func TestServer_handleConnection(t *testing.T) {
 			},
 			wantResp: []byte(`{"msg":"这是GetById的响应"}`),
 		},
+		{
+			// 服务端要把请求 ID 放回 ctx 里面
+			name:    "request id",
+			service: &requestIDService{},
+			conn: &mockConn{
+				readData: func() []byte {
+					req := &message.Request{
+						ServiceName: "request-id-service",
+						Method:      "GetById",
+						Data:        []byte(`{}`),
+						Serializer:  1,
+						BodyLength:  2,
+						Meta:        map[string]string{requestid.MetaKey: "req-1"},
+					}
+					req.SetHeadLength()
+					return message.EncodeReq(req)
+				}(),
+			},
+			wantResp: []byte(`{"msg":"req-1"}`),
+		},
 	}
 	serializer := json2.Serializer{}
 	for _, tc := range testCases {
@@ -54,6 +75,19 @@ func (u *UserService) GetById(ctx context.Context, request *AnyRequest) (*AnyRes
 	}, nil
 }
 
+type requestIDService struct {
+}
+
+func (r *requestIDService) ServiceName() string {
+	return "request-id-service"
+}
+
+func (r *requestIDService) GetById(ctx context.Context, request *AnyRequest) (*AnyResponse, error) {
+	return &AnyResponse{
+		Msg: requestid.FromContext(ctx),
+	}, nil
+}
+
 func newRequestBytes(t *testing.T, service string, method string, input any) []byte {
 	data, err := json.Marshal(input)
 	require.NoError(t, err)
@@ -3,26 +3,46 @@ package querylog
 import (
 	"context"
 	"gitee.com/geektime-geekbang/geektime-go/orm"
+	"gitee.com/geektime-geekbang/geektime-go/requestid"
 	"log"
 )
 
 type MiddlewareBuilder struct {
-	logFunc func(sql string, args []any)
+	logFunc func(ctx context.Context, sql string, args []any)
 }
 
+// LogFunc 拿到的是原始的 SQL，需要请求 ID 的话用 LogFuncWithCtx
 func (m *MiddlewareBuilder) LogFunc(logFunc func(sql string, args []any)) *MiddlewareBuilder {
+	m.logFunc = func(ctx context.Context, sql string, args []any) {
+		logFunc(sql, args)
+	}
+	return m
+}
+
+// LogFuncWithCtx 需要用到 ctx 里面的数据的时候用这个，例如用 requestid.FromContext 拿到请求 ID
+// sql 是原始的 SQL
+func (m *MiddlewareBuilder) LogFuncWithCtx(logFunc func(ctx context.Context, sql string, args []any)) *MiddlewareBuilder {
 	m.logFunc = logFunc
 	return m
 }
 
 func NewBuilder() *MiddlewareBuilder {
 	return &MiddlewareBuilder{
-		logFunc: func(sql string, args []any) {
-			log.Println(sql, args)
+		logFunc: func(ctx context.Context, sql string, args []any) {
+			// 带上请求 ID，就能和 HTTP 的日志对上了
+			log.Println(withRequestID(ctx, sql), args)
 		},
 	}
 }
 
+// withRequestID 用 SQL 注释的形式把请求 ID 带上
+func withRequestID(ctx context.Context, sql string) string {
+	if id := requestid.FromContext(ctx); id != "" {
+		return "/* request_id=" + id + " */ " + sql
+	}
+	return sql
+}
+
 func (m *MiddlewareBuilder) Build() orm.Middleware {
 	return func(next orm.HandleFunc) orm.HandleFunc {
 		return func(ctx context.Context, qc *orm.QueryContext) *orm.QueryResult {
@@ -32,7 +52,7 @@ func (m *MiddlewareBuilder) Build() orm.Middleware {
 					Err: err,
 				}
 			}
-			m.logFunc(q.SQL, q.Args)
+			m.logFunc(ctx, q.SQL, q.Args)
 			return next(ctx, qc)
 		}
 	}
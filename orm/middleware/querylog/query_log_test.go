@@ -0,0 +1,84 @@
+package querylog
+
+import (
+	"context"
+	"gitee.com/geektime-geekbang/geektime-go/orm"
+	"gitee.com/geektime-geekbang/geektime-go/requestid"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type TestModel struct {
+	Id   int64
+	Name string
+}
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	testCases := []struct {
+		name string
+		ctx  context.Context
+
+		wantSQL string
+	}{
+		{
+			name:    "no request id",
+			ctx:     context.Background(),
+			wantSQL: "SELECT * FROM `test_model` WHERE `id` = ?;",
+		},
+		{
+			// LogFunc 拿到的 SQL 不会带上请求 ID
+			name:    "request id",
+			ctx:     requestid.NewContext(context.Background(), "req-1"),
+			wantSQL: "SELECT * FROM `test_model` WHERE `id` = ?;",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer func() { _ = mockDB.Close() }()
+			var (
+				gotSQL  string
+				gotArgs []any
+			)
+			db, err := orm.OpenDB(mockDB, orm.DBWithMiddleware(NewBuilder().
+				LogFunc(func(sql string, args []any) {
+					gotSQL, gotArgs = sql, args
+				}).Build()))
+			require.NoError(t, err)
+			// 真正执行的 SQL 不会带上请求 ID
+			mock.ExpectQuery("^SELECT \\* FROM `test_model` WHERE `id` = \\?;$").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "Tom"))
+
+			_, err = orm.NewSelector[TestModel](db).
+				Where(orm.C("Id").EQ(1)).Get(tc.ctx)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantSQL, gotSQL)
+			assert.Equal(t, []any{1}, gotArgs)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestMiddlewareBuilder_LogFuncWithCtx(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() { _ = mockDB.Close() }()
+	var gotSQL, gotRequestID string
+	db, err := orm.OpenDB(mockDB, orm.DBWithMiddleware(NewBuilder().
+		LogFuncWithCtx(func(ctx context.Context, sql string, args []any) {
+			gotSQL, gotRequestID = sql, requestid.FromContext(ctx)
+		}).Build()))
+	require.NoError(t, err)
+	mock.ExpectQuery("^SELECT \\* FROM `test_model` WHERE `id` = \\?;$").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "Tom"))
+
+	_, err = orm.NewSelector[TestModel](db).
+		Where(orm.C("Id").EQ(1)).Get(requestid.NewContext(context.Background(), "req-1"))
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM `test_model` WHERE `id` = ?;", gotSQL)
+	assert.Equal(t, "req-1", gotRequestID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
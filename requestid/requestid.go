@@ -0,0 +1,36 @@
+// Package requestid 在 web, orm 和 micro 之间传递请求 ID，
+// 这样同一个请求的 HTTP 日志、RPC 调用和 SQL 日志都可以用一个 ID 串起来
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+const (
+	// HeaderName HTTP 请求和响应里面的头部
+	HeaderName = "X-Request-ID"
+	// MetaKey RPC 请求的元数据里面的 key
+	MetaKey = "request-id"
+)
+
+type ctxKey struct{}
+
+// NewContext 把请求 ID 放到 ctx 里面
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext 拿到 ctx 里面的请求 ID，没有的话返回空字符串
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+// New 生成一个新的请求 ID，32 个字符的十六进制字符串
+func New() string {
+	data := make([]byte, 16)
+	_, _ = rand.Read(data)
+	return hex.EncodeToString(data)
+}
@@ -0,0 +1,18 @@
+package requestid
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	id1, id2 := New(), New()
+	assert.Len(t, id1, 32)
+	assert.NotEqual(t, id1, id2)
+}
+
+func TestFromContext(t *testing.T) {
+	assert.Equal(t, "", FromContext(context.Background()))
+	assert.Equal(t, "req-1", FromContext(NewContext(context.Background(), "req-1")))
+}
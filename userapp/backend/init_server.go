@@ -1,14 +1,18 @@
 package main
 
 import (
+	limiter "gitee.com/geektime-geekbang/geektime-go/micro/ratelimit"
 	"gitee.com/geektime-geekbang/geektime-go/web"
 	"gitee.com/geektime-geekbang/geektime-go/web/middleware/accesslog"
 	"gitee.com/geektime-geekbang/geektime-go/web/middleware/cors"
 	"gitee.com/geektime-geekbang/geektime-go/web/middleware/opentelemetry"
 	"gitee.com/geektime-geekbang/geektime-go/web/middleware/prometheus"
+	"gitee.com/geektime-geekbang/geektime-go/web/middleware/ratelimit"
 	"gitee.com/geektime-geekbang/geektime-go/web/middleware/recovery"
+	"gitee.com/geektime-geekbang/geektime-go/web/middleware/requestid"
 	"go.uber.org/zap"
 	"net/http"
+	"time"
 )
 
 func initSever() *web.HTTPServer {
@@ -19,10 +23,10 @@ func initSever() *web.HTTPServer {
 	// server.UseAny("/vip", func(next web.HandleFunc) web.HandleFunc {
 	//
 	// })
-	//
-	// server.UseAny("/login", func(next web.HandleFunc) web.HandleFunc {
-	// 	// 针对登录的限流
-	// })
+
+	// 针对登录的限流，同一个 IP 一分钟内最多尝试登录 10 次，防止暴力破解密码
+	server.UseAny("/login", ratelimit.NewBuilder(
+		limiter.NewSlideWindowKeyLimiter(10, time.Minute)).Build())
 
 	// 这三个其实不太好确定谁先谁后，你们可以自己琢磨一下自己
 	server.UseAny("/*",
@@ -39,6 +43,8 @@ func initSever() *web.HTTPServer {
 		// 		ctx.RespStatusCode = 200
 		// 	}
 		// },
+		// 请求 ID 要放在最前面，这样后面的日志都能拿到
+		requestid.NewBuilder().Build(),
 		opentelemetry.MiddlewareBuilder{}.Build(),
 		accesslog.NewBuilder().LogFunc(func(accessLog string) {
 			zap.L().Info(accessLog)
@@ -51,6 +57,8 @@ func initSever() *web.HTTPServer {
 					// 发生 panic 的时候，可能都还没到路由查找那里
 					zap.String("route", ctx.MatchedRoute))
 			},
+			// 把 panic 记录到链路追踪里面
+			Hook: recovery.TraceHook,
 		}.Build(),
 		prometheus.MiddlewareBuilder{
 			Name: "userapp",
@@ -59,6 +67,13 @@ func initSever() *web.HTTPServer {
 			// ConstLabels: map[string]string{},
 			Help: "userapp 的 web 统计",
 		}.Build(),
-		cors.MiddlewareBuilder{}.Build())
+		cors.MiddlewareBuilder{
+			Policy: cors.Policy{
+				// 前端的开发服务器
+				AllowOrigins:     []string{"http://localhost:8080"},
+				AllowCredentials: true,
+				MaxAge:           10 * time.Minute,
+			},
+		}.Build())
 	return server
 }
\ No newline at end of file
@@ -6,7 +6,6 @@ import (
 	"gitee.com/geektime-geekbang/geektime-go/userapp/backend/internal/service"
 	"gitee.com/geektime-geekbang/geektime-go/web"
 	"gitee.com/geektime-geekbang/geektime-go/web/session"
-	"github.com/google/uuid"
 	"go.uber.org/zap"
 	"net/http"
 	"strconv"
@@ -79,9 +78,8 @@ func LoginV1(service service.UserService, sessMrg session.Manager) web.HandleFun
 			return
 		}
 		// 准备 session 了
-		// session id 我们使用 uuid 就好了
-		// 实际中你可以考虑将一些前端信息编码
-		sess, err := h.sessMgr.InitSession(ctx, uuid.New().String())
+		// 登录成功之后一定要换一个 session id，防止 session 固定攻击
+		sess, err := h.sessMgr.LoginSession(ctx)
 		if err != nil {
 			zap.L().Error("登录失败，初始化 session 失败", zap.Error(err))
 			_ = ctx.RespJSON(http.StatusInternalServerError, Resp{
@@ -136,9 +134,8 @@ func Login(ctx *web.Context) {
 		return
 	}
 	// 准备 session 了
-	// session id 我们使用 uuid 就好了
-	// 实际中你可以考虑将一些前端信息编码
-	sess, err := h.sessMgr.InitSession(ctx, uuid.New().String())
+	// 登录成功之后一定要换一个 session id，防止 session 固定攻击
+	sess, err := h.sessMgr.LoginSession(ctx)
 	if err != nil {
 		zap.L().Error("登录失败，初始化 session 失败", zap.Error(err))
 		_ = ctx.RespJSON(http.StatusInternalServerError, Resp{
@@ -192,9 +189,8 @@ func (h *UserHandler) Login(ctx *web.Context) {
 		return
 	}
 	// 准备 session 了
-	// session id 我们使用 uuid 就好了
-	// 实际中你可以考虑将一些前端信息编码
-	sess, err := h.sessMgr.InitSession(ctx, uuid.New().String())
+	// 登录成功之后一定要换一个 session id，防止 session 固定攻击
+	sess, err := h.sessMgr.LoginSession(ctx)
 	if err != nil {
 		zap.L().Error("登录失败，初始化 session 失败", zap.Error(err))
 		_ = ctx.RespJSON(http.StatusInternalServerError, Resp{
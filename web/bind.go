@@ -0,0 +1,243 @@
+package web
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultMultipartMemory 解析 multipart 表单的时候，最多使用多少内存，超过的部分会写入临时文件
+const defaultMultipartMemory = 32 << 20
+
+var (
+	errBindNotPtrToStruct = errors.New("web: Bind 只支持指向结构体的指针")
+
+	fileHeaderType = reflect.TypeOf((*multipart.FileHeader)(nil))
+	durationType   = reflect.TypeOf(time.Duration(0))
+	timeType       = reflect.TypeOf(time.Time{})
+)
+
+// Bind 将请求的数据绑定到 val 上，val 必须是指向结构体的指针
+// 1. 首先根据 Content-Type 解析请求体，支持 JSON, XML, form-urlencoded, multipart
+// 2. 然后根据字段上的标签，从不同的地方取数据：
+//   - path:"id" 路径参数
+//   - query:"page" 查询参数
+//   - form:"name" 表单，包括查询参数。类型为 *multipart.FileHeader 的字段会被绑定为上传的文件
+//   - header:"X-Token" 请求头
+//   - json 和 xml 标签交给对应的解码器处理
+// 3. 最后根据 validate 标签进行校验，例如 validate:"required,min=1"
+//
+// 绑定失败或者校验失败的时候，返回的是 FieldErrors，可以直接作为 400 响应返回给前端
+// 请求体解析失败的时候，FieldError 的 Rule 是 body；
+// 如果能够知道是哪个字段出错，例如 JSON 的类型不对，那么 Field 就是 JSON 里面的字段名
+// 只有 val 不是指向结构体的指针的时候，返回的才不是 FieldErrors
+func (c *Context) Bind(val any) error {
+	rv := reflect.ValueOf(val)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errBindNotPtrToStruct
+	}
+	if err := c.bindBody(val); err != nil {
+		fe := &FieldError{Rule: "body", Msg: err.Error()}
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			fe.Field = typeErr.Field
+		}
+		return FieldErrors{fe}
+	}
+	var errs FieldErrors
+	c.bindFields(rv.Elem(), &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return Validate(val)
+}
+
+// bindBody 根据 Content-Type 选择解码器
+func (c *Context) bindBody(val any) error {
+	if c.Req.Body == nil || c.Req.Body == http.NoBody {
+		return nil
+	}
+	contentType := c.Req.Header.Get("Content-Type")
+	if contentType == "" {
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("web: 非法的 Content-Type %s, %w", contentType, err)
+	}
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return json.NewDecoder(c.Req.Body).Decode(val)
+	case mediaType == "application/xml" || mediaType == "text/xml" ||
+		strings.HasSuffix(mediaType, "+xml"):
+		return xml.NewDecoder(c.Req.Body).Decode(val)
+	case mediaType == "application/x-www-form-urlencoded":
+		return c.Req.ParseForm()
+	case mediaType == "multipart/form-data":
+		return c.Req.ParseMultipartForm(defaultMultipartMemory)
+	}
+	return nil
+}
+
+func (c *Context) bindFields(rv reflect.Value, errs *FieldErrors) {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		ft := rt.Field(i)
+		fv := rv.Field(i)
+		if !ft.IsExported() {
+			continue
+		}
+		// 组合的结构体，把它的字段当成自己的字段
+		if ft.Anonymous && ft.Type.Kind() == reflect.Struct {
+			c.bindFields(fv, errs)
+			continue
+		}
+		for _, source := range []string{"path", "query", "form", "header"} {
+			key, ok := ft.Tag.Lookup(source)
+			if !ok || key == "" || key == "-" {
+				continue
+			}
+			if source == "form" && ft.Type == fileHeaderType {
+				if err := c.bindFile(key, fv); err != nil {
+					*errs = append(*errs, &FieldError{Field: ft.Name, Rule: source, Msg: err.Error()})
+				}
+				continue
+			}
+			vals, ok := c.lookup(source, key)
+			if !ok {
+				continue
+			}
+			if err := setValue(fv, vals); err != nil {
+				*errs = append(*errs, &FieldError{Field: ft.Name, Rule: source, Param: key, Msg: err.Error()})
+			}
+		}
+	}
+}
+
+// lookup 从不同的地方查找数据
+func (c *Context) lookup(source string, key string) ([]string, bool) {
+	switch source {
+	case "path":
+		val, ok := c.PathParams[key]
+		if !ok {
+			return nil, false
+		}
+		return []string{val}, true
+	case "query":
+		if c.cacheQueryValues == nil {
+			c.cacheQueryValues = c.Req.URL.Query()
+		}
+		vals, ok := c.cacheQueryValues[key]
+		return vals, ok
+	case "form":
+		if c.Req.Form == nil {
+			if err := c.Req.ParseForm(); err != nil {
+				return nil, false
+			}
+		}
+		vals, ok := c.Req.Form[key]
+		return vals, ok
+	case "header":
+		vals := c.Req.Header.Values(key)
+		return vals, len(vals) > 0
+	}
+	return nil, false
+}
+
+func (c *Context) bindFile(key string, fv reflect.Value) error {
+	if c.Req.MultipartForm == nil {
+		// 不是 multipart 请求，那么就没有文件可以绑定
+		return nil
+	}
+	fhs := c.Req.MultipartForm.File[key]
+	if len(fhs) == 0 {
+		return nil
+	}
+	fv.Set(reflect.ValueOf(fhs[0]))
+	return nil
+}
+
+// setValue 将字符串转化为字段的类型
+// 支持基本类型，time.Duration, time.Time(RFC3339)，以及它们的指针和切片
+func setValue(fv reflect.Value, vals []string) error {
+	if fv.Kind() == reflect.Pointer {
+		elem := reflect.New(fv.Type().Elem())
+		if err := setValue(elem.Elem(), vals); err != nil {
+			return err
+		}
+		fv.Set(elem)
+		return nil
+	}
+	if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 {
+		slice := reflect.MakeSlice(fv.Type(), len(vals), len(vals))
+		for i, val := range vals {
+			if err := setValue(slice.Index(i), []string{val}); err != nil {
+				return err
+			}
+		}
+		fv.Set(slice)
+		return nil
+	}
+	if len(vals) == 0 {
+		return nil
+	}
+	val := vals[0]
+	switch fv.Type() {
+	case durationType:
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	case timeType:
+		t, err := time.Parse(time.RFC3339, val)
+		if err != nil {
+			return err
+		}
+		fv.Set(reflect.ValueOf(t))
+		return nil
+	}
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(val)
+	case reflect.Slice:
+		// []byte
+		fv.SetBytes([]byte(val))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(val, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(val, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(val, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	default:
+		return fmt.Errorf("web: 不支持绑定的类型 %s", fv.Type())
+	}
+	return nil
+}
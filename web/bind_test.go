@@ -0,0 +1,248 @@
+package web
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type bindUser struct {
+	ID      int64         `path:"id"`
+	Page    int           `query:"page" validate:"omitempty,min=1"`
+	Tags    []string      `query:"tag"`
+	Token   string        `header:"X-Token" validate:"required"`
+	Name    string        `json:"name" xml:"name" form:"name" validate:"required,max=8"`
+	Age     *uint8        `json:"age" xml:"age" form:"age"`
+	Timeout time.Duration `query:"timeout"`
+	Gender  string        `json:"gender" xml:"gender" form:"gender" validate:"omitempty,oneof=male female"`
+}
+
+func TestContext_Bind(t *testing.T) {
+	age := uint8(18)
+	testCases := []struct {
+		name    string
+		req     func() *http.Request
+		params  map[string]string
+		wantVal *bindUser
+		wantErr error
+	}{
+		{
+			name: "json",
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost,
+					"/user/123?page=2&tag=a&tag=b&timeout=3s",
+					strings.NewReader(`{"name":"Tom","age":18,"gender":"male"}`))
+				req.Header.Set("Content-Type", "application/json; charset=utf-8")
+				req.Header.Set("X-Token", "abc")
+				return req
+			},
+			params: map[string]string{"id": "123"},
+			wantVal: &bindUser{
+				ID: 123, Page: 2, Tags: []string{"a", "b"}, Token: "abc",
+				Name: "Tom", Age: &age, Timeout: 3 * time.Second, Gender: "male",
+			},
+		},
+		{
+			name: "xml",
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/user/123",
+					strings.NewReader(`<bindUser><name>Tom</name><age>18</age></bindUser>`))
+				req.Header.Set("Content-Type", "application/xml")
+				req.Header.Set("X-Token", "abc")
+				return req
+			},
+			params:  map[string]string{"id": "123"},
+			wantVal: &bindUser{ID: 123, Token: "abc", Name: "Tom", Age: &age},
+		},
+		{
+			name: "form",
+			req: func() *http.Request {
+				form := url.Values{"name": []string{"Tom"}, "age": []string{"18"}}
+				req := httptest.NewRequest(http.MethodPost, "/user/123",
+					strings.NewReader(form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				req.Header.Set("X-Token", "abc")
+				return req
+			},
+			params:  map[string]string{"id": "123"},
+			wantVal: &bindUser{ID: 123, Token: "abc", Name: "Tom", Age: &age},
+		},
+		{
+			name: "type error",
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/user/abc?page=abc", nil)
+				req.Header.Set("X-Token", "abc")
+				return req
+			},
+			params: map[string]string{"id": "abc"},
+			wantErr: FieldErrors{
+				{Field: "ID", Rule: "path", Param: "id",
+					Msg: `strconv.ParseInt: parsing "abc": invalid syntax`},
+				{Field: "Page", Rule: "query", Param: "page",
+					Msg: `strconv.ParseInt: parsing "abc": invalid syntax`},
+			},
+		},
+		{
+			name: "validate error",
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/user/123?page=-1",
+					strings.NewReader(`{"name":"Tom and Jerry","gender":"unknown"}`))
+				req.Header.Set("Content-Type", "application/json")
+				return req
+			},
+			params: map[string]string{"id": "123"},
+			wantErr: FieldErrors{
+				{Field: "Page", Rule: "min", Param: "1", Msg: "值不能小于 1"},
+				{Field: "Token", Rule: "required", Msg: "不能为空"},
+				{Field: "Name", Rule: "max", Param: "8", Msg: "长度不能大于 8"},
+				{Field: "Gender", Rule: "oneof", Param: "male female", Msg: "必须是 [male female] 其中之一"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := &Context{Req: tc.req(), PathParams: tc.params}
+			u := &bindUser{}
+			err := ctx.Bind(u)
+			assert.Equal(t, tc.wantErr, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, tc.wantVal, u)
+		})
+	}
+}
+
+func TestContext_Bind_Multipart(t *testing.T) {
+	type uploadReq struct {
+		Name   string                `form:"name" validate:"required"`
+		Avatar *multipart.FileHeader `form:"avatar"`
+	}
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	require.NoError(t, writer.WriteField("name", "Tom"))
+	fw, err := writer.CreateFormFile("avatar", "avatar.png")
+	require.NoError(t, err)
+	_, err = fw.Write([]byte("png"))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	ctx := &Context{Req: req}
+	val := &uploadReq{}
+	require.NoError(t, ctx.Bind(val))
+	assert.Equal(t, "Tom", val.Name)
+	require.NotNil(t, val.Avatar)
+	assert.Equal(t, "avatar.png", val.Avatar.Filename)
+}
+
+func TestContext_Bind_BodyError(t *testing.T) {
+	testCases := []struct {
+		name        string
+		contentType string
+		body        string
+		wantField   string
+	}{
+		{
+			name:        "invalid json",
+			contentType: "application/json",
+			body:        `{"name":`,
+		},
+		{
+			name:        "json type error",
+			contentType: "application/json",
+			body:        `{"name":"Tom","age":"abc"}`,
+			wantField:   "age",
+		},
+		{
+			name:        "invalid xml",
+			contentType: "application/xml",
+			body:        `<bindUser><name>Tom</bindUser>`,
+		},
+		{
+			name:        "invalid content type",
+			contentType: "application/json; charset",
+			body:        `{}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/user/123", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			ctx := &Context{Req: req}
+			err := ctx.Bind(&bindUser{})
+			var errs FieldErrors
+			require.ErrorAs(t, err, &errs)
+			require.Len(t, errs, 1)
+			assert.Equal(t, "body", errs[0].Rule)
+			assert.Equal(t, tc.wantField, errs[0].Field)
+			assert.NotEmpty(t, errs[0].Msg)
+		})
+	}
+}
+
+func TestContext_Bind_NotPtrToStruct(t *testing.T) {
+	ctx := &Context{Req: httptest.NewRequest(http.MethodGet, "/", nil)}
+	assert.Equal(t, errBindNotPtrToStruct, ctx.Bind(bindUser{}))
+	var i int
+	assert.Equal(t, errBindNotPtrToStruct, ctx.Bind(&i))
+}
+
+func TestValidate(t *testing.T) {
+	type address struct {
+		City string `validate:"required"`
+	}
+	type user struct {
+		Emails  []string `validate:"len=2"`
+		Address address
+		Home    *address
+	}
+	err := Validate(user{Emails: []string{"a"}, Home: &address{}})
+	assert.Equal(t, FieldErrors{
+		{Field: "Emails", Rule: "len", Param: "2", Msg: "长度必须是 2"},
+		{Field: "Address.City", Rule: "required", Msg: "不能为空"},
+		{Field: "Home.City", Rule: "required", Msg: "不能为空"},
+	}, err)
+
+	assert.NoError(t, Validate(&user{
+		Emails:  []string{"a", "b"},
+		Address: address{City: "Shanghai"},
+	}))
+}
+
+func TestValidate_ZeroValue(t *testing.T) {
+	type query struct {
+		Page   int     `validate:"min=1"`
+		Code   string  `validate:"len=3"`
+		Gender string  `validate:"oneof=male female"`
+		Size   int     `validate:"omitempty,min=10"`
+		Limit  *int    `validate:"min=1"`
+		Offset *int    `validate:"required"`
+		Sort   string  `validate:"omitempty,oneof=asc desc"`
+		Score  float64 `validate:"max=100"`
+	}
+	err := Validate(query{})
+	assert.Equal(t, FieldErrors{
+		{Field: "Page", Rule: "min", Param: "1", Msg: "值不能小于 1"},
+		{Field: "Code", Rule: "len", Param: "3", Msg: "长度必须是 3"},
+		{Field: "Gender", Rule: "oneof", Param: "male female", Msg: "必须是 [male female] 其中之一"},
+		{Field: "Offset", Rule: "required", Msg: "不能为空"},
+	}, err)
+
+	zero := 0
+	err = Validate(query{Page: 1, Code: "abc", Gender: "male", Size: 1, Limit: &zero, Offset: &zero, Sort: "random"})
+	assert.Equal(t, FieldErrors{
+		{Field: "Size", Rule: "min", Param: "10", Msg: "值不能小于 10"},
+		{Field: "Limit", Rule: "min", Param: "1", Msg: "值不能小于 1"},
+		{Field: "Sort", Rule: "oneof", Param: "asc desc", Msg: "必须是 [asc desc] 其中之一"},
+	}, err)
+}
@@ -6,8 +6,11 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 )
 
+var errKeyNotFound = errors.New("web: 找不到这个 key")
+
 type Context struct {
 	Req  *http.Request
 	// Resp 原生的 ResponseWriter。当你直接使用 Resp 的时候，
@@ -30,12 +33,72 @@ type Context struct {
 
 	// 页面渲染的引擎
 	tplEngine TemplateEngine
+	// Middleware 添加的模板数据，例如 CSRF token
+	tplValues map[string]any
 
 	// 用户可以自由决定在这里存储什么，
 	// 主要用于解决在不同 Middleware 之间数据传递的问题
 	// 但是要注意
 	// 1. UserValues 在初始状态的时候总是 nil，你需要自己手动初始化
 	UserValues map[string]any
+
+	// 开启了流式响应之后才不为 nil
+	stream *StreamWriter
+	// 升级成 WebSocket 之后，连接已经被 Hijack 了，不能再写响应
+	hijacked bool
+	// 通过 Clone 复制出来的 Context，不能开启流式响应，也不能升级成 WebSocket
+	detached bool
+}
+
+// Reset 重置 Context，以便复用
+// 所有和请求相关的字段都会被清空
+func (c *Context) Reset() {
+	c.Req = nil
+	c.Resp = nil
+	c.RespStatusCode = 0
+	// 这里不能复用 RespData 的底层数组，
+	// 因为用户可能在别的地方持有了它，例如缓存起来了
+	c.RespData = nil
+	c.PathParams = nil
+	c.MatchedRoute = ""
+	c.cacheQueryValues = nil
+	c.tplEngine = nil
+	c.tplValues = nil
+	c.UserValues = nil
+	c.stream = nil
+	c.hijacked = false
+	c.detached = false
+}
+
+// Clone 复制一份 Context，用 req 和 resp 替换掉原本的 Req 和 Resp
+// 主要用于在另外一个 goroutine 上执行后续的 handler，例如超时控制。
+// 所有的 map 都会复制一份，修改复制出来的 Context 不会影响原本的 Context。
+// 复制出来的 Context 不能开启流式响应，也不能升级成 WebSocket，
+// 因为它的 Resp 不是真正的连接，这时候 Stream、SSE 和 Upgrade 会返回 error
+func (c *Context) Clone(req *http.Request, resp http.ResponseWriter) *Context {
+	return &Context{
+		Req:            req,
+		Resp:           resp,
+		RespStatusCode: c.RespStatusCode,
+		RespData:       append([]byte(nil), c.RespData...),
+		PathParams:     cloneMap(c.PathParams),
+		MatchedRoute:   c.MatchedRoute,
+		tplEngine:      c.tplEngine,
+		tplValues:      cloneMap(c.tplValues),
+		UserValues:     cloneMap(c.UserValues),
+		detached:       true,
+	}
+}
+
+func cloneMap[T any](m map[string]T) map[string]T {
+	if m == nil {
+		return nil
+	}
+	res := make(map[string]T, len(m))
+	for k, v := range m {
+		res[k] = v
+	}
+	return res
 }
 
 func (c *Context) Redirect(url string) {
@@ -65,21 +128,45 @@ func (c *Context) FormValue(key string) StringValue{
 	return StringValue{val: c.Req.FormValue(key)}
 }
 
+// FormValues 返回表单中 key 对应的所有值，包括查询参数
+func (c *Context) FormValues(key string) StringValues {
+	if err := c.Req.ParseForm(); err != nil {
+		return StringValues{err: err}
+	}
+	vals, ok := c.Req.Form[key]
+	if !ok {
+		return StringValues{err: errKeyNotFound}
+	}
+	return StringValues{vals: vals}
+}
+
 func (c *Context) QueryValue(key string) StringValue {
 	if c.cacheQueryValues == nil {
 		c.cacheQueryValues = c.Req.URL.Query()
 	}
 	vals, ok := c.cacheQueryValues[key]
 	if !ok {
-		return StringValue{err: errors.New("web: 找不到这个 key")}
+		return StringValue{err: errKeyNotFound}
 	}
 	return StringValue{val: vals[0]}
 }
 
+// QueryValues 返回查询参数中 key 对应的所有值，例如 ?id=1&id=2
+func (c *Context) QueryValues(key string) StringValues {
+	if c.cacheQueryValues == nil {
+		c.cacheQueryValues = c.Req.URL.Query()
+	}
+	vals, ok := c.cacheQueryValues[key]
+	if !ok {
+		return StringValues{err: errKeyNotFound}
+	}
+	return StringValues{vals: vals}
+}
+
 func (c *Context) PathValue(key string) StringValue {
 	val, ok := c.PathParams[key]
 	if !ok {
-		return StringValue{err: errors.New("web: 找不到这个 key")}
+		return StringValue{err: errKeyNotFound}
 	}
 	return StringValue{val: val}
 }
@@ -117,7 +204,7 @@ func (c *Context) RespOk(msg string) error {
 }
 func (c *Context) Render(tpl string, data any) error {
 	var err error
-	c.RespData, err = c.tplEngine.Render(c.Req.Context(), tpl, data)
+	c.RespData, err = c.tplEngine.Render(c.Req.Context(), tpl, c.mergeTplValues(data))
 	c.RespStatusCode = 200
 	if err != nil {
 		c.RespStatusCode = 500
@@ -125,6 +212,38 @@ func (c *Context) Render(tpl string, data any) error {
 	return err
 }
 
+// AddTplValue 添加一个所有模板都能访问的数据，一般是 Middleware 用的，例如 CSRF token
+// 只有 Render 的 data 是 map[string]any 或者 nil 的时候才会生效，并且 data 里面同名的 key 优先
+func (c *Context) AddTplValue(key string, val any) {
+	if c.tplValues == nil {
+		c.tplValues = make(map[string]any, 2)
+	}
+	c.tplValues[key] = val
+}
+
+func (c *Context) mergeTplValues(data any) any {
+	if len(c.tplValues) == 0 {
+		return data
+	}
+	var m map[string]any
+	switch val := data.(type) {
+	case nil:
+	case map[string]any:
+		m = val
+	default:
+		return data
+	}
+	// 不修改用户传进来的 map
+	res := make(map[string]any, len(c.tplValues)+len(m))
+	for k, v := range c.tplValues {
+		res[k] = v
+	}
+	for k, v := range m {
+		res[k] = v
+	}
+	return res
+}
+
 // func (c *Context) QueryValueAsInt64(key string) (int64, error) {
 // 	val, err := c.QueryValue(key)
 // 	if err != nil {
@@ -156,6 +275,111 @@ func (s StringValue) ToUInt64() (uint64, error) {
 	return strconv.ParseUint(s.val, 10, 64)
 }
 
+// ToBool 支持 1, t, T, TRUE, true, True, 0, f, F, FALSE, false, False
+func (s StringValue) ToBool() (bool, error) {
+	if s.err != nil {
+		return false, s.err
+	}
+	return strconv.ParseBool(s.val)
+}
+
+func (s StringValue) ToFloat64() (float64, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	return strconv.ParseFloat(s.val, 64)
+}
+
+// ToTime 按照 layout 解析时间，例如 time.RFC3339, "2006-01-02"
+func (s StringValue) ToTime(layout string) (time.Time, error) {
+	if s.err != nil {
+		return time.Time{}, s.err
+	}
+	return time.Parse(layout, s.val)
+}
+
+// ToDuration 解析时间间隔，例如 300ms, 1h30m
+func (s StringValue) ToDuration() (time.Duration, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	return time.ParseDuration(s.val)
+}
+
+// Or 在找不到 key 或者值为空字符串的时候，使用默认值 def
+// 例如 ctx.QueryValue("page").Or("1").ToInt64()
+// 注意，如果值存在但是转换失败，那么转换的时候依旧会返回错误；
+// 别的错误，例如解析表单失败，也不会被默认值掩盖
+func (s StringValue) Or(def string) StringValue {
+	if s.err == errKeyNotFound || (s.err == nil && s.val == "") {
+		return StringValue{val: def}
+	}
+	return s
+}
+
+// StringValues 代表同一个 key 对应的多个值
+type StringValues struct {
+	vals []string
+	err  error
+}
+
+func (s StringValues) Strings() ([]string, error) {
+	return s.vals, s.err
+}
+
+func (s StringValues) ToInt64s() ([]int64, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	res := make([]int64, 0, len(s.vals))
+	for _, val := range s.vals {
+		i, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, i)
+	}
+	return res, nil
+}
+
+func (s StringValues) ToUInt64s() ([]uint64, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	res := make([]uint64, 0, len(s.vals))
+	for _, val := range s.vals {
+		i, err := strconv.ParseUint(val, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, i)
+	}
+	return res, nil
+}
+
+func (s StringValues) ToFloat64s() ([]float64, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	res := make([]float64, 0, len(s.vals))
+	for _, val := range s.vals {
+		f, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, f)
+	}
+	return res, nil
+}
+
+// Or 在找不到 key 的时候，使用默认值 defs，别的错误会原样保留
+func (s StringValues) Or(defs ...string) StringValues {
+	if s.err == errKeyNotFound || (s.err == nil && len(s.vals) == 0) {
+		return StringValues{vals: defs}
+	}
+	return s
+}
+
 // 不能用泛型
 // func (s StringValue) To[T any]() (T, error) {
 //
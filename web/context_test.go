@@ -0,0 +1,268 @@
+package web
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestContext_Reset(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/user/123?name=Tom", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := &Context{
+		Req:              req,
+		Resp:             httptest.NewRecorder(),
+		RespStatusCode:   http.StatusOK,
+		RespData:         []byte("hello"),
+		PathParams:       map[string]string{"id": "123"},
+		MatchedRoute:     "/user/:id",
+		cacheQueryValues: url.Values{"name": []string{"Tom"}},
+		tplEngine:        &GoTemplateEngine{},
+		UserValues:       map[string]any{"key": "value"},
+		tplValues:        map[string]any{"csrf": "token"},
+	}
+	ctx.Reset()
+	assert.Equal(t, &Context{}, ctx)
+}
+
+func TestContext_Clone(t *testing.T) {
+	ctx := &Context{
+		Req:              httptest.NewRequest(http.MethodGet, "/user/123?name=Tom", nil),
+		Resp:             httptest.NewRecorder(),
+		RespStatusCode:   http.StatusOK,
+		RespData:         []byte("hello"),
+		PathParams:       map[string]string{"id": "123"},
+		MatchedRoute:     "/user/:id",
+		cacheQueryValues: url.Values{"name": []string{"Tom"}},
+		tplEngine:        &GoTemplateEngine{},
+		UserValues:       map[string]any{"key": "value"},
+		tplValues:        map[string]any{"csrf": "token"},
+		stream:           &StreamWriter{},
+		hijacked:         true,
+	}
+	req := httptest.NewRequest(http.MethodGet, "/user/123?name=Jerry", nil)
+	resp := httptest.NewRecorder()
+	c := ctx.Clone(req, resp)
+	assert.Equal(t, &Context{
+		Req:            req,
+		Resp:           resp,
+		RespStatusCode: http.StatusOK,
+		RespData:       []byte("hello"),
+		PathParams:     map[string]string{"id": "123"},
+		MatchedRoute:   "/user/:id",
+		tplEngine:      &GoTemplateEngine{},
+		UserValues:     map[string]any{"key": "value"},
+		tplValues:      map[string]any{"csrf": "token"},
+		detached:       true,
+	}, c)
+
+	// 修改复制出来的 Context 不会影响原本的
+	c.RespData[0] = 'H'
+	c.PathParams["id"] = "456"
+	c.UserValues["key"] = "new value"
+	c.AddTplValue("csrf", "new token")
+	assert.Equal(t, []byte("hello"), ctx.RespData)
+	assert.Equal(t, "123", ctx.PathParams["id"])
+	assert.Equal(t, "value", ctx.UserValues["key"])
+	assert.Equal(t, "token", ctx.tplValues["csrf"])
+	// 查询参数用的是新的 Req
+	name, err := c.QueryValue("name").String()
+	require.NoError(t, err)
+	assert.Equal(t, "Jerry", name)
+
+	// 不能开启流式响应，也不能升级成 WebSocket
+	_, err = c.Stream(http.StatusOK)
+	assert.Equal(t, errCtxDetached, err)
+	_, err = c.SSE()
+	assert.Equal(t, errCtxDetached, err)
+	setWebSocketHeaders(req.Header)
+	_, err = (&WebSocketUpgrader{}).Upgrade(c)
+	assert.Equal(t, errCtxDetached, err)
+	assert.Equal(t, http.StatusInternalServerError, c.RespStatusCode)
+}
+
+func TestContext_Render(t *testing.T) {
+	tpl, err := template.New("hello").Parse(`{{.Name}}-{{.CSRFToken}}`)
+	require.NoError(t, err)
+	testCases := []struct {
+		name     string
+		data     any
+		wantResp string
+	}{
+		{
+			name:     "nil",
+			wantResp: "-abc",
+		},
+		{
+			name:     "map",
+			data:     map[string]any{"Name": "Tom"},
+			wantResp: "Tom-abc",
+		},
+		{
+			name:     "map override",
+			data:     map[string]any{"Name": "Tom", "CSRFToken": "xyz"},
+			wantResp: "Tom-xyz",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := &Context{
+				Req:       httptest.NewRequest(http.MethodGet, "/", nil),
+				tplEngine: &GoTemplateEngine{T: tpl},
+			}
+			ctx.AddTplValue("CSRFToken", "abc")
+			require.NoError(t, ctx.Render("hello", tc.data))
+			assert.Equal(t, tc.wantResp, string(ctx.RespData))
+		})
+	}
+}
+
+func TestStringValue(t *testing.T) {
+	errMock := errors.New("mock error")
+	testCases := []struct {
+		name    string
+		sv      StringValue
+		convert func(sv StringValue) (any, error)
+		wantVal any
+		wantErr error
+	}{
+		{
+			name: "bool",
+			sv:   StringValue{val: "true"},
+			convert: func(sv StringValue) (any, error) {
+				return sv.ToBool()
+			},
+			wantVal: true,
+		},
+		{
+			name: "bool error",
+			sv:   StringValue{val: "abc"},
+			convert: func(sv StringValue) (any, error) {
+				return sv.ToBool()
+			},
+			wantVal: false,
+			wantErr: &strconv.NumError{Func: "ParseBool", Num: "abc", Err: strconv.ErrSyntax},
+		},
+		{
+			name: "float64",
+			sv:   StringValue{val: "12.5"},
+			convert: func(sv StringValue) (any, error) {
+				return sv.ToFloat64()
+			},
+			wantVal: 12.5,
+		},
+		{
+			name: "time",
+			sv:   StringValue{val: "2022-10-01"},
+			convert: func(sv StringValue) (any, error) {
+				return sv.ToTime("2006-01-02")
+			},
+			wantVal: time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "duration",
+			sv:   StringValue{val: "1m30s"},
+			convert: func(sv StringValue) (any, error) {
+				return sv.ToDuration()
+			},
+			wantVal: 90 * time.Second,
+		},
+		{
+			name: "error",
+			sv:   StringValue{err: errMock},
+			convert: func(sv StringValue) (any, error) {
+				return sv.ToDuration()
+			},
+			wantVal: time.Duration(0),
+			wantErr: errMock,
+		},
+		{
+			name: "or with error",
+			sv:   StringValue{err: errKeyNotFound},
+			convert: func(sv StringValue) (any, error) {
+				return sv.Or("10").ToInt64()
+			},
+			wantVal: int64(10),
+		},
+		{
+			// 不是找不到 key 的错误，不能用默认值掩盖
+			name: "or with other error",
+			sv:   StringValue{err: errMock},
+			convert: func(sv StringValue) (any, error) {
+				return sv.Or("10").ToInt64()
+			},
+			wantVal: int64(0),
+			wantErr: errMock,
+		},
+		{
+			name: "or with empty",
+			sv:   StringValue{},
+			convert: func(sv StringValue) (any, error) {
+				return sv.Or("10").ToInt64()
+			},
+			wantVal: int64(10),
+		},
+		{
+			name: "or with value",
+			sv:   StringValue{val: "12"},
+			convert: func(sv StringValue) (any, error) {
+				return sv.Or("10").ToInt64()
+			},
+			wantVal: int64(12),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			val, err := tc.convert(tc.sv)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantVal, val)
+		})
+	}
+}
+
+func TestContext_QueryValues(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/user?id=1&id=2&name=Tom&score=1.5", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := &Context{Req: req}
+
+	ids, err := ctx.QueryValues("id").ToInt64s()
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1, 2}, ids)
+
+	uids, err := ctx.QueryValues("id").ToUInt64s()
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{1, 2}, uids)
+
+	scores, err := ctx.QueryValues("score").ToFloat64s()
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{1.5}, scores)
+
+	_, err = ctx.QueryValues("name").ToInt64s()
+	assert.Error(t, err)
+
+	_, err = ctx.QueryValues("not_exist").Strings()
+	assert.Equal(t, errKeyNotFound, err)
+
+	names, err := ctx.QueryValues("not_exist").Or("Tom", "Jerry").Strings()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Tom", "Jerry"}, names)
+
+	errParseForm := errors.New("mock parse form error")
+	_, err = StringValues{err: errParseForm}.Or("Tom").Strings()
+	assert.Equal(t, errParseForm, err)
+
+	names, err = ctx.FormValues("name").Strings()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Tom"}, names)
+}
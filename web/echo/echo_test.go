@@ -8,6 +8,8 @@ import (
 )
 
 func TestHelloWorld(t *testing.T) {
+	// 会启动服务器并且一直阻塞，手动运行的时候去掉这一行
+	t.Skip("手动运行的测试")
 	// Echo instance
 	e := echo.New()
 
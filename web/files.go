@@ -1,7 +1,6 @@
 package web
 
 import (
-	"fmt"
 	lru "github.com/hashicorp/golang-lru"
 	"io"
 	"io/ioutil"
@@ -179,25 +178,25 @@ func (h *StaticResourceHandler) Handle(ctx *Context) {
 	req, _ := ctx.PathValue("file").String()
 	if item, ok := h.readFileFromData(req); ok {
 		log.Printf("从缓存中读取数据...")
-		h.writeItemAsResponse(item, ctx.Resp)
+		h.writeItemAsResponse(item, ctx)
 		return
 	}
 	path := filepath.Join(h.dir, req)
 	f, err := os.Open(path)
 	if err != nil {
-		ctx.Resp.WriteHeader(http.StatusInternalServerError)
+		ctx.RespStatusCode = http.StatusInternalServerError
 		return
 	}
 	ext := getFileExt(f.Name())
 	t, ok := h.extensionContentTypeMap[ext]
 	if !ok {
-		ctx.Resp.WriteHeader(http.StatusBadRequest)
+		ctx.RespStatusCode = http.StatusBadRequest
 		return
 	}
 
 	data, err := ioutil.ReadAll(f)
 	if err != nil {
-		ctx.Resp.WriteHeader(http.StatusInternalServerError)
+		ctx.RespStatusCode = http.StatusInternalServerError
 		return
 	}
 	item := &fileCacheItem{
@@ -208,7 +207,7 @@ func (h *StaticResourceHandler) Handle(ctx *Context) {
 	}
 
 	h.cacheFile(item)
-	h.writeItemAsResponse(item, ctx.Resp)
+	h.writeItemAsResponse(item, ctx)
 }
 
 func (h *StaticResourceHandler) cacheFile(item *fileCacheItem) {
@@ -217,12 +216,13 @@ func (h *StaticResourceHandler) cacheFile(item *fileCacheItem) {
 	}
 }
 
-func (h *StaticResourceHandler) writeItemAsResponse(item *fileCacheItem, writer http.ResponseWriter) {
-	writer.WriteHeader(http.StatusOK)
-	writer.Header().Set("Content-Type", item.contentType)
-	writer.Header().Set("Content-Length", fmt.Sprintf("%d", item.fileSize))
-	_, _ = writer.Write(item.data)
-
+// writeItemAsResponse 通过 RespData 返回文件内容，而不是直接写 ctx.Resp，
+// 这样 Middleware 例如压缩和缓存也能作用于静态资源。
+// Content-Length 会在 flashResp 里面设置
+func (h *StaticResourceHandler) writeItemAsResponse(item *fileCacheItem, ctx *Context) {
+	ctx.Resp.Header().Set("Content-Type", item.contentType)
+	ctx.RespStatusCode = http.StatusOK
+	ctx.RespData = item.data
 }
 
 func (h *StaticResourceHandler) readFileFromData(fileName string) (*fileCacheItem, bool) {
@@ -9,6 +9,8 @@ import (
 )
 
 func TestFileUploader_Handle(t *testing.T) {
+	// 会启动服务器并且一直阻塞，手动运行的时候去掉这一行
+	t.Skip("手动运行的测试")
 	s := NewHTTPServer()
 	s.Get("/upload_page", func(ctx *Context) {
 		tpl := template.New("upload")
@@ -47,6 +49,8 @@ func TestFileUploader_Handle(t *testing.T) {
 }
 
 func TestFileDownloader_Handle(t *testing.T) {
+	// 会启动服务器并且一直阻塞，手动运行的时候去掉这一行
+	t.Skip("手动运行的测试")
 	s := NewHTTPServer()
 	s.Get("/download", (&FileDownloader{
 		// 下载的文件所在目录
@@ -57,6 +61,8 @@ func TestFileDownloader_Handle(t *testing.T) {
 }
 
 func TestStaticResourceHandler_Handle(t *testing.T) {
+	// 会启动服务器并且一直阻塞，手动运行的时候去掉这一行
+	t.Skip("手动运行的测试")
 	s := NewHTTPServer()
 	handler := NewStaticResourceHandler("./testdata/img", "/img")
 	s.Get("/img/:file", handler.Handle)
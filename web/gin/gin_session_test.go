@@ -9,6 +9,8 @@ import (
 
 
 func TestGinSession(t *testing.T) {
+	// 会启动服务器并且一直阻塞，手动运行的时候去掉这一行
+	t.Skip("手动运行的测试")
 	r := gin.Default()
 	store := cookie.NewStore([]byte("secret"))
 	r.Use(sessions.Sessions("mysession", store))
@@ -0,0 +1,89 @@
+package web
+
+import "net/http"
+
+// RouteGroup 路由分组
+// 同一个分组下的路由共享前缀 prefix，
+// 分组的 middleware 会被注册在路由树上前缀对应的节点上，
+// 所以对该前缀下所有的路由都生效，包括子分组的路由
+type RouteGroup struct {
+	server *HTTPServer
+	prefix string
+}
+
+// Group 创建一个路由分组
+// prefix 必须以 / 开头，并且不能以 / 结尾，例如 /api/v1
+func (s *HTTPServer) Group(prefix string, mdls ...Middleware) *RouteGroup {
+	return newRouteGroup(s, prefix, mdls...)
+}
+
+func newRouteGroup(s *HTTPServer, prefix string, mdls ...Middleware) *RouteGroup {
+	checkGroupPrefix(prefix)
+	if len(mdls) > 0 {
+		s.UseAny(prefix, mdls...)
+	}
+	if prefix == "/" {
+		prefix = ""
+	}
+	return &RouteGroup{
+		server: s,
+		prefix: prefix,
+	}
+}
+
+// Group 创建子分组，子分组的前缀是在当前分组的前缀后面再加上 prefix
+// 子分组的路由会先执行父分组的 middleware，再执行子分组的 middleware
+func (g *RouteGroup) Group(prefix string, mdls ...Middleware) *RouteGroup {
+	checkGroupPrefix(prefix)
+	return newRouteGroup(g.server, g.fullPath(prefix), mdls...)
+}
+
+// Use 给分组追加 middleware
+func (g *RouteGroup) Use(mdls ...Middleware) {
+	g.server.UseAny(g.fullPath("/"), mdls...)
+}
+
+func (g *RouteGroup) Handle(method string, path string, handler HandleFunc) {
+	g.server.addRoute(method, g.fullPath(path), handler)
+}
+
+func (g *RouteGroup) Get(path string, handler HandleFunc) {
+	g.Handle(http.MethodGet, path, handler)
+}
+
+func (g *RouteGroup) Post(path string, handler HandleFunc) {
+	g.Handle(http.MethodPost, path, handler)
+}
+
+func (g *RouteGroup) Put(path string, handler HandleFunc) {
+	g.Handle(http.MethodPut, path, handler)
+}
+
+func (g *RouteGroup) Delete(path string, handler HandleFunc) {
+	g.Handle(http.MethodDelete, path, handler)
+}
+
+func (g *RouteGroup) Patch(path string, handler HandleFunc) {
+	g.Handle(http.MethodPatch, path, handler)
+}
+
+// fullPath 拼接分组前缀
+// path 为 / 的时候代表的就是分组前缀本身
+func (g *RouteGroup) fullPath(path string) string {
+	if path == "/" {
+		if g.prefix == "" {
+			return "/"
+		}
+		return g.prefix
+	}
+	return g.prefix + path
+}
+
+func checkGroupPrefix(prefix string) {
+	if prefix == "" || prefix[0] != '/' {
+		panic("web: 分组前缀必须以 / 开头")
+	}
+	if prefix != "/" && prefix[len(prefix)-1] == '/' {
+		panic("web: 分组前缀不能以 / 结尾")
+	}
+}
@@ -0,0 +1,133 @@
+package web
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouteGroup(t *testing.T) {
+	var mdlBuilder = func(i byte) Middleware {
+		return func(next HandleFunc) HandleFunc {
+			return func(ctx *Context) {
+				ctx.RespData = append(ctx.RespData, i)
+				next(ctx)
+			}
+		}
+	}
+	var handlerBuilder = func(i byte) HandleFunc {
+		return func(ctx *Context) {
+			ctx.RespData = append(ctx.RespData, i)
+		}
+	}
+
+	s := NewHTTPServer()
+	s.Get("/health", handlerBuilder('h'))
+	api := s.Group("/api/v1", mdlBuilder('a'))
+	api.Get("/", handlerBuilder('r'))
+	api.Post("/login", handlerBuilder('l'))
+	user := api.Group("/user", mdlBuilder('u'))
+	user.Get("/:id", handlerBuilder('g'))
+	user.Put("/:id", handlerBuilder('p'))
+	user.Delete("/:id", handlerBuilder('d'))
+	user.Patch("/:id", handlerBuilder('t'))
+	// 分组创建之后再追加的 middleware 也要生效
+	user.Use(mdlBuilder('x'))
+	root := s.Group("/")
+	root.Get("/ping", handlerBuilder('i'))
+
+	testCases := []struct {
+		name     string
+		method   string
+		path     string
+		wantCode int
+		wantResp string
+	}{
+		{
+			name:     "no group",
+			method:   http.MethodGet,
+			path:     "/health",
+			wantCode: http.StatusOK,
+			wantResp: "h",
+		},
+		{
+			name:     "group root",
+			method:   http.MethodGet,
+			path:     "/api/v1",
+			wantCode: http.StatusOK,
+			wantResp: "ar",
+		},
+		{
+			name:     "group",
+			method:   http.MethodPost,
+			path:     "/api/v1/login",
+			wantCode: http.StatusOK,
+			wantResp: "al",
+		},
+		{
+			name:     "nested get",
+			method:   http.MethodGet,
+			path:     "/api/v1/user/123",
+			wantCode: http.StatusOK,
+			wantResp: "auxg",
+		},
+		{
+			name:     "nested put",
+			method:   http.MethodPut,
+			path:     "/api/v1/user/123",
+			wantCode: http.StatusOK,
+			wantResp: "auxp",
+		},
+		{
+			name:     "nested delete",
+			method:   http.MethodDelete,
+			path:     "/api/v1/user/123",
+			wantCode: http.StatusOK,
+			wantResp: "auxd",
+		},
+		{
+			name:     "nested patch",
+			method:   http.MethodPatch,
+			path:     "/api/v1/user/123",
+			wantCode: http.StatusOK,
+			wantResp: "auxt",
+		},
+		{
+			name:     "root group",
+			method:   http.MethodGet,
+			path:     "/ping",
+			wantCode: http.StatusOK,
+			wantResp: "i",
+		},
+		{
+			name:     "not found",
+			method:   http.MethodGet,
+			path:     "/api/v1/order",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(tc.method, tc.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			assert.Equal(t, tc.wantResp, recorder.Body.String())
+		})
+	}
+
+	assert.PanicsWithValue(t, "web: 分组前缀必须以 / 开头", func() {
+		s.Group("api")
+	})
+	assert.PanicsWithValue(t, "web: 分组前缀不能以 / 结尾", func() {
+		s.Group("/api/")
+	})
+	assert.PanicsWithValue(t, "web: 分组前缀必须以 / 开头", func() {
+		api.Group("order")
+	})
+}
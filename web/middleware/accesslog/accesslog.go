@@ -1,13 +1,30 @@
 package accesslog
 
 import (
-	"encoding/json"
 	"gitee.com/geektime-geekbang/geektime-go/web"
+	"go.opentelemetry.io/otel/trace"
 	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"time"
 )
 
 type MiddlewareBuilder struct {
-	logFunc func(accessLog string)
+	logFunc   func(accessLog string)
+	formatter Formatter
+
+	// trustedProxies 只有请求是从这些代理过来的，才会相信 X-Forwarded-For 和 X-Real-IP
+	trustedProxies []*net.IPNet
+	// requestIDHeader 从哪个头部拿请求 ID，会先找响应头部，再找请求头部
+	requestIDHeader string
+
+	// sampleRate 采样率，[0, 1]，5xx 的响应总是会记录
+	sampleRate float64
+	// skipRoutes 不记录的路由，例如健康检查
+	skipRoutes map[string]struct{}
+	skipFunc   func(ctx *web.Context) bool
 }
 
 func (b *MiddlewareBuilder) LogFunc(logFunc func(accessLog string)) *MiddlewareBuilder {
@@ -15,35 +32,185 @@ func (b *MiddlewareBuilder) LogFunc(logFunc func(accessLog string)) *MiddlewareB
 	return b
 }
 
+// Formatter 设置输出格式，默认是 JSON，也可以用 Combined 和 Logfmt
+func (b *MiddlewareBuilder) Formatter(formatter Formatter) *MiddlewareBuilder {
+	b.formatter = formatter
+	return b
+}
+
+// TrustedProxies 设置可信的代理，可以是 IP 也可以是 CIDR，例如 10.0.0.0/8
+func (b *MiddlewareBuilder) TrustedProxies(proxies ...string) *MiddlewareBuilder {
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			if strings.Contains(p, ":") {
+				p = p + "/128"
+			} else {
+				p = p + "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(p)
+		if err != nil {
+			panic("accesslog: 非法的代理地址 " + p)
+		}
+		b.trustedProxies = append(b.trustedProxies, ipNet)
+	}
+	return b
+}
+
+// RequestIDHeader 设置请求 ID 的头部，默认是 X-Request-ID
+func (b *MiddlewareBuilder) RequestIDHeader(header string) *MiddlewareBuilder {
+	b.requestIDHeader = header
+	return b
+}
+
+// SampleRate 设置采样率，例如 0.1 代表只记录 10% 的请求，5xx 的响应不受影响
+func (b *MiddlewareBuilder) SampleRate(rate float64) *MiddlewareBuilder {
+	b.sampleRate = rate
+	return b
+}
+
+// Skip 不记录这些路由，用的是注册路由时候的路径，例如 /health
+func (b *MiddlewareBuilder) Skip(routes ...string) *MiddlewareBuilder {
+	for _, r := range routes {
+		b.skipRoutes[r] = struct{}{}
+	}
+	return b
+}
+
+// SkipFunc 返回 true 的请求不记录
+func (b *MiddlewareBuilder) SkipFunc(fn func(ctx *web.Context) bool) *MiddlewareBuilder {
+	b.skipFunc = fn
+	return b
+}
+
 func NewBuilder() *MiddlewareBuilder {
 	return &MiddlewareBuilder{
 		logFunc: func(accessLog string) {
 			log.Println(accessLog)
 		},
+		formatter:       JSON,
+		requestIDHeader: "X-Request-ID",
+		sampleRate:      1,
+		skipRoutes:      map[string]struct{}{},
 	}
 }
 
-type accessLog struct {
+// AccessLog 一条访问日志，自定义 Formatter 的时候使用
+type AccessLog struct {
+	// 为了兼容以前的日志格式，这几个字段的名字保持不变
 	Host       string
 	Route      string
 	HTTPMethod string `json:"http_method"`
 	Path       string
+
+	Time      time.Time     `json:"time"`
+	Query     string        `json:"query,omitempty"`
+	Proto     string        `json:"proto"`
+	Status    int           `json:"status"`
+	Size      int           `json:"size"` // 流式响应和 SSE 是实际写出去的字节数
+	Latency   time.Duration `json:"latency"`
+	ClientIP  string        `json:"client_ip"`
+	UserAgent string        `json:"user_agent,omitempty"`
+	Referer   string        `json:"referer,omitempty"`
+	RequestID string        `json:"request_id,omitempty"`
+	TraceID   string        `json:"trace_id,omitempty"`
+	SpanID    string        `json:"span_id,omitempty"`
 }
 
 func (b MiddlewareBuilder) Build() web.Middleware {
 	return func(next web.HandleFunc) web.HandleFunc {
 		return func(ctx *web.Context) {
+			start := time.Now()
 			defer func() {
-				l := accessLog{
-					Host:       ctx.Req.Host,
-					Route:      ctx.MatchedRoute,
-					Path:       ctx.Req.URL.Path,
-					HTTPMethod: ctx.Req.Method,
+				if b.skip(ctx) {
+					return
+				}
+				l := b.accessLog(ctx, start)
+				if l.Status < http.StatusInternalServerError &&
+					b.sampleRate < 1 && rand.Float64() >= b.sampleRate {
+					return
 				}
-				val, _ := json.Marshal(l)
-				b.logFunc(string(val))
+				b.logFunc(b.formatter(l))
 			}()
 			next(ctx)
 		}
 	}
 }
+
+func (b MiddlewareBuilder) skip(ctx *web.Context) bool {
+	if _, ok := b.skipRoutes[ctx.MatchedRoute]; ok && ctx.MatchedRoute != "" {
+		return true
+	}
+	return b.skipFunc != nil && b.skipFunc(ctx)
+}
+
+func (b MiddlewareBuilder) accessLog(ctx *web.Context, start time.Time) *AccessLog {
+	req := ctx.Req
+	l := &AccessLog{
+		Host:       req.Host,
+		Route:      ctx.MatchedRoute,
+		Path:       req.URL.Path,
+		HTTPMethod: req.Method,
+		Time:       start,
+		Query:      req.URL.RawQuery,
+		Proto:      req.Proto,
+		Status:     ctx.RespStatusCode,
+		Size:       ctx.RespSize(),
+		Latency:    time.Since(start),
+		ClientIP:   b.clientIP(req),
+		UserAgent:  req.UserAgent(),
+		Referer:    req.Referer(),
+	}
+	if l.Status == 0 {
+		l.Status = http.StatusOK
+	}
+	l.RequestID = ctx.Resp.Header().Get(b.requestIDHeader)
+	if l.RequestID == "" {
+		l.RequestID = req.Header.Get(b.requestIDHeader)
+	}
+	// opentelemetry 的 Middleware 会把 span 放到 ctx.Req 里面
+	if sc := trace.SpanContextFromContext(req.Context()); sc.IsValid() {
+		l.TraceID = sc.TraceID().String()
+		l.SpanID = sc.SpanID().String()
+	}
+	return l
+}
+
+// clientIP 只有在直接连过来的是可信代理的时候，才会看 X-Forwarded-For，
+// 并且从右往左找到第一个不是可信代理的 IP，否则客户端可以随便伪造
+func (b MiddlewareBuilder) clientIP(req *http.Request) string {
+	remote, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		remote = req.RemoteAddr
+	}
+	if !b.trusted(remote) {
+		return remote
+	}
+	if xff := req.Header.Get("X-Forwarded-For"); xff != "" {
+		ips := strings.Split(xff, ",")
+		for i := len(ips) - 1; i >= 0; i-- {
+			ip := strings.TrimSpace(ips[i])
+			if !b.trusted(ip) {
+				return ip
+			}
+		}
+		return strings.TrimSpace(ips[0])
+	}
+	if ip := req.Header.Get("X-Real-IP"); ip != "" {
+		return ip
+	}
+	return remote
+}
+
+func (b MiddlewareBuilder) trusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, ipNet := range b.trustedProxies {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
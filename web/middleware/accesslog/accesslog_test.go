@@ -1,12 +1,21 @@
 package accesslog
 
 import (
+	"context"
+	"fmt"
 	"gitee.com/geektime-geekbang/geektime-go/web"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
 
 func TestMiddlewareBuilder_Build(t *testing.T) {
+	// 会启动服务器并且一直阻塞，手动运行的时候去掉这一行
+	t.Skip("手动运行的测试")
 	b := NewBuilder()
 	s := web.NewHTTPServer()
 	s.Get("/", func(ctx *web.Context) {
@@ -19,3 +28,181 @@ func TestMiddlewareBuilder_Build(t *testing.T) {
 	s.UseAny("/*", b.Build())
 	s.Start(":8081")
 }
+
+func TestMiddlewareBuilder_Format(t *testing.T) {
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	spanCtx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceID,
+		SpanID:  spanID,
+	}))
+
+	testCases := []struct {
+		name    string
+		builder *MiddlewareBuilder
+		req     func() *http.Request
+
+		wantLog string
+	}{
+		{
+			name:    "json",
+			builder: NewBuilder().TrustedProxies("10.0.0.0/8"),
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/user/123?a=b", nil)
+				req.RemoteAddr = "10.0.0.1:1234"
+				req.Header.Set("X-Forwarded-For", "1.1.1.1, 2.2.2.2, 10.0.0.2")
+				req.Header.Set("User-Agent", "curl")
+				req.Header.Set("X-Request-ID", "req-1")
+				return req.WithContext(spanCtx)
+			},
+			wantLog: `{"Host":"example.com","Route":"/user/:id","http_method":"GET","Path":"/user/123",` +
+				`"time":"2022-01-01T00:00:00Z","query":"a=b","proto":"HTTP/1.1","status":200,"size":5,` +
+				`"latency":0,"client_ip":"2.2.2.2","user_agent":"curl","request_id":"req-1",` +
+				`"trace_id":"4bf92f3577b34da6a3ce929d0e0e4736","span_id":"00f067aa0ba902b7"}`,
+		},
+		{
+			// 不是可信代理过来的，X-Forwarded-For 是可以伪造的
+			name:    "untrusted proxy",
+			builder: NewBuilder().Formatter(Combined),
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/user/123?a=b", nil)
+				req.RemoteAddr = "3.3.3.3:1234"
+				req.Header.Set("X-Forwarded-For", "1.1.1.1")
+				req.Header.Set("User-Agent", "curl")
+				return req
+			},
+			wantLog: `3.3.3.3 - - [01/Jan/2022:00:00:00 +0000] "GET /user/123?a=b HTTP/1.1" 200 5 "-" "curl"`,
+		},
+		{
+			// 解码之后的路径里面有换行和引号，不能原样写到日志里面
+			name:    "combined escape",
+			builder: NewBuilder().Formatter(Combined),
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, `/user/a%0Ab%22c?a="b"`, nil)
+				req.RemoteAddr = "3.3.3.3:1234"
+				return req
+			},
+			wantLog: `3.3.3.3 - - [01/Jan/2022:00:00:00 +0000] "GET /user/a%0Ab%22c?a=%22b%22 HTTP/1.1" 200 5 "-" "-"`,
+		},
+		{
+			name:    "logfmt escape",
+			builder: NewBuilder().Formatter(Logfmt),
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/user/a%0Ab", nil)
+			},
+			wantLog: `time=2022-01-01T00:00:00.000Z host=example.com method=GET path="/user/a\nb" ` +
+				`route=/user/:id proto=HTTP/1.1 status=200 size=5 latency=0s client_ip=192.0.2.1`,
+		},
+		{
+			name:    "logfmt",
+			builder: NewBuilder().Formatter(Logfmt),
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/user/123", nil)
+				req.Header.Set("User-Agent", "Mozilla/5.0 (X11)")
+				return req
+			},
+			wantLog: `time=2022-01-01T00:00:00.000Z host=example.com method=GET path=/user/123 ` +
+				`route=/user/:id proto=HTTP/1.1 status=200 size=5 latency=0s client_ip=192.0.2.1 ` +
+				`user_agent="Mozilla/5.0 (X11)"`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var logs []string
+			// 时间是不确定的，替换掉
+			formatter := tc.builder.formatter
+			tc.builder.Formatter(func(l *AccessLog) string {
+				l.Time = time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+				l.Latency = 0
+				return formatter(l)
+			}).LogFunc(func(accessLog string) {
+				logs = append(logs, accessLog)
+			})
+			s := web.NewHTTPServer()
+			s.UseAny("/*", tc.builder.Build())
+			s.Get("/user/:id", func(ctx *web.Context) {
+				ctx.RespStatusCode = http.StatusOK
+				ctx.RespData = []byte("hello")
+			})
+			s.ServeHTTP(httptest.NewRecorder(), tc.req())
+			assert.Equal(t, []string{tc.wantLog}, logs)
+		})
+	}
+}
+
+func TestMiddlewareBuilder_Stream(t *testing.T) {
+	var logs []string
+	s := web.NewHTTPServer()
+	s.UseAny("/*", NewBuilder().Formatter(Combined).LogFunc(func(accessLog string) {
+		logs = append(logs, accessLog)
+	}).Build())
+	s.Get("/stream", func(ctx *web.Context) {
+		sw, err := ctx.Stream(http.StatusAccepted)
+		if err != nil {
+			return
+		}
+		_, _ = sw.Write([]byte("hello, "))
+		_, _ = sw.Write([]byte("world"))
+	})
+	s.Get("/events", func(ctx *web.Context) {
+		sse, err := ctx.SSE()
+		if err != nil {
+			return
+		}
+		_ = sse.Send(web.SSEEvent{ID: "1", Data: "hello"})
+		_ = sse.Send(web.SSEEvent{ID: "2", Data: "world"})
+	})
+
+	testCases := []struct {
+		name string
+		path string
+
+		wantStatus int
+	}{
+		{
+			name:       "stream",
+			path:       "/stream",
+			wantStatus: http.StatusAccepted,
+		},
+		{
+			name:       "sse",
+			path:       "/events",
+			wantStatus: http.StatusOK,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			logs = nil
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			// 流式响应没有 RespData，大小是实际写出去的字节数
+			require.Len(t, logs, 1)
+			require.NotZero(t, recorder.Body.Len())
+			assert.Contains(t, logs[0], fmt.Sprintf(`"GET %s HTTP/1.1" %d %d `,
+				tc.path, tc.wantStatus, recorder.Body.Len()))
+		})
+	}
+}
+
+func TestMiddlewareBuilder_Skip(t *testing.T) {
+	var logs []string
+	s := web.NewHTTPServer()
+	s.UseAny("/*", NewBuilder().Skip("/health").SampleRate(0).LogFunc(func(accessLog string) {
+		logs = append(logs, accessLog)
+	}).Build())
+	s.Get("/health", func(ctx *web.Context) {
+		ctx.RespStatusCode = http.StatusOK
+	})
+	s.Get("/user", func(ctx *web.Context) {
+		ctx.RespStatusCode = http.StatusOK
+	})
+	s.Get("/error", func(ctx *web.Context) {
+		ctx.RespStatusCode = http.StatusInternalServerError
+	})
+	for _, path := range []string{"/health", "/user", "/error"} {
+		s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	// 采样率是 0，只有 5xx 的会被记录下来
+	assert.Len(t, logs, 1)
+	assert.Contains(t, logs[0], `"Path":"/error"`)
+}
@@ -0,0 +1,93 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Formatter 把访问日志转化为字符串
+type Formatter func(l *AccessLog) string
+
+// JSON 格式
+func JSON(l *AccessLog) string {
+	val, _ := json.Marshal(l)
+	return string(val)
+}
+
+// Combined Apache 的 combined 格式
+// 127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /index.html HTTP/1.0" 200 2326 "http://example.com/" "Mozilla/4.08"
+// Path 是解码之后的，可能包含换行和引号，所以要重新编码，防止伪造日志
+func Combined(l *AccessLog) string {
+	uri := (&url.URL{Path: l.Path}).EscapedPath()
+	if l.Query != "" {
+		uri = uri + "?" + escapeQuery(l.Query)
+	}
+	size := "-"
+	if l.Size > 0 {
+		size = strconv.Itoa(l.Size)
+	}
+	return l.ClientIP + ` - - [` + l.Time.Format("02/Jan/2006:15:04:05 -0700") + `] "` +
+		l.HTTPMethod + " " + uri + " " + l.Proto + `" ` + strconv.Itoa(l.Status) + " " + size +
+		" " + quote(l.Referer) + " " + quote(l.UserAgent)
+}
+
+// Logfmt key=value 的格式
+func Logfmt(l *AccessLog) string {
+	var sb strings.Builder
+	write := func(key, val string) {
+		if val == "" {
+			return
+		}
+		if sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(key)
+		sb.WriteByte('=')
+		// 换行之类的控制字符也要转义，防止伪造日志
+		if q := strconv.Quote(val); q[1:len(q)-1] != val || strings.ContainsAny(val, " =") {
+			val = q
+		}
+		sb.WriteString(val)
+	}
+	write("time", l.Time.Format("2006-01-02T15:04:05.000Z07:00"))
+	write("host", l.Host)
+	write("method", l.HTTPMethod)
+	write("path", l.Path)
+	write("query", l.Query)
+	write("route", l.Route)
+	write("proto", l.Proto)
+	write("status", strconv.Itoa(l.Status))
+	write("size", strconv.Itoa(l.Size))
+	write("latency", l.Latency.String())
+	write("client_ip", l.ClientIP)
+	write("user_agent", l.UserAgent)
+	write("referer", l.Referer)
+	write("request_id", l.RequestID)
+	write("trace_id", l.TraceID)
+	write("span_id", l.SpanID)
+	return sb.String()
+}
+
+// escapeQuery RawQuery 一般已经是编码过的，但是客户端也可以直接发送引号之类的字符
+func escapeQuery(query string) string {
+	var sb strings.Builder
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		if c <= ' ' || c == '"' || c == '\\' || c >= 0x7f {
+			fmt.Fprintf(&sb, "%%%02X", c)
+			continue
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+func quote(val string) string {
+	if val == "" {
+		return `"-"`
+	}
+	return strconv.Quote(val)
+}
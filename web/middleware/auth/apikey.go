@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"gitee.com/geektime-geekbang/geektime-go/web"
+)
+
+// APIKeyVerifier API Key 认证，一般用于服务端之间的调用
+type APIKeyVerifier struct {
+	header string
+	query  string
+	// validate 返回 API Key 对应的身份信息，例如调用方的名字
+	validate func(key string) (any, bool)
+}
+
+// NewAPIKeyVerifier 默认从 X-API-Key 头部里面拿 API Key
+func NewAPIKeyVerifier(validate func(key string) (any, bool)) *APIKeyVerifier {
+	return &APIKeyVerifier{header: "X-API-Key", validate: validate}
+}
+
+// Header 设置从哪个头部拿 API Key
+func (v *APIKeyVerifier) Header(name string) *APIKeyVerifier {
+	v.header = name
+	return v
+}
+
+// Query 允许从查询参数里面拿 API Key，头部优先
+// 注意查询参数很容易出现在访问日志里面，所以默认不开启
+func (v *APIKeyVerifier) Query(name string) *APIKeyVerifier {
+	v.query = name
+	return v
+}
+
+// StaticAPIKeys 固定的 API Key，key 是 API Key，value 是身份信息
+func StaticAPIKeys(keys map[string]any) func(key string) (any, bool) {
+	return func(key string) (any, bool) {
+		// 不直接用 map 查找，而是逐个用常数时间比较，避免时序攻击
+		for k, identity := range keys {
+			if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+				return identity, true
+			}
+		}
+		return nil, false
+	}
+}
+
+func (v *APIKeyVerifier) Challenge() string {
+	return ""
+}
+
+func (v *APIKeyVerifier) Verify(ctx *web.Context) (any, error) {
+	key := ctx.Req.Header.Get(v.header)
+	if key == "" && v.query != "" {
+		key, _ = ctx.QueryValue(v.query).String()
+	}
+	if key == "" {
+		return nil, ErrNoCredential
+	}
+	identity, ok := v.validate(key)
+	if !ok {
+		return nil, ErrInvalidCredential
+	}
+	return identity, nil
+}
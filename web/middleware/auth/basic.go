@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"gitee.com/geektime-geekbang/geektime-go/web"
+	"strconv"
+)
+
+// BasicVerifier HTTP Basic 认证
+// 因为密码是明文传输的，所以一定要配合 HTTPS 使用
+type BasicVerifier struct {
+	realm    string
+	validate func(username, password string) bool
+}
+
+// NewBasicVerifier validate 校验用户名和密码
+func NewBasicVerifier(realm string, validate func(username, password string) bool) *BasicVerifier {
+	return &BasicVerifier{realm: realm, validate: validate}
+}
+
+// BasicAccounts 固定的账号密码，适合内部的管理页面，例如 /metrics
+func BasicAccounts(accounts map[string]string) func(username, password string) bool {
+	return func(username, password string) bool {
+		expected, ok := accounts[username]
+		// 比较密码的时候要用常数时间的比较，避免时序攻击
+		return ok && subtle.ConstantTimeCompare([]byte(expected), []byte(password)) == 1
+	}
+}
+
+func (v *BasicVerifier) Challenge() string {
+	return "Basic realm=" + strconv.Quote(v.realm)
+}
+
+// Verify 返回的身份信息是用户名
+func (v *BasicVerifier) Verify(ctx *web.Context) (any, error) {
+	username, password, ok := ctx.Req.BasicAuth()
+	if !ok {
+		return nil, ErrNoCredential
+	}
+	if !v.validate(username, password) {
+		return nil, ErrInvalidCredential
+	}
+	return username, nil
+}
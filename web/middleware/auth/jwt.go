@@ -0,0 +1,294 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	// 注册 crypto.SHA256, crypto.SHA384, crypto.SHA512
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gitee.com/geektime-geekbang/geektime-go/web"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken     = errors.New("auth: 非法的 JWT")
+	ErrTokenExpired     = errors.New("auth: JWT 已经过期")
+	ErrTokenNotValidYet = errors.New("auth: JWT 还没有生效")
+)
+
+// JWTClaims JWT 的 payload
+// 数字会被解析成 json.Number，避免大整数丢失精度
+type JWTClaims map[string]any
+
+// Subject 一般是用户 ID
+func (c JWTClaims) Subject() string {
+	sub, _ := c["sub"].(string)
+	return sub
+}
+
+func (c JWTClaims) time(key string) (time.Time, bool, error) {
+	val, ok := c[key]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	var sec float64
+	switch v := val.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return time.Time{}, false, ErrInvalidToken
+		}
+		sec = f
+	case float64:
+		sec = v
+	case int64:
+		sec = float64(v)
+	case int:
+		sec = float64(v)
+	default:
+		return time.Time{}, false, ErrInvalidToken
+	}
+	return time.Unix(0, int64(sec*float64(time.Second))), true, nil
+}
+
+// hashes 支持的签名算法，不支持 none
+var hashes = map[string]crypto.Hash{
+	"HS256": crypto.SHA256,
+	"HS384": crypto.SHA384,
+	"HS512": crypto.SHA512,
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+}
+
+// JWTVerifier 校验 JWT
+// HS 系列的算法使用 HMAC 密钥，RS 系列的算法使用 RSA 公钥，
+// 算法必须和密钥的类型匹配，避免攻击者把 alg 改成 HS256 然后用公钥来伪造签名
+type JWTVerifier struct {
+	hmacKey []byte
+	rsaKey  *rsa.PublicKey
+
+	// leeway 校验 exp 和 nbf 的时候允许的时钟误差
+	leeway   time.Duration
+	issuer   string
+	audience string
+	// tokenFunc 从请求里面拿到 token，默认是 Authorization: Bearer xxx
+	tokenFunc func(ctx *web.Context) string
+	now       func() time.Time
+}
+
+// NewHMACJWTVerifier 使用 HS256, HS384, HS512 签名的 JWT
+func NewHMACJWTVerifier(key []byte) *JWTVerifier {
+	return &JWTVerifier{hmacKey: key, tokenFunc: BearerToken, now: time.Now}
+}
+
+// NewRSAJWTVerifier 使用 RS256, RS384, RS512 签名的 JWT
+func NewRSAJWTVerifier(key *rsa.PublicKey) *JWTVerifier {
+	return &JWTVerifier{rsaKey: key, tokenFunc: BearerToken, now: time.Now}
+}
+
+// Leeway 设置允许的时钟误差
+func (v *JWTVerifier) Leeway(leeway time.Duration) *JWTVerifier {
+	v.leeway = leeway
+	return v
+}
+
+// Issuer 要求 iss 必须是这个值
+func (v *JWTVerifier) Issuer(iss string) *JWTVerifier {
+	v.issuer = iss
+	return v
+}
+
+// Audience 要求 aud 必须包含这个值
+func (v *JWTVerifier) Audience(aud string) *JWTVerifier {
+	v.audience = aud
+	return v
+}
+
+// TokenFunc 设置从哪里拿 token，例如从 cookie 里面拿
+func (v *JWTVerifier) TokenFunc(fn func(ctx *web.Context) string) *JWTVerifier {
+	v.tokenFunc = fn
+	return v
+}
+
+// BearerToken 从 Authorization: Bearer xxx 里面拿到 token
+func BearerToken(ctx *web.Context) string {
+	header := ctx.Req.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+func (v *JWTVerifier) Challenge() string {
+	return "Bearer"
+}
+
+// Verify 返回的身份信息是 JWTClaims
+func (v *JWTVerifier) Verify(ctx *web.Context) (any, error) {
+	token := v.tokenFunc(ctx)
+	if token == "" {
+		return nil, ErrNoCredential
+	}
+	return v.Parse(token)
+}
+
+// Parse 校验签名、有效期、iss 和 aud，并且返回 claims
+func (v *JWTVerifier) Parse(token string) (JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if err = v.verifySignature(header.Alg, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+	var claims JWTClaims
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if err = v.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *JWTVerifier) verifySignature(alg string, signingInput string, sig []byte) error {
+	hash, ok := hashes[alg]
+	if !ok {
+		return fmt.Errorf("%w: 不支持的算法 %s", ErrInvalidToken, alg)
+	}
+	switch {
+	case strings.HasPrefix(alg, "HS") && v.hmacKey != nil:
+		if !hmac.Equal(sig, hmacSign(hash, v.hmacKey, signingInput)) {
+			return ErrInvalidToken
+		}
+		return nil
+	case strings.HasPrefix(alg, "RS") && v.rsaKey != nil:
+		h := hash.New()
+		h.Write([]byte(signingInput))
+		if rsa.VerifyPKCS1v15(v.rsaKey, hash, h.Sum(nil), sig) != nil {
+			return ErrInvalidToken
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: 算法 %s 和密钥不匹配", ErrInvalidToken, alg)
+}
+
+func (v *JWTVerifier) validateClaims(claims JWTClaims) error {
+	now := v.now()
+	exp, ok, err := claims.time("exp")
+	if err != nil {
+		return err
+	}
+	if ok && now.After(exp.Add(v.leeway)) {
+		return ErrTokenExpired
+	}
+	nbf, ok, err := claims.time("nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(v.leeway).Before(nbf) {
+		return ErrTokenNotValidYet
+	}
+	if v.issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.issuer {
+			return fmt.Errorf("%w: iss 不匹配", ErrInvalidToken)
+		}
+	}
+	if v.audience != "" && !containsAudience(claims["aud"], v.audience) {
+		return fmt.Errorf("%w: aud 不匹配", ErrInvalidToken)
+	}
+	return nil
+}
+
+// containsAudience aud 可以是字符串，也可以是字符串数组
+func containsAudience(aud any, want string) bool {
+	switch val := aud.(type) {
+	case string:
+		return val == want
+	case []any:
+		for _, a := range val {
+			if s, ok := a.(string); ok && s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(seg string, val any) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return ErrInvalidToken
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err = decoder.Decode(val); err != nil {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+func hmacSign(hash crypto.Hash, key []byte, signingInput string) []byte {
+	mac := hmac.New(hash.New, key)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
+
+// SignJWT 生成 JWT，一般在登录成功之后调用
+// HS 系列的算法 key 是 []byte，RS 系列的算法 key 是 *rsa.PrivateKey
+func SignJWT(alg string, claims JWTClaims, key any) (string, error) {
+	hash, ok := hashes[alg]
+	if !ok {
+		return "", fmt.Errorf("auth: 不支持的算法 %s", alg)
+	}
+	header, err := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." +
+		base64.RawURLEncoding.EncodeToString(payload)
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		if !strings.HasPrefix(alg, "HS") {
+			return "", fmt.Errorf("auth: 算法 %s 和密钥不匹配", alg)
+		}
+		sig = hmacSign(hash, k, signingInput)
+	case *rsa.PrivateKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return "", fmt.Errorf("auth: 算法 %s 和密钥不匹配", alg)
+		}
+		h := hash.New()
+		h.Write([]byte(signingInput))
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, hash, h.Sum(nil))
+		if err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("auth: 不支持的密钥类型 %T", key)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
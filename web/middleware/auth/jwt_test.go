@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestJWTVerifier_Parse(t *testing.T) {
+	hmacKey := []byte("secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	now := time.Unix(1700000000, 0)
+
+	sign := func(alg string, claims JWTClaims, key any) string {
+		token, err := SignJWT(alg, claims, key)
+		require.NoError(t, err)
+		return token
+	}
+	testCases := []struct {
+		name     string
+		verifier *JWTVerifier
+		token    string
+
+		wantSub string
+		wantErr error
+	}{
+		{
+			name:     "HS256",
+			verifier: NewHMACJWTVerifier(hmacKey),
+			token:    sign("HS256", JWTClaims{"sub": "123", "exp": now.Unix() + 10}, hmacKey),
+			wantSub:  "123",
+		},
+		{
+			name:     "HS512",
+			verifier: NewHMACJWTVerifier(hmacKey),
+			token:    sign("HS512", JWTClaims{"sub": "123"}, hmacKey),
+			wantSub:  "123",
+		},
+		{
+			name:     "RS256",
+			verifier: NewRSAJWTVerifier(&rsaKey.PublicKey),
+			token:    sign("RS256", JWTClaims{"sub": "123"}, rsaKey),
+			wantSub:  "123",
+		},
+		{
+			name:     "wrong key",
+			verifier: NewHMACJWTVerifier([]byte("another")),
+			token:    sign("HS256", JWTClaims{"sub": "123"}, hmacKey),
+			wantErr:  ErrInvalidToken,
+		},
+		{
+			name:     "expired",
+			verifier: NewHMACJWTVerifier(hmacKey),
+			token:    sign("HS256", JWTClaims{"exp": now.Unix() - 10}, hmacKey),
+			wantErr:  ErrTokenExpired,
+		},
+		{
+			name:     "expired within leeway",
+			verifier: NewHMACJWTVerifier(hmacKey).Leeway(time.Minute),
+			token:    sign("HS256", JWTClaims{"sub": "123", "exp": now.Unix() - 10}, hmacKey),
+			wantSub:  "123",
+		},
+		{
+			name:     "not valid yet",
+			verifier: NewHMACJWTVerifier(hmacKey),
+			token:    sign("HS256", JWTClaims{"nbf": now.Unix() + 10}, hmacKey),
+			wantErr:  ErrTokenNotValidYet,
+		},
+		{
+			name:     "issuer and audience",
+			verifier: NewHMACJWTVerifier(hmacKey).Issuer("userapp").Audience("web"),
+			token: sign("HS256", JWTClaims{"sub": "123", "iss": "userapp",
+				"aud": []string{"web", "app"}}, hmacKey),
+			wantSub: "123",
+		},
+		{
+			name:     "wrong audience",
+			verifier: NewHMACJWTVerifier(hmacKey).Audience("admin"),
+			token:    sign("HS256", JWTClaims{"aud": "web"}, hmacKey),
+			wantErr:  ErrInvalidToken,
+		},
+		{
+			name:     "alg none",
+			verifier: NewHMACJWTVerifier(hmacKey),
+			token:    unsignedToken(t, "none", JWTClaims{"sub": "123"}),
+			wantErr:  ErrInvalidToken,
+		},
+		{
+			// 用 RSA 公钥作为 HMAC 密钥来伪造签名
+			name:     "alg confusion",
+			verifier: NewRSAJWTVerifier(&rsaKey.PublicKey),
+			token:    sign("HS256", JWTClaims{"sub": "123"}, publicKeyPEM(t, &rsaKey.PublicKey)),
+			wantErr:  ErrInvalidToken,
+		},
+		{
+			name:     "malformed",
+			verifier: NewHMACJWTVerifier(hmacKey),
+			token:    "abc.def",
+			wantErr:  ErrInvalidToken,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.verifier.now = func() time.Time {
+				return now
+			}
+			claims, err := tc.verifier.Parse(tc.token)
+			assert.True(t, errors.Is(err, tc.wantErr), "%v", err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, tc.wantSub, claims.Subject())
+		})
+	}
+}
+
+func unsignedToken(t *testing.T, alg string, claims JWTClaims) string {
+	header, err := json.Marshal(map[string]string{"alg": alg})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	return base64.RawURLEncoding.EncodeToString(header) + "." +
+		base64.RawURLEncoding.EncodeToString(payload) + "."
+}
+
+func publicKeyPEM(t *testing.T, key *rsa.PublicKey) []byte {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
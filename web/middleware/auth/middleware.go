@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"errors"
+	"gitee.com/geektime-geekbang/geektime-go/web"
+	"net/http"
+)
+
+// DefaultUserValueKey 认证通过之后，身份信息在 ctx.UserValues 里面的 key
+const DefaultUserValueKey = "auth"
+
+var (
+	// ErrNoCredential 请求里面没有这种凭证，Verifier 返回这个错误的时候会尝试下一个 Verifier
+	ErrNoCredential = errors.New("auth: 没有凭证")
+	// ErrInvalidCredential 凭证不对，例如用户名密码错误
+	ErrInvalidCredential = errors.New("auth: 凭证无效")
+)
+
+// Verifier 从请求里面解析凭证并且校验
+// 成功的时候返回代表身份的数据，例如 JWT 的 claims，Basic 认证的用户名
+type Verifier interface {
+	Verify(ctx *web.Context) (any, error)
+	// Challenge 认证失败的时候放到 WWW-Authenticate 里面的值，空字符串代表不需要
+	Challenge() string
+}
+
+// MiddlewareBuilder 认证
+// 通过 Use 或者 UseAny 注册到需要保护的路由上，
+// 这样公开的路由和需要认证的路由可以在同一个 HTTPServer 里面
+//
+//	server.UseAny("/vip", auth.NewBuilder(auth.NewHMACJWTVerifier(key)).Build())
+type MiddlewareBuilder struct {
+	// verifiers 按照顺序尝试，任何一个通过就可以
+	verifiers []Verifier
+	userKey   string
+	// authorize 认证通过之后的鉴权，返回 false 的时候响应 403
+	authorize func(ctx *web.Context, identity any) bool
+}
+
+func NewBuilder(verifiers ...Verifier) *MiddlewareBuilder {
+	return &MiddlewareBuilder{
+		verifiers: verifiers,
+		userKey:   DefaultUserValueKey,
+	}
+}
+
+// UserValueKey 设置身份信息在 ctx.UserValues 里面的 key
+func (b *MiddlewareBuilder) UserValueKey(key string) *MiddlewareBuilder {
+	b.userKey = key
+	return b
+}
+
+// Authorize 设置鉴权，例如只允许 VIP 用户访问
+func (b *MiddlewareBuilder) Authorize(fn func(ctx *web.Context, identity any) bool) *MiddlewareBuilder {
+	b.authorize = fn
+	return b
+}
+
+func (b *MiddlewareBuilder) Build() web.Middleware {
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			identity, ok := b.verify(ctx)
+			if !ok {
+				for _, v := range b.verifiers {
+					if challenge := v.Challenge(); challenge != "" {
+						ctx.Resp.Header().Add("WWW-Authenticate", challenge)
+					}
+				}
+				ctx.RespStatusCode = http.StatusUnauthorized
+				ctx.RespData = []byte(http.StatusText(http.StatusUnauthorized))
+				return
+			}
+			if ctx.UserValues == nil {
+				ctx.UserValues = make(map[string]any, 1)
+			}
+			ctx.UserValues[b.userKey] = identity
+			if b.authorize != nil && !b.authorize(ctx, identity) {
+				ctx.RespStatusCode = http.StatusForbidden
+				ctx.RespData = []byte(http.StatusText(http.StatusForbidden))
+				return
+			}
+			next(ctx)
+		}
+	}
+}
+
+func (b *MiddlewareBuilder) verify(ctx *web.Context) (any, bool) {
+	for _, v := range b.verifiers {
+		identity, err := v.Verify(ctx)
+		if err == nil {
+			return identity, true
+		}
+		// 带了凭证但是凭证不对，就不再尝试别的方式了
+		if !errors.Is(err, ErrNoCredential) {
+			return nil, false
+		}
+	}
+	return nil, false
+}
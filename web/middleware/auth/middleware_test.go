@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"gitee.com/geektime-geekbang/geektime-go/web"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	key := []byte("secret")
+	token, err := SignJWT("HS256", JWTClaims{"sub": "123", "vip": true}, key)
+	require.NoError(t, err)
+	normalToken, err := SignJWT("HS256", JWTClaims{"sub": "456"}, key)
+	require.NoError(t, err)
+
+	s := web.NewHTTPServer()
+	// /vip 只允许 VIP 用户访问
+	s.UseAny("/vip", NewBuilder(NewHMACJWTVerifier(key)).
+		Authorize(func(ctx *web.Context, identity any) bool {
+			vip, _ := identity.(JWTClaims)["vip"].(bool)
+			return vip
+		}).Build())
+	// /admin 可以用 Basic，也可以用 API Key
+	s.UseAny("/admin", NewBuilder(
+		NewBasicVerifier("admin", BasicAccounts(map[string]string{"root": "123456"})),
+		NewAPIKeyVerifier(StaticAPIKeys(map[string]any{"key-1": "ci"})),
+	).Build())
+	handler := func(ctx *web.Context) {
+		ctx.RespStatusCode = http.StatusOK
+		if ctx.UserValues != nil {
+			switch identity := ctx.UserValues[DefaultUserValueKey].(type) {
+			case JWTClaims:
+				ctx.RespData = []byte(identity.Subject())
+			case string:
+				ctx.RespData = []byte(identity)
+			}
+		}
+	}
+	s.Get("/public", handler)
+	s.Get("/vip/home", handler)
+	s.Get("/admin/home", handler)
+
+	testCases := []struct {
+		name   string
+		path   string
+		header http.Header
+
+		wantCode      int
+		wantBody      string
+		wantChallenge []string
+	}{
+		{
+			name:     "public",
+			path:     "/public",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "vip",
+			path:     "/vip/home",
+			header:   http.Header{"Authorization": []string{"Bearer " + token}},
+			wantCode: http.StatusOK,
+			wantBody: "123",
+		},
+		{
+			name:     "not vip",
+			path:     "/vip/home",
+			header:   http.Header{"Authorization": []string{"Bearer " + normalToken}},
+			wantCode: http.StatusForbidden,
+			wantBody: http.StatusText(http.StatusForbidden),
+		},
+		{
+			name:          "no token",
+			path:          "/vip/home",
+			wantCode:      http.StatusUnauthorized,
+			wantBody:      http.StatusText(http.StatusUnauthorized),
+			wantChallenge: []string{"Bearer"},
+		},
+		{
+			name:          "invalid token",
+			path:          "/vip/home",
+			header:        http.Header{"Authorization": []string{"Bearer abc"}},
+			wantCode:      http.StatusUnauthorized,
+			wantBody:      http.StatusText(http.StatusUnauthorized),
+			wantChallenge: []string{"Bearer"},
+		},
+		{
+			name:     "basic",
+			path:     "/admin/home",
+			header:   http.Header{"Authorization": []string{"Basic cm9vdDoxMjM0NTY="}},
+			wantCode: http.StatusOK,
+			wantBody: "root",
+		},
+		{
+			name: "wrong password",
+			path: "/admin/home",
+			// root:654321
+			header:        http.Header{"Authorization": []string{"Basic cm9vdDo2NTQzMjE="}},
+			wantCode:      http.StatusUnauthorized,
+			wantBody:      http.StatusText(http.StatusUnauthorized),
+			wantChallenge: []string{`Basic realm="admin"`},
+		},
+		{
+			name:     "api key",
+			path:     "/admin/home",
+			header:   http.Header{"X-Api-Key": []string{"key-1"}},
+			wantCode: http.StatusOK,
+			wantBody: "ci",
+		},
+		{
+			name:          "wrong api key",
+			path:          "/admin/home",
+			header:        http.Header{"X-Api-Key": []string{"key-2"}},
+			wantCode:      http.StatusUnauthorized,
+			wantBody:      http.StatusText(http.StatusUnauthorized),
+			wantChallenge: []string{`Basic realm="admin"`},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			for k, vals := range tc.header {
+				req.Header[k] = vals
+			}
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			assert.Equal(t, tc.wantBody, recorder.Body.String())
+			assert.Equal(t, tc.wantChallenge, recorder.Header().Values("WWW-Authenticate"))
+		})
+	}
+}
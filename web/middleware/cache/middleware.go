@@ -0,0 +1,429 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"gitee.com/geektime-geekbang/geektime-go/cache"
+	"gitee.com/geektime-geekbang/geektime-go/web"
+	"golang.org/x/sync/singleflight"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MiddlewareBuilder 缓存整个 GET 响应，包括响应码、响应头部和 RespData
+// 缓存的 key 由 HTTP 方法、命中的路由、请求路径、排好序的查询参数以及 VaryHeaders 指定的头部组成。
+//
+// 以下情况不会缓存：
+// - 请求带了 Authorization 或者 Cookie，或者 Cache-Control: no-store
+// - HEAD 请求，它只会读 GET 请求写进去的缓存
+// - 响应码不是 200，或者响应带了 Set-Cookie
+// - 响应的 Cache-Control 是 no-store, no-cache 或者 private
+// - 响应的 Vary 是 *，或者包含了 VaryHeaders 以外的头部，例如内层的压缩 Middleware 加的 Accept-Encoding
+//
+// 带 Cookie 的请求一般是已经登录的用户，响应很可能是个性化的；
+// 如果确实要缓存，那么把 Cookie 加到 VaryHeaders 里面，每个用户各自缓存一份。
+// 响应的 Cache-Control 里面的 s-maxage 或者 max-age 会覆盖默认的过期时间。
+// 要注意 Purge 只能删除当前实例写进去的缓存，多实例共享 Redis 的时候，
+// 其它实例写进去的缓存只能等过期
+type MiddlewareBuilder struct {
+	cache      cache.Cache
+	expiration time.Duration
+	prefix     string
+	// varyHeaders 会影响响应的头部，例如 Accept-Language
+	varyHeaders []string
+
+	group singleflight.Group
+	mutex sync.Mutex
+	// keys 路由到缓存 key 的映射，用于 Purge
+	keys map[string]*routeKeys
+
+	logFunc func(ctx *web.Context, err error)
+}
+
+// NewBuilder expiration 是默认的过期时间
+func NewBuilder(c cache.Cache, expiration time.Duration) *MiddlewareBuilder {
+	return &MiddlewareBuilder{
+		cache:      c,
+		expiration: expiration,
+		prefix:     "http-cache",
+		keys:       map[string]*routeKeys{},
+		logFunc: func(ctx *web.Context, err error) {
+			log.Printf("HTTP 缓存出错 %s %s: %v", ctx.Req.Method, ctx.Req.URL.Path, err)
+		},
+	}
+}
+
+// KeyPrefix 设置缓存 key 的前缀，默认是 http-cache
+func (b *MiddlewareBuilder) KeyPrefix(prefix string) *MiddlewareBuilder {
+	b.prefix = prefix
+	return b
+}
+
+// VaryHeaders 设置哪些请求头部会影响响应，这些头部的值会成为缓存 key 的一部分
+func (b *MiddlewareBuilder) VaryHeaders(headers ...string) *MiddlewareBuilder {
+	for _, h := range headers {
+		b.varyHeaders = append(b.varyHeaders, http.CanonicalHeaderKey(h))
+	}
+	return b
+}
+
+func (b *MiddlewareBuilder) LogFunc(fn func(ctx *web.Context, err error)) *MiddlewareBuilder {
+	b.logFunc = fn
+	return b
+}
+
+// Purge 删除这些路由的所有缓存，route 是注册路由时候的路径，例如 /user/:id
+func (b *MiddlewareBuilder) Purge(ctx context.Context, routes ...string) error {
+	var keys []string
+	b.mutex.Lock()
+	now := time.Now()
+	for _, route := range routes {
+		if rk, ok := b.keys[route]; ok {
+			for key, expireAt := range rk.keys {
+				// 已经过期的 key 不需要删除
+				if expireAt.After(now) {
+					keys = append(keys, key)
+				}
+			}
+		}
+		delete(b.keys, route)
+	}
+	b.mutex.Unlock()
+	var firstErr error
+	for _, key := range keys {
+		if err := b.cache.Delete(ctx, key); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (b *MiddlewareBuilder) Build() web.Middleware {
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			if !b.cacheable(ctx) {
+				next(ctx)
+				return
+			}
+			key := b.key(ctx)
+			reqCC := parseCacheControl(ctx.Req.Header.Get("Cache-Control"))
+			_, noCache := reqCC["no-cache"]
+			if reqCC["max-age"] == "0" {
+				noCache = true
+			}
+			if !noCache {
+				if e, err := b.get(ctx.Req.Context(), key); err == nil {
+					e.writeTo(ctx, "HIT")
+					return
+				}
+			}
+			// HEAD 请求只能读缓存，handler 可能根本就没有设置 RespData，
+			// 存进去的话后面的 GET 请求拿到的就是空的响应体
+			if ctx.Req.Method == http.MethodHead {
+				next(ctx)
+				ctx.Resp.Header().Set("X-Cache", "MISS")
+				return
+			}
+
+			leader := false
+			val, _, _ := b.group.Do(key, func() (interface{}, error) {
+				leader = true
+				return b.load(ctx, next, key), nil
+			})
+			if leader {
+				return
+			}
+			// 跟着 leader 拿到了结果，但是这个响应不能缓存，那么只能自己执行一遍
+			e, ok := val.(*entry)
+			if !ok || e == nil {
+				next(ctx)
+				return
+			}
+			e.writeTo(ctx, "HIT")
+		}
+	}
+}
+
+// load 执行业务逻辑，并且把可以缓存的响应写到缓存里面
+func (b *MiddlewareBuilder) load(ctx *web.Context, next web.HandleFunc, key string) *entry {
+	header := ctx.Resp.Header()
+	before := header.Clone()
+	next(ctx)
+	header.Set("X-Cache", "MISS")
+	expiration, ok := b.respExpiration(ctx)
+	if !ok || !b.varyCovered(before.Values("Vary"), header.Values("Vary")) {
+		return nil
+	}
+	e := &entry{
+		Status: respStatus(ctx),
+		Header: http.Header{},
+		Data:   ctx.RespData,
+	}
+	// 只保存业务逻辑设置的头部，外层 Middleware 设置的例如 X-Request-ID 每次都不一样
+	for k, v := range header {
+		if k == "X-Cache" || k == "Content-Length" {
+			continue
+		}
+		if old, ok := before[k]; !ok || strings.Join(old, ",") != strings.Join(v, ",") {
+			e.Header[k] = v
+		}
+	}
+	if header.Get("ETag") == "" {
+		sum := sha1.Sum(e.Data)
+		etag := `"` + hex.EncodeToString(sum[:]) + `"`
+		header.Set("ETag", etag)
+		e.Header.Set("ETag", etag)
+	}
+	if etagMatch(ctx.Req.Header.Get("If-None-Match"), header.Get("ETag")) {
+		ctx.RespStatusCode = http.StatusNotModified
+		ctx.RespData = nil
+	}
+	data, err := json.Marshal(e)
+	if err == nil {
+		err = b.cache.Set(ctx.Req.Context(), key, data, expiration)
+	}
+	if err != nil {
+		b.logFunc(ctx, err)
+		return e
+	}
+	b.mutex.Lock()
+	rk, ok := b.keys[ctx.MatchedRoute]
+	if !ok {
+		rk = &routeKeys{keys: map[string]time.Time{}}
+		b.keys[ctx.MatchedRoute] = rk
+	}
+	rk.add(key, time.Now().Add(expiration))
+	b.mutex.Unlock()
+	return e
+}
+
+// routeKeys 一个路由下面的缓存 key 以及它们的过期时间
+// 写入的时候顺便清理已经过期的 key，不然查询参数不同的请求会让它无限增长
+type routeKeys struct {
+	keys map[string]time.Time
+	// pruneAt key 的数量达到这个值的时候清理一次，
+	// 清理之后设置为剩下的数量的两倍，这样平摊下来每次写入是 O(1) 的
+	pruneAt int
+}
+
+const minPruneAt = 64
+
+func (r *routeKeys) add(key string, expireAt time.Time) {
+	r.keys[key] = expireAt
+	if len(r.keys) < r.pruneAt {
+		return
+	}
+	now := time.Now()
+	for k, t := range r.keys {
+		if !t.After(now) {
+			delete(r.keys, k)
+		}
+	}
+	r.pruneAt = 2 * len(r.keys)
+	if r.pruneAt < minPruneAt {
+		r.pruneAt = minPruneAt
+	}
+}
+
+func (b *MiddlewareBuilder) get(ctx context.Context, key string) (*entry, error) {
+	val, err := b.cache.Get(ctx, key)
+	// 不同的缓存实现 key 不存在的时候返回的 error 都不一样，这里统一当作没有命中
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	switch v := val.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return nil, errInvalidEntry
+	}
+	e := &entry{}
+	err = json.Unmarshal(data, e)
+	return e, err
+}
+
+// cacheable 判断请求能不能用缓存
+func (b *MiddlewareBuilder) cacheable(ctx *web.Context) bool {
+	if ctx.Req.Method != http.MethodGet && ctx.Req.Method != http.MethodHead {
+		return false
+	}
+	if ctx.MatchedRoute == "" || ctx.Req.Header.Get("Authorization") != "" {
+		return false
+	}
+	if ctx.Req.Header.Get("Cookie") != "" && !b.varyCookie() {
+		return false
+	}
+	_, noStore := parseCacheControl(ctx.Req.Header.Get("Cache-Control"))["no-store"]
+	return !noStore
+}
+
+// respExpiration 判断响应能不能缓存，以及缓存多久
+func (b *MiddlewareBuilder) respExpiration(ctx *web.Context) (time.Duration, bool) {
+	header := ctx.Resp.Header()
+	if respStatus(ctx) != http.StatusOK || header.Get("Set-Cookie") != "" {
+		return 0, false
+	}
+	cc := parseCacheControl(header.Get("Cache-Control"))
+	for _, directive := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := cc[directive]; ok {
+			return 0, false
+		}
+	}
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		if val, ok := cc[directive]; ok {
+			seconds, err := strconv.Atoi(val)
+			if err != nil || seconds <= 0 {
+				return 0, false
+			}
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+	return b.expiration, true
+}
+
+// varyCovered 响应的 Vary 里面的头部都在 varyHeaders 里面，也就是都已经是 key 的一部分
+// 外层 Middleware 在调用 next 之前设置的 Vary 每次都会重新设置，所以不用管
+func (b *MiddlewareBuilder) varyCovered(before []string, after []string) bool {
+	outer := make(map[string]struct{}, len(before))
+	for _, h := range splitVary(before) {
+		outer[h] = struct{}{}
+	}
+	for _, h := range splitVary(after) {
+		if _, ok := outer[h]; ok {
+			continue
+		}
+		if h == "*" {
+			return false
+		}
+		covered := false
+		for _, vh := range b.varyHeaders {
+			if vh == h {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return false
+		}
+	}
+	return true
+}
+
+func splitVary(vals []string) []string {
+	var res []string
+	for _, v := range vals {
+		for _, h := range strings.Split(v, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				res = append(res, http.CanonicalHeaderKey(h))
+			}
+		}
+	}
+	return res
+}
+
+func (b *MiddlewareBuilder) varyCookie() bool {
+	for _, h := range b.varyHeaders {
+		if h == "Cookie" {
+			return true
+		}
+	}
+	return false
+}
+
+// respStatus handler 没有设置响应码的时候，框架最终返回的是 200
+func respStatus(ctx *web.Context) int {
+	if ctx.RespStatusCode == 0 {
+		return http.StatusOK
+	}
+	return ctx.RespStatusCode
+}
+
+// key 的形式是 prefix:GET:/user/:id:hash
+// HEAD 请求和 GET 请求共用缓存，但是只有 GET 请求会写缓存
+func (b *MiddlewareBuilder) key(ctx *web.Context) string {
+	var sb strings.Builder
+	sb.WriteString(ctx.Req.URL.Path)
+	sb.WriteByte('?')
+	sb.WriteString(normalizeQuery(ctx.Req.URL.Query()))
+	for _, h := range b.varyHeaders {
+		sb.WriteByte('\n')
+		sb.WriteString(h)
+		sb.WriteByte(':')
+		sb.WriteString(strings.Join(ctx.Req.Header.Values(h), ","))
+	}
+	sum := sha256.Sum256([]byte(sb.String()))
+	return b.prefix + ":" + http.MethodGet + ":" + ctx.MatchedRoute + ":" + hex.EncodeToString(sum[:])
+}
+
+// normalizeQuery 按照 key 排序，同一个 key 的多个值也排序
+func normalizeQuery(query url.Values) string {
+	for _, vals := range query {
+		sort.Strings(vals)
+	}
+	return query.Encode()
+}
+
+func parseCacheControl(val string) map[string]string {
+	res := map[string]string{}
+	for _, directive := range strings.Split(val, ",") {
+		directive = strings.TrimSpace(directive)
+		if directive == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(directive, "=")
+		res[strings.ToLower(k)] = strings.Trim(v, `"`)
+	}
+	return res
+}
+
+// etagMatch If-None-Match 用的是弱比较，参考 RFC 7232 3.2
+func etagMatch(ifNoneMatch string, etag string) bool {
+	if ifNoneMatch == "" || etag == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+var errInvalidEntry = errors.New("cache: 缓存的响应格式不对")
+
+// entry 缓存的响应
+type entry struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Data   []byte      `json:"data"`
+}
+
+func (e *entry) writeTo(ctx *web.Context, xCache string) {
+	header := ctx.Resp.Header()
+	for k, v := range e.Header {
+		header[k] = v
+	}
+	header.Set("X-Cache", xCache)
+	if etagMatch(ctx.Req.Header.Get("If-None-Match"), e.Header.Get("ETag")) {
+		ctx.RespStatusCode = http.StatusNotModified
+		ctx.RespData = nil
+		return
+	}
+	ctx.RespStatusCode = e.Status
+	ctx.RespData = e.Data
+}
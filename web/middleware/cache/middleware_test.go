@@ -0,0 +1,389 @@
+package cache
+
+import (
+	"context"
+	"gitee.com/geektime-geekbang/geektime-go/cache"
+	"gitee.com/geektime-geekbang/geektime-go/web"
+	"gitee.com/geektime-geekbang/geektime-go/web/middleware/compress"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	var cnt int64
+	builder := NewBuilder(cache.NewBuildinMapCache(), time.Minute).VaryHeaders("Accept-Language")
+	s := web.NewHTTPServer()
+	s.UseAny("/*", builder.Build())
+	handler := func(ctx *web.Context) {
+		n := atomic.AddInt64(&cnt, 1)
+		ctx.Resp.Header().Set("Content-Type", "text/plain")
+		ctx.RespStatusCode = http.StatusOK
+		ctx.RespData = []byte(ctx.Req.Header.Get("Accept-Language") + string(rune('0'+n)))
+	}
+	s.Get("/user/:id", handler)
+	s.Get("/private", func(ctx *web.Context) {
+		atomic.AddInt64(&cnt, 1)
+		ctx.Resp.Header().Set("Cache-Control", "private")
+		ctx.RespStatusCode = http.StatusOK
+	})
+	s.Get("/error", func(ctx *web.Context) {
+		atomic.AddInt64(&cnt, 1)
+		ctx.RespStatusCode = http.StatusInternalServerError
+	})
+
+	testCases := []struct {
+		name   string
+		path   string
+		header http.Header
+
+		wantCode   int
+		wantBody   string
+		wantXCache string
+		wantCnt    int64
+	}{
+		{
+			name:       "miss",
+			path:       "/user/1?a=1&b=2",
+			wantCode:   http.StatusOK,
+			wantBody:   "1",
+			wantXCache: "MISS",
+			wantCnt:    1,
+		},
+		{
+			name:       "hit with normalized query",
+			path:       "/user/1?b=2&a=1",
+			wantCode:   http.StatusOK,
+			wantBody:   "1",
+			wantXCache: "HIT",
+			wantCnt:    1,
+		},
+		{
+			name:       "another path",
+			path:       "/user/2?a=1&b=2",
+			wantCode:   http.StatusOK,
+			wantBody:   "2",
+			wantXCache: "MISS",
+			wantCnt:    2,
+		},
+		{
+			name:       "vary header",
+			path:       "/user/1?a=1&b=2",
+			header:     http.Header{"Accept-Language": []string{"zh"}},
+			wantCode:   http.StatusOK,
+			wantBody:   "zh3",
+			wantXCache: "MISS",
+			wantCnt:    3,
+		},
+		{
+			name:       "request no-cache",
+			path:       "/user/1?a=1&b=2",
+			header:     http.Header{"Cache-Control": []string{"no-cache"}},
+			wantCode:   http.StatusOK,
+			wantBody:   "4",
+			wantXCache: "MISS",
+			wantCnt:    4,
+		},
+		{
+			name:       "refreshed",
+			path:       "/user/1?a=1&b=2",
+			wantCode:   http.StatusOK,
+			wantBody:   "4",
+			wantXCache: "HIT",
+			wantCnt:    4,
+		},
+		{
+			name:     "authorization",
+			path:     "/user/1?a=1&b=2",
+			header:   http.Header{"Authorization": []string{"Bearer abc"}},
+			wantCode: http.StatusOK,
+			wantBody: "5",
+			wantCnt:  5,
+		},
+		{
+			name:       "private",
+			path:       "/private",
+			wantCode:   http.StatusOK,
+			wantXCache: "MISS",
+			wantCnt:    6,
+		},
+		{
+			name:       "private again",
+			path:       "/private",
+			wantCode:   http.StatusOK,
+			wantXCache: "MISS",
+			wantCnt:    7,
+		},
+		{
+			name:       "error",
+			path:       "/error",
+			wantCode:   http.StatusInternalServerError,
+			wantXCache: "MISS",
+			wantCnt:    8,
+		},
+		{
+			name:       "error again",
+			path:       "/error",
+			wantCode:   http.StatusInternalServerError,
+			wantXCache: "MISS",
+			wantCnt:    9,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			for k, vals := range tc.header {
+				req.Header[k] = vals
+			}
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			assert.Equal(t, tc.wantBody, recorder.Body.String())
+			assert.Equal(t, tc.wantXCache, recorder.Header().Get("X-Cache"))
+			assert.Equal(t, tc.wantCnt, atomic.LoadInt64(&cnt))
+		})
+	}
+
+	// ETag
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/user/1?a=1&b=2", nil))
+	etag := recorder.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+	assert.Equal(t, "text/plain", recorder.Header().Get("Content-Type"))
+	req := httptest.NewRequest(http.MethodGet, "/user/1?a=1&b=2", nil)
+	req.Header.Set("If-None-Match", "W/"+etag)
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusNotModified, recorder.Code)
+	assert.Equal(t, "", recorder.Body.String())
+
+	// Purge 之后就要重新执行业务逻辑
+	require.NoError(t, builder.Purge(context.Background(), "/user/:id"))
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/user/1?a=1&b=2", nil))
+	assert.Equal(t, "MISS", recorder.Header().Get("X-Cache"))
+	assert.Equal(t, int64(10), atomic.LoadInt64(&cnt))
+}
+
+func TestMiddlewareBuilder_ImplicitStatus(t *testing.T) {
+	var cnt int64
+	s := web.NewHTTPServer()
+	s.UseAny("/*", NewBuilder(cache.NewBuildinMapCache(), time.Minute).Build())
+	// 没有设置响应码，框架返回的是 200，一样要缓存
+	s.Get("/user", func(ctx *web.Context) {
+		n := atomic.AddInt64(&cnt, 1)
+		ctx.RespData = []byte(string(rune('0' + n)))
+	})
+	wantXCache := []string{"MISS", "HIT", "HIT"}
+	for _, want := range wantXCache {
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/user", nil))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "1", recorder.Body.String())
+		assert.Equal(t, want, recorder.Header().Get("X-Cache"))
+	}
+	assert.Equal(t, int64(1), atomic.LoadInt64(&cnt))
+}
+
+func TestMiddlewareBuilder_Vary(t *testing.T) {
+	testCases := []struct {
+		name    string
+		builder func() *MiddlewareBuilder
+		vary    string
+
+		wantXCache []string
+	}{
+		{
+			// 压缩 Middleware 在缓存里面，响应取决于 Accept-Encoding，但是 key 里面没有
+			name: "not covered",
+			builder: func() *MiddlewareBuilder {
+				return NewBuilder(cache.NewBuildinMapCache(), time.Minute)
+			},
+			wantXCache: []string{"MISS", "MISS", "MISS", "MISS"},
+		},
+		{
+			name: "covered",
+			builder: func() *MiddlewareBuilder {
+				return NewBuilder(cache.NewBuildinMapCache(), time.Minute).VaryHeaders("accept-encoding")
+			},
+			wantXCache: []string{"MISS", "MISS", "HIT", "HIT"},
+		},
+		{
+			name: "vary all",
+			builder: func() *MiddlewareBuilder {
+				return NewBuilder(cache.NewBuildinMapCache(), time.Minute).VaryHeaders("Accept-Encoding")
+			},
+			vary:       "*",
+			wantXCache: []string{"MISS", "MISS", "MISS", "MISS"},
+		},
+	}
+	encodings := []string{"gzip", "", "gzip", ""}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := web.NewHTTPServer()
+			s.UseAny("/*", tc.builder().Build(), compress.NewBuilder().Build())
+			body := strings.Repeat("hello", 1024)
+			s.Get("/user", func(ctx *web.Context) {
+				if tc.vary != "" {
+					ctx.Resp.Header().Set("Vary", tc.vary)
+				}
+				ctx.Resp.Header().Set("Content-Type", "text/plain")
+				ctx.RespData = []byte(body)
+			})
+			for i, encoding := range encodings {
+				req := httptest.NewRequest(http.MethodGet, "/user", nil)
+				if encoding != "" {
+					req.Header.Set("Accept-Encoding", encoding)
+				}
+				recorder := httptest.NewRecorder()
+				s.ServeHTTP(recorder, req)
+				assert.Equal(t, tc.wantXCache[i], recorder.Header().Get("X-Cache"))
+				// 不能把压缩过的响应返回给不支持压缩的客户端
+				assert.Equal(t, encoding, recorder.Header().Get("Content-Encoding"))
+				if encoding == "" {
+					assert.Equal(t, body, recorder.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestMiddlewareBuilder_Head(t *testing.T) {
+	var cnt int64
+	s := web.NewHTTPServer()
+	s.UseAny("/*", NewBuilder(cache.NewBuildinMapCache(), time.Minute).Build())
+	s.Get("/user", func(ctx *web.Context) {
+		n := atomic.AddInt64(&cnt, 1)
+		// HEAD 请求不需要响应体，handler 可能就不设置了
+		if ctx.Req.Method == http.MethodHead {
+			return
+		}
+		ctx.RespData = []byte(string(rune('0' + n)))
+	})
+	testCases := []struct {
+		method     string
+		wantBody   string
+		wantXCache string
+	}{
+		// HEAD 请求不会写缓存
+		{method: http.MethodHead, wantXCache: "MISS"},
+		{method: http.MethodGet, wantBody: "2", wantXCache: "MISS"},
+		// 但是可以读 GET 请求写进去的缓存
+		{method: http.MethodHead, wantXCache: "HIT"},
+		{method: http.MethodGet, wantBody: "2", wantXCache: "HIT"},
+	}
+	for _, tc := range testCases {
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(tc.method, "/user", nil))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, tc.wantBody, recorder.Body.String())
+		assert.Equal(t, tc.wantXCache, recorder.Header().Get("X-Cache"))
+	}
+	assert.Equal(t, int64(2), atomic.LoadInt64(&cnt))
+}
+
+func TestMiddlewareBuilder_Cookie(t *testing.T) {
+	testCases := []struct {
+		name    string
+		builder func() *MiddlewareBuilder
+		cookies []string
+
+		wantBodies []string
+		wantXCache []string
+	}{
+		{
+			// 带了 Cookie 的请求不缓存，不然别人会看到我的个性化页面
+			name: "skip",
+			builder: func() *MiddlewareBuilder {
+				return NewBuilder(cache.NewBuildinMapCache(), time.Minute)
+			},
+			cookies:    []string{"sess=tom", "sess=jerry", "sess=tom"},
+			wantBodies: []string{"tom1", "jerry2", "tom3"},
+			wantXCache: []string{"", "", ""},
+		},
+		{
+			name: "vary cookie",
+			builder: func() *MiddlewareBuilder {
+				return NewBuilder(cache.NewBuildinMapCache(), time.Minute).VaryHeaders("cookie")
+			},
+			cookies:    []string{"sess=tom", "sess=jerry", "sess=tom"},
+			wantBodies: []string{"tom1", "jerry2", "tom1"},
+			wantXCache: []string{"MISS", "MISS", "HIT"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var cnt int64
+			s := web.NewHTTPServer()
+			s.UseAny("/*", tc.builder().Build())
+			s.Get("/profile", func(ctx *web.Context) {
+				n := atomic.AddInt64(&cnt, 1)
+				c, _ := ctx.Req.Cookie("sess")
+				ctx.RespData = []byte(c.Value + string(rune('0'+n)))
+			})
+			for i, cookie := range tc.cookies {
+				req := httptest.NewRequest(http.MethodGet, "/profile", nil)
+				req.Header.Set("Cookie", cookie)
+				recorder := httptest.NewRecorder()
+				s.ServeHTTP(recorder, req)
+				assert.Equal(t, tc.wantBodies[i], recorder.Body.String())
+				assert.Equal(t, tc.wantXCache[i], recorder.Header().Get("X-Cache"))
+			}
+		})
+	}
+}
+
+func TestMiddlewareBuilder_Singleflight(t *testing.T) {
+	var cnt int64
+	start := make(chan struct{})
+	s := web.NewHTTPServer()
+	s.UseAny("/*", NewBuilder(cache.NewBuildinMapCache(), time.Minute).Build())
+	s.Get("/slow", func(ctx *web.Context) {
+		atomic.AddInt64(&cnt, 1)
+		<-start
+		ctx.RespStatusCode = http.StatusOK
+		ctx.RespData = []byte("slow")
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/slow", nil))
+			assert.Equal(t, "slow", recorder.Body.String())
+		}()
+	}
+	// 等所有请求都进来
+	time.Sleep(100 * time.Millisecond)
+	close(start)
+	wg.Wait()
+	assert.Equal(t, int64(1), atomic.LoadInt64(&cnt))
+}
+
+func TestRouteKeys_Add(t *testing.T) {
+	rk := &routeKeys{keys: map[string]time.Time{}}
+	expired := time.Now().Add(-time.Second)
+	for i := 0; i < minPruneAt; i++ {
+		rk.add(strconv.Itoa(i), expired)
+	}
+	// 达到阈值的时候，过期的 key 都被清理掉了
+	rk.add("alive", time.Now().Add(time.Minute))
+	assert.Equal(t, 1, len(rk.keys))
+	assert.Equal(t, minPruneAt, rk.pruneAt)
+
+	// 没有过期的 key 不会被清理，阈值变成剩下的两倍
+	for i := 0; i < minPruneAt; i++ {
+		rk.add(strconv.Itoa(i), time.Now().Add(time.Minute))
+	}
+	assert.Equal(t, minPruneAt+1, len(rk.keys))
+	assert.Equal(t, 2*minPruneAt, rk.pruneAt)
+}
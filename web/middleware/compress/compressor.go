@@ -0,0 +1,158 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"github.com/andybalholm/brotli"
+	"io"
+	"sync"
+)
+
+// Compressor 压缩算法的抽象
+// 和 micro/rpc/compress 里面的 Compressor 是一个思路，
+// 只不过 HTTP 协议里面是用 Content-Encoding 的名字来标识压缩算法，而不是一个 byte
+type Compressor interface {
+	// Name 对应 Accept-Encoding 和 Content-Encoding 里面的值，例如 gzip
+	Name() string
+	Compress(data []byte) ([]byte, error)
+	Uncompress(data []byte) ([]byte, error)
+}
+
+// GzipCompressor gzip 压缩
+// Writer 的创建成本很高，所以这里用 sync.Pool 复用
+type GzipCompressor struct {
+	pool sync.Pool
+}
+
+// NewGzipCompressor level 的取值参考 compress/gzip，例如 gzip.DefaultCompression
+func NewGzipCompressor(level int) *GzipCompressor {
+	return &GzipCompressor{
+		pool: sync.Pool{
+			New: func() any {
+				// 只有 level 非法的时候才会返回 error
+				w, err := gzip.NewWriterLevel(nil, level)
+				if err != nil {
+					w = gzip.NewWriter(nil)
+				}
+				return w
+			},
+		},
+	}
+}
+
+func (c *GzipCompressor) Name() string {
+	return "gzip"
+}
+
+func (c *GzipCompressor) Compress(data []byte) ([]byte, error) {
+	res := &bytes.Buffer{}
+	gw := c.pool.Get().(*gzip.Writer)
+	defer c.pool.Put(gw)
+	gw.Reset(res)
+	if _, err := gw.Write(data); err != nil {
+		return nil, err
+	}
+	// 一定要先 Close，否则部分数据还没刷新到 res 里面
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	return res.Bytes(), nil
+}
+
+func (c *GzipCompressor) Uncompress(data []byte) ([]byte, error) {
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+	return io.ReadAll(gr)
+}
+
+// DeflateCompressor deflate 压缩
+// 注意 HTTP 里面的 deflate 指的是 zlib 格式（RFC 9110 8.4.1.2），也就是带了 zlib 头部和校验和的 deflate，
+// 而不是 compress/flate 输出的裸 deflate
+type DeflateCompressor struct {
+	pool sync.Pool
+}
+
+// NewDeflateCompressor level 的取值参考 compress/zlib，例如 zlib.DefaultCompression
+func NewDeflateCompressor(level int) *DeflateCompressor {
+	return &DeflateCompressor{
+		pool: sync.Pool{
+			New: func() any {
+				// 只有 level 非法的时候才会返回 error
+				w, err := zlib.NewWriterLevel(nil, level)
+				if err != nil {
+					w = zlib.NewWriter(nil)
+				}
+				return w
+			},
+		},
+	}
+}
+
+func (c *DeflateCompressor) Name() string {
+	return "deflate"
+}
+
+func (c *DeflateCompressor) Compress(data []byte) ([]byte, error) {
+	res := &bytes.Buffer{}
+	fw := c.pool.Get().(*zlib.Writer)
+	defer c.pool.Put(fw)
+	fw.Reset(res)
+	if _, err := fw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := fw.Close(); err != nil {
+		return nil, err
+	}
+	return res.Bytes(), nil
+}
+
+func (c *DeflateCompressor) Uncompress(data []byte) ([]byte, error) {
+	fr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer fr.Close()
+	return io.ReadAll(fr)
+}
+
+// BrotliCompressor brotli 压缩，压缩率比 gzip 高，但是只有 HTTPS 下浏览器才会发送 br
+type BrotliCompressor struct {
+	pool sync.Pool
+}
+
+// NewBrotliCompressor level 的取值参考 brotli 包，例如 brotli.DefaultCompression
+func NewBrotliCompressor(level int) *BrotliCompressor {
+	return &BrotliCompressor{
+		pool: sync.Pool{
+			New: func() any {
+				return brotli.NewWriterLevel(nil, level)
+			},
+		},
+	}
+}
+
+func (c *BrotliCompressor) Name() string {
+	return "br"
+}
+
+func (c *BrotliCompressor) Compress(data []byte) ([]byte, error) {
+	res := &bytes.Buffer{}
+	bw := c.pool.Get().(*brotli.Writer)
+	defer c.pool.Put(bw)
+	bw.Reset(res)
+	if _, err := bw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := bw.Close(); err != nil {
+		return nil, err
+	}
+	return res.Bytes(), nil
+}
+
+func (c *BrotliCompressor) Uncompress(data []byte) ([]byte, error) {
+	return io.ReadAll(brotli.NewReader(bytes.NewReader(data)))
+}
@@ -0,0 +1,181 @@
+package compress
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"gitee.com/geektime-geekbang/geektime-go/web"
+	"github.com/andybalholm/brotli"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// MiddlewareBuilder 压缩响应
+// 因为 flashResp 是一次性把 RespData 写回去的，
+// 所以我们只需要在 next 返回之后压缩 RespData 就可以了。
+// 直接写 ctx.Resp 的响应，以及流式响应和 WebSocket 都不会被压缩
+type MiddlewareBuilder struct {
+	// compressors 按照优先级排列，客户端的 q 值相同的时候，排在前面的优先
+	compressors []Compressor
+	// minSize 小于这个大小的响应不压缩，因为压缩之后可能反而更大
+	minSize int
+	// skipTypes 这些类型的数据本身已经是压缩过的，再压缩也没有意义
+	// 按照前缀匹配，例如 image/ 会匹配所有的图片
+	skipTypes []string
+}
+
+func NewBuilder() *MiddlewareBuilder {
+	return &MiddlewareBuilder{
+		compressors: []Compressor{
+			NewBrotliCompressor(brotli.DefaultCompression),
+			NewGzipCompressor(gzip.DefaultCompression),
+			NewDeflateCompressor(zlib.DefaultCompression),
+		},
+		minSize: 1024,
+		skipTypes: []string{
+			"image/", "video/", "audio/", "font/woff",
+			"application/zip", "application/gzip", "application/x-gzip",
+			"application/x-7z-compressed", "application/x-rar-compressed",
+			"application/pdf", "application/wasm", "application/octet-stream",
+		},
+	}
+}
+
+// Compressors 替换所有的压缩算法，按照优先级排列
+func (b *MiddlewareBuilder) Compressors(cs ...Compressor) *MiddlewareBuilder {
+	b.compressors = cs
+	return b
+}
+
+// MinSize 设置压缩的阈值，单位是字节
+func (b *MiddlewareBuilder) MinSize(size int) *MiddlewareBuilder {
+	b.minSize = size
+	return b
+}
+
+// SkipContentTypes 追加不需要压缩的 Content-Type，按照前缀匹配
+func (b *MiddlewareBuilder) SkipContentTypes(types ...string) *MiddlewareBuilder {
+	b.skipTypes = append(b.skipTypes, types...)
+	return b
+}
+
+func (b *MiddlewareBuilder) Build() web.Middleware {
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			next(ctx)
+			if !b.compressible(ctx) {
+				return
+			}
+			header := ctx.Resp.Header()
+			// 不管最终有没有压缩，只要响应可能因为 Accept-Encoding 不同而不同，
+			// 就要告诉缓存服务器
+			addVary(header, "Accept-Encoding")
+			c := b.negotiate(ctx.Req.Header.Get("Accept-Encoding"))
+			if c == nil {
+				return
+			}
+			data, err := c.Compress(ctx.RespData)
+			// 压缩失败或者压缩之后反而更大，那就返回原始数据
+			if err != nil || len(data) >= len(ctx.RespData) {
+				return
+			}
+			ctx.RespData = data
+			header.Set("Content-Encoding", c.Name())
+			// Content-Length 会在 flashResp 里面根据 RespData 重新设置
+			header.Del("Content-Length")
+			// 压缩之后的内容和原始内容字节上不同了，强 ETag 需要降级为弱 ETag
+			if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+				header.Set("ETag", "W/"+etag)
+			}
+		}
+	}
+}
+
+func (b *MiddlewareBuilder) compressible(ctx *web.Context) bool {
+	if len(ctx.RespData) < b.minSize {
+		return false
+	}
+	code := ctx.RespStatusCode
+	if code == 0 {
+		code = http.StatusOK
+	}
+	// 206 的响应体是原始数据的一部分，不能压缩
+	if code < 200 || code == http.StatusNoContent ||
+		code == http.StatusPartialContent || code == http.StatusNotModified {
+		return false
+	}
+	header := ctx.Resp.Header()
+	if header.Get("Content-Encoding") != "" ||
+		strings.Contains(header.Get("Cache-Control"), "no-transform") {
+		return false
+	}
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		// 压缩之后 net/http 就没法根据内容推断类型了，所以要在压缩之前推断出来
+		contentType = http.DetectContentType(ctx.RespData)
+		header.Set("Content-Type", contentType)
+	}
+	// image/svg+xml 是文本，压缩效果很好
+	if strings.HasPrefix(contentType, "image/svg+xml") {
+		return true
+	}
+	for _, t := range b.skipTypes {
+		if strings.HasPrefix(contentType, t) {
+			return false
+		}
+	}
+	return true
+}
+
+// negotiate 根据 Accept-Encoding 选择压缩算法，例如 gzip;q=0.8, br
+// q 值最高的胜出；q 值一样的时候，按照 compressors 的顺序
+func (b *MiddlewareBuilder) negotiate(acceptEncoding string) Compressor {
+	if acceptEncoding == "" {
+		return nil
+	}
+	qs := make(map[string]float64, 4)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			parsed, err := strconv.ParseFloat(params[2:], 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		qs[name] = q
+	}
+	var (
+		res   Compressor
+		bestQ float64
+	)
+	for _, c := range b.compressors {
+		q, ok := qs[c.Name()]
+		if !ok {
+			q, ok = qs["*"]
+		}
+		if !ok || q <= 0 {
+			continue
+		}
+		if q > bestQ {
+			res, bestQ = c, q
+		}
+	}
+	return res
+}
+
+func addVary(header http.Header, val string) {
+	for _, v := range header.Values("Vary") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), val) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", val)
+}
@@ -0,0 +1,200 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"gitee.com/geektime-geekbang/geektime-go/web"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCompressor(t *testing.T) {
+	input := []byte(strings.Repeat("hello, world", 100))
+	testCases := []struct {
+		name string
+		c    Compressor
+	}{
+		{name: "gzip", c: NewGzipCompressor(gzip.BestSpeed)},
+		{name: "deflate", c: NewDeflateCompressor(-1)},
+		{name: "brotli", c: NewBrotliCompressor(4)},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := tc.c.Compress(input)
+			require.NoError(t, err)
+			assert.Less(t, len(data), len(input))
+			data, err = tc.c.Uncompress(data)
+			require.NoError(t, err)
+			assert.Equal(t, input, data)
+		})
+	}
+}
+
+// TestDeflateCompressor_Zlib HTTP 的 deflate 是 zlib 格式，标准的 zlib 解码器要能解开
+func TestDeflateCompressor_Zlib(t *testing.T) {
+	input := []byte(strings.Repeat("hello, world", 100))
+	data, err := NewDeflateCompressor(zlib.BestSpeed).Compress(input)
+	require.NoError(t, err)
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	defer zr.Close()
+	output, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, input, output)
+}
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	html := strings.Repeat("<p>hello, world</p>", 100)
+	testCases := []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		respHeader     http.Header
+		data           string
+		code           int
+
+		wantEncoding string
+		wantVary     bool
+	}{
+		{
+			name:           "gzip",
+			acceptEncoding: "gzip, deflate",
+			contentType:    "text/html; charset=utf-8",
+			data:           html,
+			wantEncoding:   "gzip",
+			wantVary:       true,
+		},
+		{
+			name:           "br first",
+			acceptEncoding: "gzip, deflate, br",
+			data:           html,
+			wantEncoding:   "br",
+			wantVary:       true,
+		},
+		{
+			name:           "q value",
+			acceptEncoding: "br;q=0.5, deflate;q=0.8, gzip;q=0",
+			data:           html,
+			wantEncoding:   "deflate",
+			wantVary:       true,
+		},
+		{
+			name:           "wildcard",
+			acceptEncoding: "*",
+			data:           html,
+			wantEncoding:   "br",
+			wantVary:       true,
+		},
+		{
+			name:           "identity only",
+			acceptEncoding: "identity",
+			data:           html,
+			wantVary:       true,
+		},
+		{
+			name:           "too small",
+			acceptEncoding: "gzip",
+			data:           "hello",
+		},
+		{
+			name:           "already compressed type",
+			acceptEncoding: "gzip",
+			contentType:    "image/png",
+			data:           html,
+		},
+		{
+			name:           "already encoded",
+			acceptEncoding: "gzip",
+			respHeader:     http.Header{"Content-Encoding": []string{"br"}},
+			data:           html,
+		},
+		{
+			name:           "no transform",
+			acceptEncoding: "gzip",
+			respHeader:     http.Header{"Cache-Control": []string{"no-transform"}},
+			data:           html,
+		},
+		{
+			name:           "no content",
+			acceptEncoding: "gzip",
+			code:           http.StatusNoContent,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := web.NewHTTPServer()
+			s.Use(http.MethodGet, "/", NewBuilder().Build())
+			s.Get("/", func(ctx *web.Context) {
+				for key, vals := range tc.respHeader {
+					ctx.Resp.Header()[key] = vals
+				}
+				if tc.contentType != "" {
+					ctx.Resp.Header().Set("Content-Type", tc.contentType)
+				}
+				ctx.RespStatusCode = http.StatusOK
+				if tc.code != 0 {
+					ctx.RespStatusCode = tc.code
+				}
+				ctx.RespData = []byte(tc.data)
+			})
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, req)
+
+			assert.Equal(t, tc.wantVary, recorder.Header().Get("Vary") == "Accept-Encoding")
+			if tc.wantEncoding == "" {
+				assert.NotEqual(t, "gzip", recorder.Header().Get("Content-Encoding"))
+				assert.Equal(t, tc.data, recorder.Body.String())
+				return
+			}
+			assert.Equal(t, tc.wantEncoding, recorder.Header().Get("Content-Encoding"))
+			// 没有设置 Content-Type 的时候，需要在压缩之前推断出来
+			assert.True(t, strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/html"))
+			assert.Equal(t, recorder.Header().Get("Content-Length"),
+				strconv.Itoa(recorder.Body.Len()))
+			var c Compressor
+			for _, candidate := range NewBuilder().compressors {
+				if candidate.Name() == tc.wantEncoding {
+					c = candidate
+				}
+			}
+			data, err := c.Uncompress(recorder.Body.Bytes())
+			require.NoError(t, err)
+			assert.Equal(t, tc.data, string(data))
+		})
+	}
+}
+
+func TestMiddlewareBuilder_StaticResource(t *testing.T) {
+	dir := t.TempDir()
+	content := strings.Repeat("body { color: red; }\n", 100)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app.css"), []byte(content), 0644))
+
+	s := web.NewHTTPServer()
+	handler := web.NewStaticResourceHandler(dir, "/static",
+		web.WithMoreExtension(map[string]string{"css": "text/css"}))
+	s.Use(http.MethodGet, "/static", NewBuilder().Build())
+	s.Get("/static/:file", handler.Handle)
+
+	req := httptest.NewRequest(http.MethodGet, "/static/app.css", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, req)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "gzip", recorder.Header().Get("Content-Encoding"))
+	assert.Equal(t, "text/css", recorder.Header().Get("Content-Type"))
+	data, err := NewGzipCompressor(gzip.DefaultCompression).Uncompress(recorder.Body.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, content, string(data))
+}
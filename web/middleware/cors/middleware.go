@@ -3,31 +3,221 @@ package cors
 import (
 	"gitee.com/geektime-geekbang/geektime-go/web"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// Policy 跨域策略
+type Policy struct {
+	// AllowOrigins 允许的来源，支持：
+	// - 完整的来源，例如 https://example.com
+	// - 通配子域名，例如 https://*.example.com，不包含 https://example.com 本身
+	// - * 允许所有来源，此时不能和 AllowCredentials 一起使用
+	AllowOrigins []string
+	// AllowOriginRegexps 用正则表达式匹配来源，例如 ^https://.*\.example\.(com|cn)$
+	AllowOriginRegexps []string
+	// AllowOriginFunc 自定义的校验，例如从数据库里面查询
+	AllowOriginFunc func(origin string) bool
+
+	// AllowMethods 默认是 GET, HEAD, POST, PUT, PATCH, DELETE
+	AllowMethods []string
+	// AllowHeaders 默认是 Content-Type，* 代表允许请求的所有头部
+	AllowHeaders []string
+	// ExposeHeaders 允许前端读取的响应头部
+	ExposeHeaders []string
+	// AllowCredentials 是否允许带上 cookie
+	AllowCredentials bool
+	// MaxAge 预检请求的结果可以缓存多久，0 代表不设置
+	MaxAge time.Duration
+}
+
+// MiddlewareBuilder 跨域
+// 注意要用 UseAny 注册，否则预检的 OPTIONS 请求是不会经过这个 Middleware 的
+//
+// 不兼容的改动：以前什么都不设置的时候，会把请求的 Origin 原样返回并且允许带上 cookie，
+// 相当于任何网站都可以带着用户的 cookie 调用接口。现在零值是 Access-Control-Allow-Origin: *
+// 并且不允许带上 cookie。需要 cookie 的请设置具体的 AllowOrigins 和 AllowCredentials
 type MiddlewareBuilder struct {
+	// AllowOrigin 只允许一个来源，和以前一样会允许带上 cookie
+	// Deprecated: 使用 AllowOrigins 和 AllowCredentials
 	AllowOrigin string
+	// Policy 默认的策略，什么都不设置的时候允许所有来源，但是不允许带上 cookie
+	Policy
+	// PathPolicies 按照路径前缀覆盖默认的策略，最长的前缀优先，例如 /api/open
+	// 这里用的是路径而不是命中的路由，因为预检请求一般是没有注册路由的
+	PathPolicies map[string]Policy
 }
 
 func (m MiddlewareBuilder) Build() web.Middleware {
+	def := m.Policy
+	if m.AllowOrigin != "" {
+		def.AllowOrigins = append(def.AllowOrigins, m.AllowOrigin)
+		// 保持以前的行为，但是 * 是不能和 credentials 一起用的，浏览器也不认
+		if m.AllowOrigin != "*" {
+			def.AllowCredentials = true
+		}
+	}
+	defPolicy := compile(def)
+	pathPolicies := make(map[string]*compiledPolicy, len(m.PathPolicies))
+	for prefix, p := range m.PathPolicies {
+		pathPolicies[prefix] = compile(p)
+	}
 	return func(next web.HandleFunc) web.HandleFunc {
 		return func(ctx *web.Context) {
-			allowOrigin := m.AllowOrigin
-			if allowOrigin == "" {
-				allowOrigin = ctx.Req.Header.Get("Origin")
+			p := defPolicy
+			longest := -1
+			for prefix, pp := range pathPolicies {
+				if len(prefix) > longest && strings.HasPrefix(ctx.Req.URL.Path, prefix) {
+					p, longest = pp, len(prefix)
+				}
 			}
-			ctx.Resp.Header().Set("Access-Control-Allow-Origin", allowOrigin)
-			// ctx.Resp.Header().Set("Access-Control-Allow-Origin", "*")
-			ctx.Resp.Header().Set("Access-Control-Allow-Credentials", "true")
-			if ctx.Resp.Header().Get("Access-Control-Allow-Headers") == ""{
-				ctx.Resp.Header().Add("Access-Control-Allow-Headers", "Content-Type")
-			}
-			if ctx.Req.Method == http.MethodOptions {
-				ctx.RespStatusCode = 200
-				ctx.RespData = []byte("ok")
-				return
-			}
-			next(ctx)
+			p.handle(ctx, next)
+		}
+	}
+}
+
+type compiledPolicy struct {
+	allowAll  bool
+	exact     map[string]struct{}
+	wildcards [][2]string
+	regexps   []*regexp.Regexp
+	fn        func(origin string) bool
+
+	methods          string
+	allowAllHeaders  bool
+	headers          string
+	exposeHeaders    string
+	allowCredentials bool
+	maxAge           string
+}
+
+func compile(p Policy) *compiledPolicy {
+	res := &compiledPolicy{
+		exact:            make(map[string]struct{}, len(p.AllowOrigins)),
+		fn:               p.AllowOriginFunc,
+		allowCredentials: p.AllowCredentials,
+		exposeHeaders:    strings.Join(p.ExposeHeaders, ", "),
+	}
+	for _, origin := range p.AllowOrigins {
+		origin = strings.ToLower(origin)
+		switch {
+		case origin == "*":
+			res.allowAll = true
+		case strings.Contains(origin, "*."):
+			scheme, host, _ := strings.Cut(origin, "*")
+			res.wildcards = append(res.wildcards, [2]string{scheme, host})
+		default:
+			res.exact[origin] = struct{}{}
+		}
+	}
+	for _, expr := range p.AllowOriginRegexps {
+		res.regexps = append(res.regexps, regexp.MustCompile(expr))
+	}
+	// 什么都没有设置，那么就允许所有的来源
+	if len(p.AllowOrigins) == 0 && len(res.regexps) == 0 && res.fn == nil {
+		res.allowAll = true
+	}
+	if res.allowAll && res.allowCredentials {
+		panic("cors: 允许所有来源的时候不能允许 credentials，请设置具体的 AllowOrigins")
+	}
+	methods := p.AllowMethods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodHead, http.MethodPost,
+			http.MethodPut, http.MethodPatch, http.MethodDelete}
+	}
+	res.methods = strings.Join(methods, ", ")
+	headers := p.AllowHeaders
+	if len(headers) == 0 {
+		headers = []string{"Content-Type"}
+	}
+	for _, h := range headers {
+		if h == "*" {
+			res.allowAllHeaders = true
 		}
 	}
-}
\ No newline at end of file
+	res.headers = strings.Join(headers, ", ")
+	if p.MaxAge > 0 {
+		res.maxAge = strconv.Itoa(int(p.MaxAge.Seconds()))
+	}
+	return res
+}
+
+func (p *compiledPolicy) allowed(origin string) bool {
+	if p.allowAll {
+		return true
+	}
+	lower := strings.ToLower(origin)
+	if _, ok := p.exact[lower]; ok {
+		return true
+	}
+	for _, w := range p.wildcards {
+		if strings.HasPrefix(lower, w[0]) && strings.HasSuffix(lower, w[1]) &&
+			len(lower) > len(w[0])+len(w[1]) {
+			return true
+		}
+	}
+	for _, r := range p.regexps {
+		if r.MatchString(origin) {
+			return true
+		}
+	}
+	return p.fn != nil && p.fn(origin)
+}
+
+func (p *compiledPolicy) handle(ctx *web.Context, next web.HandleFunc) {
+	header := ctx.Resp.Header()
+	// 响应的内容取决于 Origin，需要告诉缓存服务器
+	if !p.allowAll {
+		header.Add("Vary", "Origin")
+	}
+	origin := ctx.Req.Header.Get("Origin")
+	// 不是跨域请求
+	if origin == "" {
+		next(ctx)
+		return
+	}
+	preflight := ctx.Req.Method == http.MethodOptions &&
+		ctx.Req.Header.Get("Access-Control-Request-Method") != ""
+	if !p.allowed(origin) {
+		// 不允许的来源不返回任何 CORS 的头部，浏览器自然就会拦截
+		if preflight {
+			ctx.RespStatusCode = http.StatusForbidden
+			return
+		}
+		next(ctx)
+		return
+	}
+	if p.allowAll {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.allowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if !preflight {
+		if p.exposeHeaders != "" {
+			header.Set("Access-Control-Expose-Headers", p.exposeHeaders)
+		}
+		next(ctx)
+		return
+	}
+
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+	header.Set("Access-Control-Allow-Methods", p.methods)
+	if p.allowAllHeaders {
+		// 带 credentials 的时候浏览器不认 *，所以直接把请求的头部返回去
+		if reqHeaders := ctx.Req.Header.Get("Access-Control-Request-Headers"); reqHeaders != "" {
+			header.Set("Access-Control-Allow-Headers", reqHeaders)
+		}
+	} else {
+		header.Set("Access-Control-Allow-Headers", p.headers)
+	}
+	if p.maxAge != "" {
+		header.Set("Access-Control-Max-Age", p.maxAge)
+	}
+	ctx.RespStatusCode = http.StatusNoContent
+}
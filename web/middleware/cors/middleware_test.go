@@ -0,0 +1,222 @@
+package cors
+
+import (
+	"gitee.com/geektime-geekbang/geektime-go/web"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	s := web.NewHTTPServer()
+	s.UseAny("/*", MiddlewareBuilder{
+		Policy: Policy{
+			AllowOrigins:       []string{"https://example.com", "https://*.example.com"},
+			AllowOriginRegexps: []string{`^https://app-\d+\.test\.com$`},
+			AllowHeaders:       []string{"Content-Type", "Authorization"},
+			ExposeHeaders:      []string{"X-Request-Id"},
+			AllowCredentials:   true,
+			MaxAge:             time.Hour,
+		},
+		PathPolicies: map[string]Policy{
+			// 开放接口，任何人都可以调用，但是不能带 cookie
+			"/open": {
+				AllowOrigins: []string{"*"},
+				AllowMethods: []string{http.MethodGet},
+				AllowHeaders: []string{"*"},
+			},
+		},
+	}.Build())
+	handler := func(ctx *web.Context) {
+		ctx.RespStatusCode = http.StatusOK
+		ctx.RespData = []byte("ok")
+	}
+	s.Get("/user", handler)
+	s.Post("/user", handler)
+	s.Get("/open/data", handler)
+
+	testCases := []struct {
+		name   string
+		method string
+		path   string
+		header http.Header
+
+		wantCode   int
+		wantBody   string
+		wantHeader http.Header
+	}{
+		{
+			name:     "same origin",
+			method:   http.MethodGet,
+			path:     "/user",
+			wantCode: http.StatusOK,
+			wantBody: "ok",
+			wantHeader: http.Header{
+				"Vary": []string{"Origin"},
+			},
+		},
+		{
+			name:     "exact origin",
+			method:   http.MethodGet,
+			path:     "/user",
+			header:   http.Header{"Origin": []string{"https://example.com"}},
+			wantCode: http.StatusOK,
+			wantBody: "ok",
+			wantHeader: http.Header{
+				"Vary":                             []string{"Origin"},
+				"Access-Control-Allow-Origin":      []string{"https://example.com"},
+				"Access-Control-Allow-Credentials": []string{"true"},
+				"Access-Control-Expose-Headers":    []string{"X-Request-Id"},
+			},
+		},
+		{
+			name:     "wildcard subdomain",
+			method:   http.MethodGet,
+			path:     "/user",
+			header:   http.Header{"Origin": []string{"https://a.b.example.com"}},
+			wantCode: http.StatusOK,
+			wantBody: "ok",
+			wantHeader: http.Header{
+				"Vary":                             []string{"Origin"},
+				"Access-Control-Allow-Origin":      []string{"https://a.b.example.com"},
+				"Access-Control-Allow-Credentials": []string{"true"},
+				"Access-Control-Expose-Headers":    []string{"X-Request-Id"},
+			},
+		},
+		{
+			name:     "regexp",
+			method:   http.MethodGet,
+			path:     "/user",
+			header:   http.Header{"Origin": []string{"https://app-12.test.com"}},
+			wantCode: http.StatusOK,
+			wantBody: "ok",
+			wantHeader: http.Header{
+				"Vary":                             []string{"Origin"},
+				"Access-Control-Allow-Origin":      []string{"https://app-12.test.com"},
+				"Access-Control-Allow-Credentials": []string{"true"},
+				"Access-Control-Expose-Headers":    []string{"X-Request-Id"},
+			},
+		},
+		{
+			// 请求照样处理，但是不会有任何 CORS 的头部
+			name:     "disallowed origin",
+			method:   http.MethodGet,
+			path:     "/user",
+			header:   http.Header{"Origin": []string{"https://evil-example.com"}},
+			wantCode: http.StatusOK,
+			wantBody: "ok",
+			wantHeader: http.Header{
+				"Vary": []string{"Origin"},
+			},
+		},
+		{
+			name:   "preflight",
+			method: http.MethodOptions,
+			path:   "/user",
+			header: http.Header{
+				"Origin":                         []string{"https://example.com"},
+				"Access-Control-Request-Method":  []string{http.MethodPost},
+				"Access-Control-Request-Headers": []string{"Content-Type"},
+			},
+			wantCode: http.StatusNoContent,
+			wantHeader: http.Header{
+				"Vary": []string{"Origin", "Access-Control-Request-Method",
+					"Access-Control-Request-Headers"},
+				"Access-Control-Allow-Origin":      []string{"https://example.com"},
+				"Access-Control-Allow-Credentials": []string{"true"},
+				"Access-Control-Allow-Methods":     []string{"GET, HEAD, POST, PUT, PATCH, DELETE"},
+				"Access-Control-Allow-Headers":     []string{"Content-Type, Authorization"},
+				"Access-Control-Max-Age":           []string{"3600"},
+			},
+		},
+		{
+			name:   "preflight disallowed origin",
+			method: http.MethodOptions,
+			path:   "/user",
+			header: http.Header{
+				"Origin":                        []string{"https://example.com.evil.com"},
+				"Access-Control-Request-Method": []string{http.MethodPost},
+			},
+			wantCode: http.StatusForbidden,
+			wantHeader: http.Header{
+				"Vary": []string{"Origin"},
+			},
+		},
+		{
+			name:     "path policy",
+			method:   http.MethodGet,
+			path:     "/open/data",
+			header:   http.Header{"Origin": []string{"https://evil.com"}},
+			wantCode: http.StatusOK,
+			wantBody: "ok",
+			wantHeader: http.Header{
+				"Access-Control-Allow-Origin": []string{"*"},
+			},
+		},
+		{
+			name:   "path policy preflight",
+			method: http.MethodOptions,
+			path:   "/open/data",
+			header: http.Header{
+				"Origin":                         []string{"https://evil.com"},
+				"Access-Control-Request-Method":  []string{http.MethodGet},
+				"Access-Control-Request-Headers": []string{"X-Custom"},
+			},
+			wantCode: http.StatusNoContent,
+			wantHeader: http.Header{
+				"Vary":                         []string{"Access-Control-Request-Method", "Access-Control-Request-Headers"},
+				"Access-Control-Allow-Origin":  []string{"*"},
+				"Access-Control-Allow-Methods": []string{"GET"},
+				"Access-Control-Allow-Headers": []string{"X-Custom"},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			for k, vals := range tc.header {
+				req.Header[k] = vals
+			}
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			assert.Equal(t, tc.wantBody, recorder.Body.String())
+			header := recorder.Header()
+			// 这些头部是框架设置的，和跨域没关系
+			header.Del("Content-Length")
+			header.Del("Content-Type")
+			header.Del("Allow")
+			assert.Equal(t, tc.wantHeader, header)
+		})
+	}
+}
+
+func TestMiddlewareBuilder_AllowOrigin(t *testing.T) {
+	// 兼容以前的用法
+	mdl := MiddlewareBuilder{AllowOrigin: "http://localhost:8080"}.Build()
+	ctx := &web.Context{
+		Req:  httptest.NewRequest(http.MethodGet, "/", nil),
+		Resp: httptest.NewRecorder(),
+	}
+	ctx.Req.Header.Set("Origin", "http://localhost:8080")
+	mdl(func(ctx *web.Context) {})(ctx)
+	assert.Equal(t, "http://localhost:8080", ctx.Resp.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", ctx.Resp.Header().Get("Access-Control-Allow-Credentials"))
+
+	// * 不能带上 credentials
+	mdl = MiddlewareBuilder{AllowOrigin: "*"}.Build()
+	ctx = &web.Context{
+		Req:  httptest.NewRequest(http.MethodGet, "/", nil),
+		Resp: httptest.NewRecorder(),
+	}
+	ctx.Req.Header.Set("Origin", "http://localhost:8080")
+	mdl(func(ctx *web.Context) {})(ctx)
+	assert.Equal(t, "*", ctx.Resp.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "", ctx.Resp.Header().Get("Access-Control-Allow-Credentials"))
+
+	assert.Panics(t, func() {
+		MiddlewareBuilder{Policy: Policy{AllowCredentials: true}}.Build()
+	})
+}
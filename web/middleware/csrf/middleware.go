@@ -0,0 +1,244 @@
+package csrf
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"gitee.com/geektime-geekbang/geektime-go/web"
+	"gitee.com/geektime-geekbang/geektime-go/web/session"
+	"log"
+	"net/http"
+)
+
+var (
+	errNoToken       = errors.New("csrf: 请求没有带上 token")
+	errTokenMismatch = errors.New("csrf: token 不匹配")
+)
+
+const (
+	// TplKey 模板里面通过 {{.CSRFToken}} 拿到 token
+	TplKey = "CSRFToken"
+	// userValueKey token 在 ctx.UserValues 里面的 key
+	userValueKey = "csrf_token"
+)
+
+// Token 拿到当前请求的 CSRF token
+// 如果模板的数据不是 map，那么需要自己把 token 放到数据里面
+func Token(ctx *web.Context) string {
+	if ctx.UserValues == nil {
+		return ""
+	}
+	token, _ := ctx.UserValues[userValueKey].(string)
+	return token
+}
+
+// MiddlewareBuilder CSRF 防护
+// 有两种模式：
+// - session 模式：token 保存在 session 里面，每个 session 一个 token
+// - double submit cookie 模式：token 保存在 cookie 里面，适合没有 session 的无状态 API
+// 对于 POST, PUT, DELETE 之类的请求，要求在头部或者表单里面带上 token
+type MiddlewareBuilder struct {
+	// sessMgr 为 nil 的时候，就是 double submit cookie 模式
+	sessMgr *session.Manager
+	// sessKey token 在 session 里面的 key
+	sessKey string
+
+	cookieName string
+	cookieOpt  func(c *http.Cookie)
+
+	headerName string
+	formField  string
+
+	// exempt 不需要校验的路由，例如第三方的回调
+	exempt     map[string]struct{}
+	exemptFunc func(ctx *web.Context) bool
+
+	errHandler func(ctx *web.Context, err error)
+}
+
+// NewBuilder session 模式
+// 注意没有 session 的请求是没办法拿到 token 的，例如登录请求，
+// 所以要么把登录排除掉，要么使用 double submit cookie 模式
+func NewBuilder(m *session.Manager) *MiddlewareBuilder {
+	res := newBuilder()
+	res.sessMgr = m
+	return res
+}
+
+// NewDoubleSubmitBuilder double submit cookie 模式
+// token 同时放在 cookie 和请求头（或者表单）里面，攻击者没办法读到 cookie，所以没办法伪造请求头
+func NewDoubleSubmitBuilder() *MiddlewareBuilder {
+	return newBuilder()
+}
+
+func newBuilder() *MiddlewareBuilder {
+	return &MiddlewareBuilder{
+		sessKey:    "csrf_token",
+		cookieName: "csrf_token",
+		cookieOpt:  func(c *http.Cookie) {},
+		headerName: "X-CSRF-Token",
+		formField:  "csrf_token",
+		exempt:     map[string]struct{}{},
+		errHandler: func(ctx *web.Context, err error) {
+			log.Printf("CSRF 校验失败 %s %s: %v", ctx.Req.Method, ctx.Req.URL.Path, err)
+			ctx.RespStatusCode = http.StatusForbidden
+			ctx.RespData = []byte(http.StatusText(http.StatusForbidden))
+		},
+	}
+}
+
+// HeaderName 设置从哪个头部拿 token，默认是 X-CSRF-Token
+func (b *MiddlewareBuilder) HeaderName(name string) *MiddlewareBuilder {
+	b.headerName = name
+	return b
+}
+
+// FormField 设置从哪个表单字段拿 token，默认是 csrf_token
+func (b *MiddlewareBuilder) FormField(name string) *MiddlewareBuilder {
+	b.formField = name
+	return b
+}
+
+// CookieName 设置 double submit cookie 模式下 cookie 的名字，默认是 csrf_token
+func (b *MiddlewareBuilder) CookieName(name string) *MiddlewareBuilder {
+	b.cookieName = name
+	return b
+}
+
+// CookieOption 设置 double submit cookie 模式下的 cookie，例如 Secure, Domain
+func (b *MiddlewareBuilder) CookieOption(opt func(c *http.Cookie)) *MiddlewareBuilder {
+	b.cookieOpt = opt
+	return b
+}
+
+// Exempt 排除掉这些路由，用的是注册路由时候的路径，例如 /callback/:id
+func (b *MiddlewareBuilder) Exempt(routes ...string) *MiddlewareBuilder {
+	for _, r := range routes {
+		b.exempt[r] = struct{}{}
+	}
+	return b
+}
+
+// ExemptFunc 返回 true 的请求不校验 token
+func (b *MiddlewareBuilder) ExemptFunc(fn func(ctx *web.Context) bool) *MiddlewareBuilder {
+	b.exemptFunc = fn
+	return b
+}
+
+// ErrHandler 校验失败的时候的处理，默认返回 403
+func (b *MiddlewareBuilder) ErrHandler(fn func(ctx *web.Context, err error)) *MiddlewareBuilder {
+	b.errHandler = fn
+	return b
+}
+
+func (b *MiddlewareBuilder) Build() web.Middleware {
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			if b.isExempt(ctx) {
+				next(ctx)
+				return
+			}
+			token, err := b.token(ctx)
+			if err != nil && !safeMethod(ctx.Req.Method) {
+				b.errHandler(ctx, err)
+				return
+			}
+			if token != "" {
+				if ctx.UserValues == nil {
+					ctx.UserValues = make(map[string]any, 1)
+				}
+				ctx.UserValues[userValueKey] = token
+				ctx.AddTplValue(TplKey, token)
+			}
+			if !safeMethod(ctx.Req.Method) && !b.validate(ctx, token) {
+				b.errHandler(ctx, errTokenMismatch)
+				return
+			}
+			next(ctx)
+		}
+	}
+}
+
+func (b *MiddlewareBuilder) isExempt(ctx *web.Context) bool {
+	if _, ok := b.exempt[ctx.MatchedRoute]; ok && ctx.MatchedRoute != "" {
+		return true
+	}
+	return b.exemptFunc != nil && b.exemptFunc(ctx)
+}
+
+// token 拿到当前的 token，没有的话就生成一个
+func (b *MiddlewareBuilder) token(ctx *web.Context) (string, error) {
+	if b.sessMgr == nil {
+		return b.cookieToken(ctx)
+	}
+	sess, err := b.sessMgr.GetSession(ctx)
+	if err != nil {
+		return "", err
+	}
+	token, err := sess.Get(ctx.Req.Context(), b.sessKey)
+	if err == nil && token != "" {
+		return token, nil
+	}
+	token, err = newToken()
+	if err != nil {
+		return "", err
+	}
+	if err = sess.Set(ctx.Req.Context(), b.sessKey, token); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (b *MiddlewareBuilder) cookieToken(ctx *web.Context) (string, error) {
+	if c, err := ctx.Req.Cookie(b.cookieName); err == nil && c.Value != "" {
+		return c.Value, nil
+	}
+	// 不安全的请求必须带着 cookie 过来，新生成的 token 是不可能校验通过的
+	if !safeMethod(ctx.Req.Method) {
+		return "", errNoToken
+	}
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	cookie := &http.Cookie{
+		Name:  b.cookieName,
+		Value: token,
+		Path:  "/",
+		// 前端的 JS 需要读取 cookie 然后放到请求头里面，所以不能是 HttpOnly
+		HttpOnly: false,
+		SameSite: http.SameSiteLaxMode,
+	}
+	b.cookieOpt(cookie)
+	ctx.SetCookie(cookie)
+	return token, nil
+}
+
+func (b *MiddlewareBuilder) validate(ctx *web.Context, token string) bool {
+	if token == "" {
+		return false
+	}
+	provided := ctx.Req.Header.Get(b.headerName)
+	if provided == "" {
+		provided, _ = ctx.FormValue(b.formField).String()
+	}
+	return provided != "" && subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1
+}
+
+// safeMethod 参考 RFC 7231 4.2.1，这些方法不应该修改数据，所以不需要校验
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+func newToken() (string, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
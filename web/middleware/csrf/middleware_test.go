@@ -0,0 +1,165 @@
+package csrf
+
+import (
+	"context"
+	"gitee.com/geektime-geekbang/geektime-go/web"
+	"gitee.com/geektime-geekbang/geektime-go/web/session"
+	"gitee.com/geektime-geekbang/geektime-go/web/session/cookie"
+	"gitee.com/geektime-geekbang/geektime-go/web/session/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareBuilder_Session(t *testing.T) {
+	m := &session.Manager{
+		Store:      memory.NewStore(time.Minute),
+		Propagator: cookie.NewPropagator("sessid"),
+		SessCtxKey: "sess",
+	}
+	_, err := m.Generate(context.Background(), "sess-1")
+	require.NoError(t, err)
+
+	tpl, err := template.New("form").Parse(
+		`<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">`)
+	require.NoError(t, err)
+	s := web.NewHTTPServer(web.ServerWithTemplateEngine(&web.GoTemplateEngine{T: tpl}))
+	s.UseAny("/*", NewBuilder(m).Exempt("/callback/:id").Build())
+	s.Get("/form", func(ctx *web.Context) {
+		_ = ctx.Render("form", nil)
+	})
+	handler := func(ctx *web.Context) {
+		ctx.RespStatusCode = http.StatusOK
+		ctx.RespData = []byte("ok")
+	}
+	s.Post("/form", handler)
+	s.Post("/callback/:id", handler)
+
+	// 先拿到 token
+	req := httptest.NewRequest(http.MethodGet, "/form", nil)
+	req.AddCookie(&http.Cookie{Name: "sessid", Value: "sess-1"})
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	sess, err := m.Get(context.Background(), "sess-1")
+	require.NoError(t, err)
+	token, err := sess.Get(context.Background(), "csrf_token")
+	require.NoError(t, err)
+	assert.Equal(t, `<input type="hidden" name="csrf_token" value="`+token+`">`, recorder.Body.String())
+
+	testCases := []struct {
+		name     string
+		path     string
+		sessID   string
+		header   string
+		form     url.Values
+		wantCode int
+	}{
+		{
+			name:     "header",
+			path:     "/form",
+			sessID:   "sess-1",
+			header:   token,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "form",
+			path:     "/form",
+			sessID:   "sess-1",
+			form:     url.Values{"csrf_token": []string{token}},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "no token",
+			path:     "/form",
+			sessID:   "sess-1",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "wrong token",
+			path:     "/form",
+			sessID:   "sess-1",
+			header:   "abc",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "no session",
+			path:     "/form",
+			header:   token,
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "exempt",
+			path:     "/callback/123",
+			wantCode: http.StatusOK,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tc.sessID != "" {
+				req.AddCookie(&http.Cookie{Name: "sessid", Value: tc.sessID})
+			}
+			if tc.header != "" {
+				req.Header.Set("X-CSRF-Token", tc.header)
+			}
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantCode, recorder.Code)
+		})
+	}
+}
+
+func TestMiddlewareBuilder_DoubleSubmit(t *testing.T) {
+	s := web.NewHTTPServer()
+	s.UseAny("/api", NewDoubleSubmitBuilder().Build())
+	s.Get("/api/token", func(ctx *web.Context) {
+		ctx.RespStatusCode = http.StatusOK
+		ctx.RespData = []byte(Token(ctx))
+	})
+	s.Post("/api/order", func(ctx *web.Context) {
+		ctx.RespStatusCode = http.StatusOK
+	})
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/token", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	cookies := recorder.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "csrf_token", cookies[0].Name)
+	assert.Equal(t, recorder.Body.String(), cookies[0].Value)
+	token := cookies[0].Value
+
+	testCases := []struct {
+		name     string
+		cookie   string
+		header   string
+		wantCode int
+	}{
+		{name: "match", cookie: token, header: token, wantCode: http.StatusOK},
+		{name: "mismatch", cookie: token, header: "abc", wantCode: http.StatusForbidden},
+		{name: "no cookie", header: token, wantCode: http.StatusForbidden},
+		{name: "no header", cookie: token, wantCode: http.StatusForbidden},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/order", nil)
+			if tc.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "csrf_token", Value: tc.cookie})
+			}
+			if tc.header != "" {
+				req.Header.Set("X-CSRF-Token", tc.header)
+			}
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantCode, recorder.Code)
+		})
+	}
+}
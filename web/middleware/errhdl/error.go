@@ -0,0 +1,55 @@
+package errhdl
+
+import (
+	"gitee.com/geektime-geekbang/geektime-go/web"
+	"net/http"
+)
+
+// errKey 错误在 ctx.UserValues 里面的 key
+const errKey = "errhdl_error"
+
+// Error 带上了响应码的错误，handler 通过 Abort 交给 Middleware 处理
+type Error struct {
+	Code int
+	// Msg 会返回给前端，所以不要把内部的错误信息放进来
+	Msg string
+	// Err 内部的错误，只用于日志之类的
+	Err error
+}
+
+// NewError Msg 为空的时候使用 http.StatusText(code)
+func NewError(code int, msg string) *Error {
+	if msg == "" {
+		msg = http.StatusText(code)
+	}
+	return &Error{Code: code, Msg: msg}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Msg + ": " + e.Err.Error()
+	}
+	return e.Msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Abort 把错误交给 Middleware 处理，
+// 错误码由 *Error 或者 MiddlewareBuilder.MapError 决定，都不匹配的话就是 500
+func Abort(ctx *web.Context, err error) {
+	if ctx.UserValues == nil {
+		ctx.UserValues = make(map[string]any, 1)
+	}
+	ctx.UserValues[errKey] = err
+}
+
+// ErrorOf 拿到 handler 通过 Abort 设置的错误
+func ErrorOf(ctx *web.Context) error {
+	if ctx.UserValues == nil {
+		return nil
+	}
+	err, _ := ctx.UserValues[errKey].(error)
+	return err
+}
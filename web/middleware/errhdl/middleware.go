@@ -1,16 +1,44 @@
 package errhdl
 
-import "gitee.com/geektime-geekbang/geektime-go/web"
+import (
+	"errors"
+	"gitee.com/geektime-geekbang/geektime-go/web"
+	"log"
+	"net/http"
+	"strings"
+)
 
+// MiddlewareBuilder 统一处理错误响应
+// 对于注册了的错误码，按照下面的顺序处理：
+// 1. 注册了跳转的，直接跳转，例如 401 跳转到登录页面
+// 2. 请求的 Accept 是 JSON 的，返回 {"code": 404, "msg": "Not Found"}
+// 3. 按照路径前缀注册了错误数据的，返回最长前缀的错误数据
+// 4. 注册了模板的，用 server 的 TemplateEngine 渲染
+// 5. 注册了错误数据的，返回错误数据
+// 没有注册的错误码不做任何处理，handler 自己返回的错误数据会保留下来
 type MiddlewareBuilder struct {
 	resp     map[int][]byte
 	redirect map[int]string
+	// tpls 错误码对应的模板名字
+	tpls map[int]string
+	// prefixResp 路径前缀 => 错误码 => 错误数据
+	prefixResp map[string]map[int][]byte
+	// errMappings 将 handler 返回的错误映射为错误码
+	errMappings []errMapping
+}
+
+type errMapping struct {
+	target error
+	code   int
 }
 
 func NewMiddlewareBuilder() *MiddlewareBuilder {
 	return &MiddlewareBuilder{
 		// 这里可以非常大方，因为在预计中用户会关心的错误码不可能超过 64
-		resp: make(map[int][]byte, 64),
+		resp:       make(map[int][]byte, 64),
+		redirect:   make(map[int]string, 8),
+		tpls:       make(map[int]string, 8),
+		prefixResp: make(map[string]map[int][]byte, 8),
 	}
 }
 
@@ -21,14 +49,161 @@ func (m *MiddlewareBuilder) RegisterError(code int, resp []byte) *MiddlewareBuil
 	return m
 }
 
+// RegisterRedirect 遇到这个错误码的时候跳转到 url，例如 401 跳转到 /login
+func (m *MiddlewareBuilder) RegisterRedirect(code int, url string) *MiddlewareBuilder {
+	m.redirect[code] = url
+	return m
+}
+
+// RegisterTemplate 遇到这个错误码的时候渲染模板 tpl，模板可以拿到这些数据：
+// StatusCode, Msg, Method, Path, Query
+func (m *MiddlewareBuilder) RegisterTemplate(code int, tpl string) *MiddlewareBuilder {
+	m.tpls[code] = tpl
+	return m
+}
+
+// RegisterPrefixError 路径以 prefix 开头的请求，使用特定的错误数据，
+// 例如 /api 下面返回 JSON，别的返回页面
+func (m *MiddlewareBuilder) RegisterPrefixError(prefix string, code int, resp []byte) *MiddlewareBuilder {
+	codes, ok := m.prefixResp[prefix]
+	if !ok {
+		codes = make(map[int][]byte, 8)
+		m.prefixResp[prefix] = codes
+	}
+	codes[code] = resp
+	return m
+}
+
+// MapError 通过 Abort 交过来的错误，如果 errors.Is(err, target)，那么使用错误码 code
+func (m *MiddlewareBuilder) MapError(target error, code int) *MiddlewareBuilder {
+	m.errMappings = append(m.errMappings, errMapping{target: target, code: code})
+	return m
+}
+
 func (m *MiddlewareBuilder) Build() web.Middleware {
 	return func(next web.HandleFunc) web.HandleFunc {
 		return func(ctx *web.Context) {
 			next(ctx)
-			resp, ok := m.resp[ctx.RespStatusCode]
-			if ok {
+			msg := ""
+			if err := ErrorOf(ctx); err != nil {
+				ctx.RespStatusCode, msg = m.mapError(err)
+				ctx.RespData = []byte(msg)
+			} else if !m.registered(ctx) {
+				return
+			}
+			code := ctx.RespStatusCode
+			if msg == "" {
+				msg = http.StatusText(code)
+			}
+
+			if url, ok := m.redirect[code]; ok {
+				ctx.Resp.Header().Set("Location", url)
+				ctx.RespStatusCode = http.StatusFound
+				ctx.RespData = nil
+				return
+			}
+			if wantJSON(ctx.Req) {
+				_ = ctx.RespJSON(code, map[string]any{"code": code, "msg": msg})
+				return
+			}
+			if resp, ok := m.prefixErr(ctx.Req.URL.Path, code); ok {
+				ctx.RespData = resp
+				return
+			}
+			if tpl, ok := m.tpls[code]; ok && m.render(ctx, tpl, code, msg) {
+				return
+			}
+			if resp, ok := m.resp[code]; ok {
 				ctx.RespData = resp
 			}
 		}
 	}
 }
+
+// registered 错误码有没有注册任何处理
+func (m *MiddlewareBuilder) registered(ctx *web.Context) bool {
+	code := ctx.RespStatusCode
+	if _, ok := m.resp[code]; ok {
+		return true
+	}
+	if _, ok := m.redirect[code]; ok {
+		return true
+	}
+	if _, ok := m.tpls[code]; ok {
+		return true
+	}
+	_, ok := m.prefixErr(ctx.Req.URL.Path, code)
+	return ok
+}
+
+func (m *MiddlewareBuilder) mapError(err error) (int, string) {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code, e.Msg
+	}
+	for _, mapping := range m.errMappings {
+		if errors.Is(err, mapping.target) {
+			return mapping.code, http.StatusText(mapping.code)
+		}
+	}
+	// 不认识的错误，不能把错误信息返回给前端
+	log.Printf("errhdl: 未知错误 %v", err)
+	return http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError)
+}
+
+// prefixErr 最长前缀优先
+func (m *MiddlewareBuilder) prefixErr(path string, code int) ([]byte, bool) {
+	var (
+		res     []byte
+		found   bool
+		longest = -1
+	)
+	for prefix, codes := range m.prefixResp {
+		if len(prefix) <= longest || !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		if resp, ok := codes[code]; ok {
+			res, found, longest = resp, true, len(prefix)
+		}
+	}
+	return res, found
+}
+
+func (m *MiddlewareBuilder) render(ctx *web.Context, tpl string, code int, msg string) (ok bool) {
+	defer func() {
+		// 没有设置 TemplateEngine 的时候 Render 会 panic
+		if err := recover(); err != nil {
+			log.Printf("errhdl: 渲染模板 %s panic: %v", tpl, err)
+			ok = false
+		}
+	}()
+	data := ctx.RespData
+	err := ctx.Render(tpl, map[string]any{
+		"StatusCode": code,
+		"Msg":        msg,
+		"Method":     ctx.Req.Method,
+		"Path":       ctx.Req.URL.Path,
+		"Query":      ctx.Req.URL.Query(),
+	})
+	if err != nil {
+		log.Printf("errhdl: 渲染模板 %s 失败: %v", tpl, err)
+		ctx.RespData = data
+		ctx.RespStatusCode = code
+		return false
+	}
+	// Render 会把响应码设置为 200
+	ctx.RespStatusCode = code
+	ctx.Resp.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return true
+}
+
+// wantJSON 简单判断一下 Accept，要求 JSON 比 HTML 优先
+func wantJSON(req *http.Request) bool {
+	accept := req.Header.Get("Accept")
+	jsonIdx := strings.Index(accept, "application/json")
+	if jsonIdx < 0 {
+		return false
+	}
+	htmlIdx := strings.Index(accept, "text/html")
+	return htmlIdx < 0 || jsonIdx < htmlIdx
+}
@@ -2,12 +2,19 @@ package errhdl
 
 import (
 	"bytes"
+	"errors"
 	"gitee.com/geektime-geekbang/geektime-go/web"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"html/template"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
 func TestNewMiddlewareBuilder(t *testing.T) {
+	// 会启动服务器并且一直阻塞，手动运行的时候去掉这一行
+	t.Skip("手动运行的测试")
 	s := web.NewHTTPServer()
 	s.Get("/user", func(ctx *web.Context) {
 		ctx.RespData = []byte("hello, world")
@@ -26,8 +33,120 @@ func TestNewMiddlewareBuilder(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	s.Use(NewMiddlewareBuilder().
+	s.UseAny("/*", NewMiddlewareBuilder().
 		RegisterError(404, buffer.Bytes()).Build())
 
 	s.Start(":8081")
 }
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	errNoPermission := errors.New("没有权限")
+	tpl, err := template.New("500").Parse(`<h1>{{.StatusCode}} {{.Method}} {{.Path}}</h1>`)
+	require.NoError(t, err)
+	s := web.NewHTTPServer(web.ServerWithTemplateEngine(&web.GoTemplateEngine{T: tpl}))
+	s.UseAny("/*", NewMiddlewareBuilder().
+		RegisterError(http.StatusNotFound, []byte("页面不存在")).
+		RegisterPrefixError("/api", http.StatusNotFound, []byte(`{"msg":"资源不存在"}`)).
+		RegisterRedirect(http.StatusUnauthorized, "/login").
+		RegisterTemplate(http.StatusInternalServerError, "500").
+		MapError(errNoPermission, http.StatusForbidden).
+		Build())
+	s.Get("/status/:code", func(ctx *web.Context) {
+		code, _ := ctx.PathValue("code").ToInt64()
+		ctx.RespStatusCode = int(code)
+		ctx.RespData = []byte("handler")
+	})
+	s.Get("/api/:code", func(ctx *web.Context) {
+		code, _ := ctx.PathValue("code").ToInt64()
+		ctx.RespStatusCode = int(code)
+	})
+	s.Get("/abort/typed", func(ctx *web.Context) {
+		Abort(ctx, NewError(http.StatusBadRequest, "参数错误"))
+	})
+	s.Get("/abort/mapped", func(ctx *web.Context) {
+		Abort(ctx, errNoPermission)
+	})
+	s.Get("/abort/unknown", func(ctx *web.Context) {
+		Abort(ctx, errors.New("数据库崩了"))
+	})
+
+	testCases := []struct {
+		name   string
+		path   string
+		accept string
+
+		wantCode     int
+		wantBody     string
+		wantLocation string
+	}{
+		{
+			name:     "not registered",
+			path:     "/status/400",
+			wantCode: http.StatusBadRequest,
+			wantBody: "handler",
+		},
+		{
+			name:     "resp",
+			path:     "/status/404",
+			wantCode: http.StatusNotFound,
+			wantBody: "页面不存在",
+		},
+		{
+			name:     "prefix",
+			path:     "/api/404",
+			wantCode: http.StatusNotFound,
+			wantBody: `{"msg":"资源不存在"}`,
+		},
+		{
+			name:     "json",
+			path:     "/status/404",
+			accept:   "application/json",
+			wantCode: http.StatusNotFound,
+			wantBody: `{"code":404,"msg":"Not Found"}`,
+		},
+		{
+			name:         "redirect",
+			path:         "/status/401",
+			wantCode:     http.StatusFound,
+			wantLocation: "/login",
+		},
+		{
+			name:     "template",
+			path:     "/status/500",
+			accept:   "text/html,application/json",
+			wantCode: http.StatusInternalServerError,
+			wantBody: "<h1>500 GET /status/500</h1>",
+		},
+		{
+			name:     "typed error",
+			path:     "/abort/typed",
+			accept:   "application/json",
+			wantCode: http.StatusBadRequest,
+			wantBody: `{"code":400,"msg":"参数错误"}`,
+		},
+		{
+			name:     "mapped error",
+			path:     "/abort/mapped",
+			wantCode: http.StatusForbidden,
+			wantBody: "Forbidden",
+		},
+		{
+			// 不认识的错误不能把错误信息暴露出去
+			name:     "unknown error",
+			path:     "/abort/unknown",
+			wantCode: http.StatusInternalServerError,
+			wantBody: "<h1>500 GET /abort/unknown</h1>",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			req.Header.Set("Accept", tc.accept)
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			assert.Equal(t, tc.wantBody, recorder.Body.String())
+			assert.Equal(t, tc.wantLocation, recorder.Header().Get("Location"))
+		})
+	}
+}
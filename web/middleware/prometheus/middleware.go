@@ -1,43 +1,190 @@
 package prometheus
 
 import (
+	"bytes"
+	"errors"
 	"gitee.com/geektime-geekbang/geektime-go/web"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
 	"strconv"
 	"time"
 )
 
+// MiddlewareBuilder 统计 HTTP 请求，会注册这些指标：
+// - {Name}_duration_seconds 响应时间的直方图
+// - {Name}_in_flight 正在处理的请求数
+// - {Name}_request_size_bytes 请求体大小的直方图
+// - {Name}_response_size_bytes 响应体大小的直方图
+// 如果请求里面有 opentelemetry 的 span，那么会把 trace id 作为 exemplar 记录下来
+//
+// 不兼容的改动：以前注册的是名字为 {Name} 的 summary，单位是毫秒；
+// 现在响应时间是 {Name}_duration_seconds 的直方图，单位是秒。
+// 升级之后要同步修改 dashboard 和告警规则里面的指标名字
 type MiddlewareBuilder struct {
+	Namespace string
+	Subsystem string
+	// Name 指标名字的前缀，默认是 http_request
 	Name        string
-	Subsystem   string
 	ConstLabels map[string]string
 	Help        string
+
+	// Buckets 响应时间的分桶，单位是秒，默认是 prometheus.DefBuckets
+	Buckets []float64
+	// SizeBuckets 请求和响应大小的分桶，单位是字节，默认是 100B 到 100MB
+	SizeBuckets []float64
+	// Registerer 默认是 prometheus.DefaultRegisterer
+	// 同一个 Registerer 上重复 Build 不会 panic，而是复用已经注册的指标
+	Registerer prometheus.Registerer
 }
 
 func (m MiddlewareBuilder) Build() web.Middleware {
-	summaryVec := prometheus.NewSummaryVec(prometheus.SummaryOpts{
-		Name:        m.Name,
+	if m.Name == "" {
+		m.Name = "http_request"
+	}
+	if m.Help == "" {
+		m.Help = "HTTP 请求的响应时间"
+	}
+	if len(m.Buckets) == 0 {
+		m.Buckets = prometheus.DefBuckets
+	}
+	if len(m.SizeBuckets) == 0 {
+		m.SizeBuckets = prometheus.ExponentialBuckets(100, 10, 7)
+	}
+	reg := m.registerer()
+
+	duration := register(reg, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   m.Namespace,
 		Subsystem:   m.Subsystem,
+		Name:        m.Name + "_duration_seconds",
 		ConstLabels: m.ConstLabels,
 		Help:        m.Help,
-	}, []string{"pattern", "method", "status"})
-	prometheus.MustRegister(summaryVec)
+		Buckets:     m.Buckets,
+	}, []string{"pattern", "method", "status"}))
+	inFlight := register(reg, prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   m.Namespace,
+		Subsystem:   m.Subsystem,
+		Name:        m.Name + "_in_flight",
+		ConstLabels: m.ConstLabels,
+		Help:        "正在处理的 HTTP 请求数",
+	}))
+	reqSize := register(reg, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   m.Namespace,
+		Subsystem:   m.Subsystem,
+		Name:        m.Name + "_request_size_bytes",
+		ConstLabels: m.ConstLabels,
+		Help:        "HTTP 请求体的大小",
+		Buckets:     m.SizeBuckets,
+	}, []string{"pattern", "method"}))
+	respSize := register(reg, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   m.Namespace,
+		Subsystem:   m.Subsystem,
+		Name:        m.Name + "_response_size_bytes",
+		ConstLabels: m.ConstLabels,
+		Help:        "HTTP 响应体的大小",
+		Buckets:     m.SizeBuckets,
+	}, []string{"pattern", "method", "status"}))
+
 	return func(next web.HandleFunc) web.HandleFunc {
 		return func(ctx *web.Context) {
 			startTime := time.Now()
+			inFlight.Inc()
+			defer func() {
+				inFlight.Dec()
+				// ctx 会被复用，所以只能在这里同步上报，不能开 goroutine
+				route := "unknown"
+				if ctx.MatchedRoute != "" {
+					route = ctx.MatchedRoute
+				}
+				status := ctx.RespStatusCode
+				if status == 0 {
+					status = http.StatusOK
+				}
+				method, code := ctx.Req.Method, strconv.Itoa(status)
+				var exemplar prometheus.Labels
+				if sc := trace.SpanContextFromContext(ctx.Req.Context()); sc.IsValid() {
+					exemplar = prometheus.Labels{"trace_id": sc.TraceID().String()}
+				}
+				observe(duration.WithLabelValues(route, method, code),
+					time.Since(startTime).Seconds(), exemplar)
+				size := ctx.Req.ContentLength
+				if size < 0 {
+					size = 0
+				}
+				observe(reqSize.WithLabelValues(route, method), float64(size), exemplar)
+				observe(respSize.WithLabelValues(route, method, code), float64(ctx.RespSize()), exemplar)
+			}()
 			next(ctx)
-			endTime := time.Now()
-			go report(endTime.Sub(startTime), ctx, summaryVec)
 		}
 	}
 }
 
-func report(dur time.Duration, ctx *web.Context, vec prometheus.ObserverVec) {
-	status := ctx.RespStatusCode
-	route := "unknown"
-	if ctx.MatchedRoute != "" {
-		route = ctx.MatchedRoute
+// MetricsRoute 在 server 上注册一个路由，用于 prometheus 采集，例如 /metrics
+// 使用 OpenMetrics 格式的时候才会输出 exemplar
+// 一般来说，更加推荐单独开一个端口来暴露这些数据
+func (m MiddlewareBuilder) MetricsRoute(s *web.HTTPServer, path string) {
+	gatherer := prometheus.DefaultGatherer
+	if g, ok := m.registerer().(prometheus.Gatherer); ok {
+		gatherer = g
+	}
+	handler := promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{EnableOpenMetrics: true})
+	s.Get(path, func(ctx *web.Context) {
+		// 先写到 RespData 里面，这样 Middleware 才能统计到响应的大小
+		w := &bufferWriter{header: ctx.Resp.Header()}
+		handler.ServeHTTP(w, ctx.Req)
+		ctx.RespStatusCode = w.code
+		ctx.RespData = w.buf.Bytes()
+	})
+}
+
+// bufferWriter 缓存 promhttp 写的响应
+type bufferWriter struct {
+	header http.Header
+	code   int
+	buf    bytes.Buffer
+}
+
+func (w *bufferWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferWriter) Write(data []byte) (int, error) {
+	return w.buf.Write(data)
+}
+
+func (w *bufferWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (m MiddlewareBuilder) registerer() prometheus.Registerer {
+	if m.Registerer == nil {
+		return prometheus.DefaultRegisterer
+	}
+	return m.Registerer
+}
+
+// register 已经注册过的话，就返回已经注册的那个
+func register[T prometheus.Collector](reg prometheus.Registerer, c T) T {
+	err := reg.Register(c)
+	if err == nil {
+		return c
+	}
+	var are prometheus.AlreadyRegisteredError
+	if errors.As(err, &are) {
+		if existing, ok := are.ExistingCollector.(T); ok {
+			return existing
+		}
+	}
+	panic(err)
+}
+
+func observe(o prometheus.Observer, val float64, exemplar prometheus.Labels) {
+	if eo, ok := o.(prometheus.ExemplarObserver); ok && exemplar != nil {
+		eo.ObserveWithExemplar(val, exemplar)
+		return
 	}
-	ms := dur / time.Millisecond
-	vec.WithLabelValues(route, ctx.Req.Method, strconv.Itoa(status)).Observe(float64(ms))
+	o.Observe(val)
 }
@@ -1,9 +1,17 @@
 package prometheus
 
 import (
+	"context"
+	"fmt"
 	"gitee.com/geektime-geekbang/geektime-go/web"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -11,14 +19,15 @@ import (
 // 启动之后，访问一下 localhost:8081/user
 // 然后再访问一下 localhost:2112/metrics
 // 就能看到类似的输出，注意找一下
-// # HELP web_http_request 这是测试例子
-// # TYPE web_http_request summary
-// web_http_request_sum{instance_id="1234567",method="GET",pattern="/user",status="0"} 1000
-// web_http_request_count{instance_id="1234567",method="GET",pattern="/user",status="0"} 1
-// web_http_request_sum{instance_id="1234567",method="GET",pattern="unknown",status="404"} 0
-// web_http_request_count{instance_id="1234567",method="GET",pattern="unknown",status="404"} 1
+// # HELP web_http_request_duration_seconds 这是测试例子
+// # TYPE web_http_request_duration_seconds histogram
+// web_http_request_duration_seconds_bucket{instance_id="1234567",method="GET",pattern="/user",status="200",le="1"} 0
+// web_http_request_duration_seconds_bucket{instance_id="1234567",method="GET",pattern="/user",status="200",le="2.5"} 1
+// web_http_request_duration_seconds_count{instance_id="1234567",method="GET",pattern="unknown",status="404"} 1
 // 如果你启动了 prometheus 服务器，那么就配置它来采集这个 2112 端口和 /metrics 路径
 func TestMiddlewareBuilder_Build(t *testing.T) {
+	// 会启动服务器并且一直阻塞，手动运行的时候去掉这一行
+	t.Skip("手动运行的测试")
 	s := web.NewHTTPServer()
 	s.Get("/", func(ctx *web.Context) {
 		ctx.Resp.Write([]byte("hello, world"))
@@ -27,7 +36,7 @@ func TestMiddlewareBuilder_Build(t *testing.T) {
 		time.Sleep(time.Second)
 	})
 
-	s.Use((&MiddlewareBuilder{
+	s.UseAny("/*", (&MiddlewareBuilder{
 		Subsystem: "web",
 		Name:      "http_request",
 		Help:      "这是测试例子",
@@ -42,3 +51,72 @@ func TestMiddlewareBuilder_Build(t *testing.T) {
 	}()
 	s.Start(":8081")
 }
+
+func TestMiddlewareBuilder_Metrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	builder := MiddlewareBuilder{
+		Subsystem:  "web",
+		Buckets:    []float64{0.1, 1},
+		Registerer: reg,
+	}
+	s := web.NewHTTPServer()
+	s.UseAny("/user", builder.Build())
+	// 重复 Build 不会 panic
+	s.UseAny("/order", builder.Build())
+	s.UseAny("/stream", builder.Build())
+	s.UseAny("/metrics", builder.Build())
+	builder.MetricsRoute(s, "/metrics")
+	s.Post("/user/:id", func(ctx *web.Context) {
+		ctx.RespStatusCode = http.StatusCreated
+		ctx.RespData = []byte("hello")
+	})
+	s.Get("/order", func(ctx *web.Context) {
+		ctx.RespData = []byte("order")
+	})
+	s.Get("/stream", func(ctx *web.Context) {
+		sw, err := ctx.Stream(http.StatusOK)
+		if err != nil {
+			return
+		}
+		_, _ = sw.Write([]byte("hello, "))
+		_, _ = sw.Write([]byte("world"))
+	})
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	spanCtx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/user/123", strings.NewReader("abc"))
+	s.ServeHTTP(httptest.NewRecorder(), req.WithContext(spanCtx))
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/order", nil))
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/stream", nil))
+
+	scrape := func() string {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set("Accept", "application/openmetrics-text; version=0.0.1")
+		s.ServeHTTP(recorder, req)
+		require.Equal(t, http.StatusOK, recorder.Code)
+		assert.Contains(t, recorder.Header().Get("Content-Type"), "application/openmetrics-text")
+		return recorder.Body.String()
+	}
+	first := scrape()
+	body := scrape()
+	// 采集的请求本身也会被统计，大小就是上一次采集返回的数据
+	assert.Contains(t, body, fmt.Sprintf(
+		`web_http_request_response_size_bytes_sum{method="GET",pattern="/metrics",status="200"} %d`, len(first)))
+	// 正在处理的只有采集的请求自己
+	assert.Contains(t, body, `web_http_request_in_flight 1`)
+	assert.Contains(t, body, `web_http_request_duration_seconds_count{method="POST",pattern="/user/:id",status="201"} 1`)
+	assert.Contains(t, body, `web_http_request_duration_seconds_count{method="GET",pattern="/order",status="200"} 1`)
+	assert.Contains(t, body, `web_http_request_request_size_bytes_sum{method="POST",pattern="/user/:id"} 3`)
+	assert.Contains(t, body, `web_http_request_response_size_bytes_sum{method="POST",pattern="/user/:id",status="201"} 5`)
+	assert.Contains(t, body, `web_http_request_response_size_bytes_sum{method="GET",pattern="/order",status="200"} 5`)
+	// 流式响应没有 RespData，统计的是实际写出去的字节数
+	assert.Contains(t, body, `web_http_request_response_size_bytes_sum{method="GET",pattern="/stream",status="200"} 12`)
+	// exemplar
+	assert.Contains(t, body, `# {trace_id="4bf92f3577b34da6a3ce929d0e0e4736"}`)
+}
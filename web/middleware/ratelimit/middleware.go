@@ -0,0 +1,129 @@
+package ratelimit
+
+import (
+	"gitee.com/geektime-geekbang/geektime-go/micro/ratelimit"
+	"gitee.com/geektime-geekbang/geektime-go/web"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// MiddlewareBuilder HTTP 的限流
+// 限流算法直接复用 micro/ratelimit 里面的实现，
+// 本地的可以用 ratelimit.NewTokenBucketKeyLimiter 之类的，
+// 集群限流可以用 ratelimit.NewRedisSlidingWindow
+type MiddlewareBuilder struct {
+	limiter ratelimit.KeyLimiter
+	keyFunc func(ctx *web.Context) string
+
+	// 触发限流的时候返回的响应
+	statusCode int
+	respData   []byte
+
+	// failOpen 为 true 的时候，限流器出错（例如 Redis 崩了）就放行，否则拒绝
+	failOpen bool
+	logFunc  func(ctx *web.Context, err error)
+}
+
+// NewBuilder 默认按照客户端 IP 限流，限流器出错的时候放行
+func NewBuilder(limiter ratelimit.KeyLimiter) *MiddlewareBuilder {
+	return &MiddlewareBuilder{
+		limiter:    limiter,
+		keyFunc:    KeyByClientIP,
+		statusCode: http.StatusTooManyRequests,
+		respData:   []byte(http.StatusText(http.StatusTooManyRequests)),
+		failOpen:   true,
+		logFunc: func(ctx *web.Context, err error) {
+			log.Printf("限流器出错 %s %s: %v", ctx.Req.Method, ctx.Req.URL.Path, err)
+		},
+	}
+}
+
+// KeyFunc 设置限流的 key，例如按照用户 ID 限流
+func (b *MiddlewareBuilder) KeyFunc(fn func(ctx *web.Context) string) *MiddlewareBuilder {
+	b.keyFunc = fn
+	return b
+}
+
+// RejectResp 设置触发限流的时候返回的响应，默认是 429
+func (b *MiddlewareBuilder) RejectResp(code int, data []byte) *MiddlewareBuilder {
+	b.statusCode = code
+	b.respData = data
+	return b
+}
+
+// FailOpen 设置限流器出错的时候是否放行
+func (b *MiddlewareBuilder) FailOpen(failOpen bool) *MiddlewareBuilder {
+	b.failOpen = failOpen
+	return b
+}
+
+func (b *MiddlewareBuilder) LogFunc(fn func(ctx *web.Context, err error)) *MiddlewareBuilder {
+	b.logFunc = fn
+	return b
+}
+
+func (b *MiddlewareBuilder) Build() web.Middleware {
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			res, err := b.limiter.Allow(ctx.Req.Context(), b.keyFunc(ctx))
+			if err != nil {
+				b.logFunc(ctx, err)
+				if b.failOpen {
+					next(ctx)
+					return
+				}
+				ctx.RespStatusCode = http.StatusServiceUnavailable
+				ctx.RespData = []byte(http.StatusText(http.StatusServiceUnavailable))
+				return
+			}
+			header := ctx.Resp.Header()
+			header.Set("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+			header.Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			header.Set("X-RateLimit-Reset", seconds(res.Reset))
+			if !res.Allowed {
+				header.Set("Retry-After", seconds(res.RetryAfter))
+				ctx.RespStatusCode = b.statusCode
+				ctx.RespData = b.respData
+				return
+			}
+			next(ctx)
+		}
+	}
+}
+
+// seconds 向上取整，避免客户端在额度恢复之前就重试
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// KeyByClientIP 按照客户端的 IP 限流
+// 注意这里用的是 RemoteAddr，如果前面有反向代理，那么需要自己根据 X-Forwarded-For 之类的头部来实现
+func KeyByClientIP(ctx *web.Context) string {
+	host, _, err := net.SplitHostPort(ctx.Req.RemoteAddr)
+	if err != nil {
+		return ctx.Req.RemoteAddr
+	}
+	return host
+}
+
+// KeyByHeader 按照某个头部限流，例如 API Key
+// 没有这个头部的请求按照客户端 IP 限流，并且加上 ip: 前缀，
+// 否则所有匿名的请求共享同一个额度，一个客户端就能把所有匿名用户的额度耗光
+func KeyByHeader(name string) func(ctx *web.Context) string {
+	return func(ctx *web.Context) string {
+		if val := ctx.Req.Header.Get(name); val != "" {
+			return val
+		}
+		return "ip:" + KeyByClientIP(ctx)
+	}
+}
+
+// KeyByRoute 按照命中的路由限流，例如 GET /user/:id
+// 也就是说所有命中同一个路由的请求共享额度
+func KeyByRoute(ctx *web.Context) string {
+	return ctx.Req.Method + " " + ctx.MatchedRoute
+}
@@ -0,0 +1,146 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"gitee.com/geektime-geekbang/geektime-go/micro/ratelimit"
+	"gitee.com/geektime-geekbang/geektime-go/web"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	s := web.NewHTTPServer()
+	s.Use(http.MethodPost, "/login",
+		NewBuilder(ratelimit.NewFixWindowKeyLimiter(2, time.Minute)).Build())
+	s.Post("/login", func(ctx *web.Context) {
+		ctx.RespStatusCode = http.StatusOK
+		ctx.RespData = []byte("ok")
+	})
+	login := func(ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/login", nil)
+		req.RemoteAddr = ip + ":12345"
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	resp := login("10.0.0.1")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "2", resp.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "1", resp.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "60", resp.Header().Get("X-RateLimit-Reset"))
+
+	resp = login("10.0.0.1")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "0", resp.Header().Get("X-RateLimit-Remaining"))
+
+	resp = login("10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+	assert.Equal(t, "60", resp.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusText(http.StatusTooManyRequests), resp.Body.String())
+
+	// 别的 IP 不受影响
+	resp = login("10.0.0.2")
+	assert.Equal(t, http.StatusOK, resp.Code)
+}
+
+func TestMiddlewareBuilder_KeyFunc(t *testing.T) {
+	testCases := []struct {
+		name    string
+		keyFunc func(ctx *web.Context) string
+		req     func() *http.Request
+		wantKey string
+	}{
+		{
+			name:    "client ip",
+			keyFunc: KeyByClientIP,
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/user/123", nil)
+				req.RemoteAddr = "[::1]:8080"
+				return req
+			},
+			wantKey: "::1",
+		},
+		{
+			name:    "header",
+			keyFunc: KeyByHeader("X-Api-Key"),
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/user/123", nil)
+				req.Header.Set("X-Api-Key", "abc")
+				return req
+			},
+			wantKey: "abc",
+		},
+		{
+			name:    "header missing",
+			keyFunc: KeyByHeader("X-Api-Key"),
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/user/123", nil)
+				req.RemoteAddr = "10.0.0.1:8080"
+				return req
+			},
+			wantKey: "ip:10.0.0.1",
+		},
+		{
+			name:    "route",
+			keyFunc: KeyByRoute,
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/user/123", nil)
+			},
+			wantKey: "GET /user/:id",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := &mockLimiter{}
+			s := web.NewHTTPServer()
+			s.Use(http.MethodGet, "/user", NewBuilder(l).KeyFunc(tc.keyFunc).Build())
+			s.Get("/user/:id", func(ctx *web.Context) {})
+			s.ServeHTTP(httptest.NewRecorder(), tc.req())
+			assert.Equal(t, tc.wantKey, l.key)
+		})
+	}
+}
+
+func TestMiddlewareBuilder_LimiterError(t *testing.T) {
+	testCases := []struct {
+		name     string
+		failOpen bool
+		wantCode int
+	}{
+		{name: "fail open", failOpen: true, wantCode: http.StatusOK},
+		{name: "fail closed", failOpen: false, wantCode: http.StatusServiceUnavailable},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var logged error
+			l := &mockLimiter{err: errors.New("redis 崩了")}
+			s := web.NewHTTPServer()
+			s.Use(http.MethodGet, "/", NewBuilder(l).FailOpen(tc.failOpen).
+				LogFunc(func(ctx *web.Context, err error) {
+					logged = err
+				}).Build())
+			s.Get("/", func(ctx *web.Context) {
+				ctx.RespStatusCode = http.StatusOK
+			})
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			assert.Equal(t, l.err, logged)
+		})
+	}
+}
+
+type mockLimiter struct {
+	key string
+	err error
+}
+
+func (m *mockLimiter) Allow(ctx context.Context, key string) (ratelimit.Result, error) {
+	m.key = key
+	return ratelimit.Result{Allowed: true}, m.err
+}
@@ -1,24 +1,65 @@
 package recovery
 
 import (
+	"errors"
+	"fmt"
 	"gitee.com/geektime-geekbang/geektime-go/web"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strings"
 )
 
 type MiddlewareBuilder struct {
+	// StatusCode 默认是 500
 	StatusCode int
-	ErrMsg     string
-	LogFunc    func(ctx *web.Context, err any)
+	// ErrMsg 默认是 Internal Server Error
+	ErrMsg string
+	// LogFunc 为 nil 的时候，会用 log 输出 panic 和调用栈
+	LogFunc func(ctx *web.Context, err any)
+
+	// Template 不为空的时候，会用 server 的 TemplateEngine 渲染这个模板作为错误页面，
+	// 模板的数据是 map[string]any{"StatusCode": StatusCode, "ErrMsg": ErrMsg}
+	// 请求的 Accept 是 JSON 的时候，总是返回 JSON {"code": StatusCode, "msg": ErrMsg}
+	Template string
+	// Hook 用于把 panic 上报到监控或者链路追踪，例如 TraceHook
+	Hook func(ctx *web.Context, err any, stack []byte)
 }
 
 func (m MiddlewareBuilder) Build() web.Middleware {
+	if m.StatusCode == 0 {
+		m.StatusCode = http.StatusInternalServerError
+	}
+	if m.ErrMsg == "" {
+		m.ErrMsg = http.StatusText(m.StatusCode)
+	}
 	return func(next web.HandleFunc) web.HandleFunc {
 		return func(ctx *web.Context) {
 			defer func() {
-				if err := recover(); err != nil {
-					ctx.RespStatusCode = m.StatusCode
-					ctx.RespData = []byte(m.ErrMsg)
-					// 万一 LogFunc 也panic，那我们也无能为力了
-					m.LogFunc(ctx, err)
+				err := recover()
+				if err == nil {
+					return
+				}
+				// 这是 net/http 约定的中断请求的方式，要交回给 net/http 处理
+				if e, ok := err.(error); ok && errors.Is(e, http.ErrAbortHandler) {
+					panic(err)
+				}
+				stack := debug.Stack()
+				m.respond(ctx)
+				safeCall("LogFunc", func() {
+					if m.LogFunc != nil {
+						m.LogFunc(ctx, err)
+						return
+					}
+					log.Printf("服务 panic %s %s: %v\n%s", ctx.Req.Method, ctx.Req.URL.Path, err, stack)
+				})
+				if m.Hook != nil {
+					safeCall("Hook", func() {
+						m.Hook(ctx, err, stack)
+					})
 				}
 			}()
 
@@ -28,3 +69,52 @@ func (m MiddlewareBuilder) Build() web.Middleware {
 		}
 	}
 }
+
+func (m MiddlewareBuilder) respond(ctx *web.Context) {
+	if strings.Contains(ctx.Req.Header.Get("Accept"), "application/json") {
+		if err := ctx.RespJSON(m.StatusCode, map[string]any{
+			"code": m.StatusCode,
+			"msg":  m.ErrMsg,
+		}); err == nil {
+			return
+		}
+	}
+	if m.Template != "" {
+		var err error
+		// 没有设置 TemplateEngine 的时候 Render 会 panic
+		safeCall("Render", func() {
+			err = ctx.Render(m.Template, map[string]any{
+				"StatusCode": m.StatusCode,
+				"ErrMsg":     m.ErrMsg,
+			})
+		})
+		if err == nil && ctx.RespData != nil {
+			ctx.Resp.Header().Set("Content-Type", "text/html; charset=utf-8")
+			ctx.RespStatusCode = m.StatusCode
+			return
+		}
+	}
+	ctx.RespStatusCode = m.StatusCode
+	ctx.RespData = []byte(m.ErrMsg)
+}
+
+// TraceHook 把 panic 记录到 opentelemetry 的 span 上
+func TraceHook(ctx *web.Context, err any, stack []byte) {
+	span := trace.SpanFromContext(ctx.Req.Context())
+	if !span.IsRecording() {
+		return
+	}
+	span.RecordError(fmt.Errorf("panic: %v", err), trace.WithAttributes(
+		attribute.String("exception.stacktrace", string(stack))))
+	span.SetStatus(codes.Error, "panic")
+}
+
+// safeCall 处理 panic 的过程中再次 panic 的话，不能让整个请求崩掉
+func safeCall(name string, fn func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("recovery: %s panic: %v", name, err)
+		}
+	}()
+	fn()
+}
@@ -2,11 +2,18 @@ package recovery
 
 import (
 	"gitee.com/geektime-geekbang/geektime-go/web"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"html/template"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
 func TestMiddlewareBuilder_Build(t *testing.T) {
+	// 会启动服务器并且一直阻塞，手动运行的时候去掉这一行
+	t.Skip("手动运行的测试")
 	s := web.NewHTTPServer()
 	s.Get("/user", func(ctx *web.Context) {
 		ctx.RespData = []byte("hello, world")
@@ -16,13 +23,113 @@ func TestMiddlewareBuilder_Build(t *testing.T) {
 		panic("闲着没事 panic")
 	})
 
-	s.Use((&MiddlewareBuilder{
+	s.UseAny("/*", (&MiddlewareBuilder{
 		StatusCode: 500,
 		ErrMsg:     "服务出小差了",
-		LogFunc: func(ctx *web.Context) {
-			log.Println(ctx.Req.URL.Path)
+		LogFunc: func(ctx *web.Context, err any) {
+			log.Println(ctx.Req.URL.Path, err)
 		},
 	}).Build())
 
 	s.Start(":8081")
 }
+
+func TestMiddlewareBuilder_Recover(t *testing.T) {
+	tpl, err := template.New("error").Parse(`<h1>{{.StatusCode}} {{.ErrMsg}}</h1>`)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name    string
+		builder MiddlewareBuilder
+		engine  web.TemplateEngine
+		accept  string
+
+		wantCode int
+		wantBody string
+		wantHook bool
+	}{
+		{
+			// 什么都不设置也不会因为 LogFunc 是 nil 而 panic
+			name:     "default",
+			wantCode: http.StatusInternalServerError,
+			wantBody: "Internal Server Error",
+		},
+		{
+			name: "log panic",
+			builder: MiddlewareBuilder{
+				ErrMsg: "系统异常",
+				LogFunc: func(ctx *web.Context, err any) {
+					panic("log panic")
+				},
+				Hook: func(ctx *web.Context, err any, stack []byte) {},
+			},
+			wantCode: http.StatusInternalServerError,
+			wantBody: "系统异常",
+			wantHook: true,
+		},
+		{
+			name:     "json",
+			builder:  MiddlewareBuilder{ErrMsg: "系统异常", Template: "error"},
+			engine:   &web.GoTemplateEngine{T: tpl},
+			accept:   "application/json, text/plain",
+			wantCode: http.StatusInternalServerError,
+			wantBody: `{"code":500,"msg":"系统异常"}`,
+		},
+		{
+			name:     "template",
+			builder:  MiddlewareBuilder{StatusCode: http.StatusServiceUnavailable, ErrMsg: "系统异常", Template: "error"},
+			engine:   &web.GoTemplateEngine{T: tpl},
+			accept:   "text/html",
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: `<h1>503 系统异常</h1>`,
+		},
+		{
+			// 没有设置 TemplateEngine，退化成 ErrMsg
+			name:     "no template engine",
+			builder:  MiddlewareBuilder{ErrMsg: "系统异常", Template: "error"},
+			wantCode: http.StatusInternalServerError,
+			wantBody: "系统异常",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var opts []web.ServerOption
+			if tc.engine != nil {
+				opts = append(opts, web.ServerWithTemplateEngine(tc.engine))
+			}
+			hooked := false
+			if tc.builder.Hook != nil {
+				tc.builder.Hook = func(ctx *web.Context, err any, stack []byte) {
+					hooked = true
+					assert.Equal(t, "boom", err)
+					assert.Contains(t, string(stack), "middleware_test.go")
+				}
+			}
+			s := web.NewHTTPServer(opts...)
+			s.UseAny("/*", tc.builder.Build())
+			s.Get("/panic", func(ctx *web.Context) {
+				panic("boom")
+			})
+			req := httptest.NewRequest(http.MethodGet, "/panic", nil)
+			req.Header.Set("Accept", tc.accept)
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			assert.Equal(t, tc.wantBody, recorder.Body.String())
+			assert.Equal(t, tc.wantHook, hooked)
+		})
+	}
+}
+
+func TestMiddlewareBuilder_ErrAbortHandler(t *testing.T) {
+	mdl := MiddlewareBuilder{}.Build()
+	ctx := &web.Context{
+		Req:  httptest.NewRequest(http.MethodGet, "/", nil),
+		Resp: httptest.NewRecorder(),
+	}
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		mdl(func(ctx *web.Context) {
+			panic(http.ErrAbortHandler)
+		})(ctx)
+	})
+}
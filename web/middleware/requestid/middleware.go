@@ -0,0 +1,76 @@
+package requestid
+
+import (
+	"gitee.com/geektime-geekbang/geektime-go/requestid"
+	"gitee.com/geektime-geekbang/geektime-go/web"
+)
+
+// MiddlewareBuilder 给每个请求分配一个 ID
+// 请求头部里面带了 X-Request-ID 的，就直接用它，否则生成一个新的。
+// ID 会放到 ctx.Req.Context() 里面，同时在响应头部里面返回。
+// 业务代码把 ctx.Req.Context() 传下去，那么 orm 的 querylog 和 micro 的 rpc 调用都能拿到这个 ID，
+// 在业务代码里面可以用 requestid.FromContext(ctx.Req.Context()) 拿到
+type MiddlewareBuilder struct {
+	headerName string
+	generator  func() string
+	// trustIncoming 是否使用请求里面带过来的 ID，
+	// 如果前面没有网关之类的统一生成 ID，那么最好不要相信客户端传过来的 ID
+	trustIncoming bool
+}
+
+func NewBuilder() *MiddlewareBuilder {
+	return &MiddlewareBuilder{
+		headerName:    requestid.HeaderName,
+		generator:     requestid.New,
+		trustIncoming: true,
+	}
+}
+
+// HeaderName 设置头部的名字，默认是 X-Request-ID
+func (b *MiddlewareBuilder) HeaderName(name string) *MiddlewareBuilder {
+	b.headerName = name
+	return b
+}
+
+// Generator 设置生成 ID 的方法，例如使用雪花算法
+func (b *MiddlewareBuilder) Generator(fn func() string) *MiddlewareBuilder {
+	b.generator = fn
+	return b
+}
+
+// TrustIncoming 设置是否使用请求里面带过来的 ID
+func (b *MiddlewareBuilder) TrustIncoming(trust bool) *MiddlewareBuilder {
+	b.trustIncoming = trust
+	return b
+}
+
+func (b *MiddlewareBuilder) Build() web.Middleware {
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			id := ""
+			if b.trustIncoming {
+				id = ctx.Req.Header.Get(b.headerName)
+			}
+			if !valid(id) {
+				id = b.generator()
+			}
+			ctx.Req = ctx.Req.WithContext(requestid.NewContext(ctx.Req.Context(), id))
+			ctx.Resp.Header().Set(b.headerName, id)
+			next(ctx)
+		}
+	}
+}
+
+// valid 客户端传过来的 ID 会被写到日志里面，
+// 所以要限制长度和字符，避免日志注入
+func valid(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
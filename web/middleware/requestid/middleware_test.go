@@ -0,0 +1,69 @@
+package requestid
+
+import (
+	"gitee.com/geektime-geekbang/geektime-go/requestid"
+	"gitee.com/geektime-geekbang/geektime-go/web"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	testCases := []struct {
+		name    string
+		builder *MiddlewareBuilder
+		reqID   string
+
+		wantID string
+	}{
+		{
+			name:    "generate",
+			builder: NewBuilder().Generator(func() string { return "gen-1" }),
+			wantID:  "gen-1",
+		},
+		{
+			name:    "incoming",
+			builder: NewBuilder().Generator(func() string { return "gen-1" }),
+			reqID:   "req-1",
+			wantID:  "req-1",
+		},
+		{
+			name:    "not trust incoming",
+			builder: NewBuilder().TrustIncoming(false).Generator(func() string { return "gen-1" }),
+			reqID:   "req-1",
+			wantID:  "gen-1",
+		},
+		{
+			name:    "invalid incoming",
+			builder: NewBuilder().Generator(func() string { return "gen-1" }),
+			reqID:   "req-1\nfake log",
+			wantID:  "gen-1",
+		},
+		{
+			name:    "too long",
+			builder: NewBuilder().Generator(func() string { return "gen-1" }),
+			reqID:   strings.Repeat("a", 129),
+			wantID:  "gen-1",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := web.NewHTTPServer()
+			s.UseAny("/*", tc.builder.Build())
+			s.Get("/user", func(ctx *web.Context) {
+				ctx.RespStatusCode = http.StatusOK
+				ctx.RespData = []byte(requestid.FromContext(ctx.Req.Context()))
+			})
+			req := httptest.NewRequest(http.MethodGet, "/user", nil)
+			if tc.reqID != "" {
+				req.Header.Set("X-Request-ID", tc.reqID)
+			}
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantID, recorder.Body.String())
+			assert.Equal(t, tc.wantID, recorder.Header().Get("X-Request-ID"))
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package timeout
+
+import (
+	"bytes"
+	"context"
+	"gitee.com/geektime-geekbang/geektime-go/web"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// MiddlewareBuilder 给请求设置超时时间
+// 超时时间会设置在 ctx.Req.Context() 上，所以只要业务代码把 ctx.Req.Context()
+// 传下去，orm 的查询和 micro 的 RPC 调用（会通过 deadline 元数据传给服务端）都会受到同一个超时时间的控制。
+//
+// 后续的 Middleware 和 handler 是在另外一个 goroutine 上执行的，用的是 Context 的副本，
+// 超时之后 handler 对副本的修改不会影响响应，所以不会有并发读写 RespData 的问题。
+// 要注意：
+// 1. 超时之后 handler 还在继续执行，直到它自己检测到 ctx.Req.Context() 结束
+// 2. 流式响应和 WebSocket 的路由要用 Route 把超时时间设置为 0，也就是不设置超时，
+// 否则 handler 拿到的副本调用 Stream、SSE 和 Upgrade 都会返回 error
+type MiddlewareBuilder struct {
+	timeout time.Duration
+	// routes 按照命中的路由（注册的时候的路径，例如 /user/:id）覆盖超时时间
+	routes map[string]time.Duration
+
+	// 超时的时候返回的响应
+	statusCode int
+	respData   []byte
+
+	logFunc func(ctx *web.Context, timeout time.Duration)
+}
+
+// NewBuilder 默认超时的时候返回 503
+func NewBuilder(timeout time.Duration) *MiddlewareBuilder {
+	return &MiddlewareBuilder{
+		timeout:    timeout,
+		routes:     map[string]time.Duration{},
+		statusCode: http.StatusServiceUnavailable,
+		respData:   []byte(http.StatusText(http.StatusServiceUnavailable)),
+		logFunc: func(ctx *web.Context, timeout time.Duration) {
+			log.Printf("请求超时 %s %s: %v", ctx.Req.Method, ctx.Req.URL.Path, timeout)
+		},
+	}
+}
+
+// Route 设置某个路由的超时时间，route 是注册路由时候的路径，例如 /user/:id
+// timeout 小于等于 0 代表这个路由不设置超时
+func (b *MiddlewareBuilder) Route(route string, timeout time.Duration) *MiddlewareBuilder {
+	b.routes[route] = timeout
+	return b
+}
+
+// TimeoutResp 设置超时的时候返回的响应，例如 504
+func (b *MiddlewareBuilder) TimeoutResp(code int, data []byte) *MiddlewareBuilder {
+	b.statusCode = code
+	b.respData = data
+	return b
+}
+
+func (b *MiddlewareBuilder) LogFunc(fn func(ctx *web.Context, timeout time.Duration)) *MiddlewareBuilder {
+	b.logFunc = fn
+	return b
+}
+
+func (b *MiddlewareBuilder) Build() web.Middleware {
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			timeout := b.timeout
+			if t, ok := b.routes[ctx.MatchedRoute]; ok {
+				timeout = t
+			}
+			if timeout <= 0 {
+				next(ctx)
+				return
+			}
+			reqCtx, cancel := context.WithTimeout(ctx.Req.Context(), timeout)
+			defer cancel()
+
+			tw := &timeoutWriter{header: ctx.Resp.Header().Clone()}
+			// 复制一份 Context 给 handler 用，超时之后它怎么改都不会影响到 ctx
+			shadow := ctx.Clone(ctx.Req.WithContext(reqCtx), tw)
+
+			done := make(chan struct{})
+			panicChan := make(chan any, 1)
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						panicChan <- p
+						return
+					}
+					close(done)
+				}()
+				next(shadow)
+			}()
+
+			select {
+			case p := <-panicChan:
+				// 在原本的 goroutine 上重新 panic，这样 recovery 之类的 Middleware 才能处理
+				panic(p)
+			case <-done:
+				tw.copyTo(ctx, shadow)
+			case <-reqCtx.Done():
+				tw.timeout()
+				b.logFunc(ctx, timeout)
+				ctx.RespStatusCode = b.statusCode
+				ctx.RespData = b.respData
+			}
+		}
+	}
+}
+
+// timeoutWriter 缓存 handler 直接写到 Resp 上的数据，
+// 正常结束的时候再复制到真正的 Context 上
+type timeoutWriter struct {
+	mutex    sync.Mutex
+	header   http.Header
+	code     int
+	buf      bytes.Buffer
+	timedOut bool
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *timeoutWriter) Write(data []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.buf.Write(data)
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.timedOut || w.code != 0 {
+		return
+	}
+	w.code = code
+}
+
+func (w *timeoutWriter) timeout() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.timedOut = true
+}
+
+// copyTo 把 handler 的响应复制到 ctx 上
+func (w *timeoutWriter) copyTo(ctx *web.Context, shadow *web.Context) {
+	header := ctx.Resp.Header()
+	for k, v := range w.header {
+		header[k] = v
+	}
+	ctx.RespStatusCode = shadow.RespStatusCode
+	ctx.RespData = shadow.RespData
+	ctx.UserValues = shadow.UserValues
+	if w.code != 0 && ctx.RespStatusCode == 0 {
+		ctx.RespStatusCode = w.code
+	}
+	if w.buf.Len() > 0 {
+		ctx.RespData = append(w.buf.Bytes(), ctx.RespData...)
+	}
+}
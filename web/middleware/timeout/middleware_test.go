@@ -0,0 +1,120 @@
+package timeout
+
+import (
+	"gitee.com/geektime-geekbang/geektime-go/web"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	s := web.NewHTTPServer()
+	s.UseAny("/*", NewBuilder(50*time.Millisecond).
+		Route("/report", time.Second).
+		Route("/stream", 0).
+		TimeoutResp(http.StatusGatewayTimeout, []byte("timeout")).Build())
+	sleep := func(d time.Duration) web.HandleFunc {
+		return func(ctx *web.Context) {
+			select {
+			case <-time.After(d):
+			case <-ctx.Req.Context().Done():
+			}
+			// 超时之后还在修改响应，不能影响到真正的响应
+			ctx.Resp.Header().Set("X-Handler", "done")
+			ctx.RespStatusCode = http.StatusOK
+			ctx.RespData = []byte("ok")
+		}
+	}
+	s.Get("/fast", sleep(0))
+	s.Get("/slow", sleep(time.Second))
+	s.Get("/report", sleep(100*time.Millisecond))
+	s.Get("/stream", func(ctx *web.Context) {
+		_, ok := ctx.Req.Context().Deadline()
+		ctx.RespStatusCode = http.StatusOK
+		ctx.RespData = []byte(map[bool]string{true: "deadline", false: "no deadline"}[ok])
+	})
+	// 没有关掉超时的流式响应，拿到的是副本，不能开启流式响应
+	s.Get("/sse", func(ctx *web.Context) {
+		if _, err := ctx.Stream(http.StatusOK); err != nil {
+			ctx.RespStatusCode = http.StatusInternalServerError
+			ctx.RespData = []byte("stream not supported")
+		}
+	})
+	s.Get("/raw", func(ctx *web.Context) {
+		ctx.Resp.WriteHeader(http.StatusCreated)
+		_, _ = ctx.Resp.Write([]byte("raw"))
+	})
+
+	testCases := []struct {
+		name string
+		path string
+
+		wantCode   int
+		wantBody   string
+		wantHeader string
+	}{
+		{
+			name:       "fast",
+			path:       "/fast",
+			wantCode:   http.StatusOK,
+			wantBody:   "ok",
+			wantHeader: "done",
+		},
+		{
+			name:     "timeout",
+			path:     "/slow",
+			wantCode: http.StatusGatewayTimeout,
+			wantBody: "timeout",
+		},
+		{
+			name:       "route override",
+			path:       "/report",
+			wantCode:   http.StatusOK,
+			wantBody:   "ok",
+			wantHeader: "done",
+		},
+		{
+			name:     "no timeout",
+			path:     "/stream",
+			wantCode: http.StatusOK,
+			wantBody: "no deadline",
+		},
+		{
+			name:     "stream",
+			path:     "/sse",
+			wantCode: http.StatusInternalServerError,
+			wantBody: "stream not supported",
+		},
+		{
+			name:     "write to resp",
+			path:     "/raw",
+			wantCode: http.StatusCreated,
+			wantBody: "raw",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			assert.Equal(t, tc.wantBody, recorder.Body.String())
+			assert.Equal(t, tc.wantHeader, recorder.Header().Get("X-Handler"))
+		})
+	}
+}
+
+func TestMiddlewareBuilder_Panic(t *testing.T) {
+	mdl := NewBuilder(time.Second).Build()
+	ctx := &web.Context{
+		Req:  httptest.NewRequest(http.MethodGet, "/", nil),
+		Resp: httptest.NewRecorder(),
+	}
+	// panic 要在调用的 goroutine 上重新抛出来
+	assert.PanicsWithValue(t, "boom", func() {
+		mdl(func(ctx *web.Context) {
+			panic("boom")
+		})(ctx)
+	})
+}
@@ -2,6 +2,9 @@ package web
 
 import (
 	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -23,7 +26,10 @@ func newRouter() router {
 // - path 必须以 / 开始并且结尾不能有 /，中间也不允许有连续的 /
 // - 不能在同一个位置注册不同的参数路由，例如 /user/:id 和 /user/:name 冲突
 // - 不能在同一个位置同时注册通配符路由和参数路由，例如 /user/:id 和 /user/* 冲突
+// - 正则路由的形式是 :param_name(reg_expr)，例如 /user/:id(^[0-9]+$)
+// - 不能在同一个位置同时注册正则路由和参数路由、通配符路由，也不能注册两个不同的正则路由
 // - 同名路径参数，在路由匹配的时候，值会被覆盖。例如 /user/:id/abc/:id，那么 /user/123/abc/456 最终 id = 456
+// - 同一个路由可以多次注册 middleware，后注册的 middleware 排在后面
 func (r *router) addRoute(method string, path string, handler HandleFunc, ms...Middleware) {
 	if path == "" {
 		panic("web: 路由是空字符串")
@@ -43,33 +49,32 @@ func (r *router) addRoute(method string, path string, handler HandleFunc, ms...M
 		root = &node{path: "/"}
 		r.trees[method] = root
 	}
-	if path == "/" {
-		if root.handler != nil {
-			panic("web: 路由冲突[/]")
+	if path != "/" {
+		segs := strings.Split(path[1:], "/")
+		// 开始一段段处理
+		for _, s := range segs {
+			if s == "" {
+				panic(fmt.Sprintf("web: 非法路由。不允许使用 //a/b, /a//b 之类的路由, [%s]", path))
+			}
+			root = root.childOrCreate(s)
 		}
-		root.handler = handler
-		root.mdls = ms
-		return
 	}
-
-	segs := strings.Split(path[1:], "/")
-	// 开始一段段处理
-	for _, s := range segs {
-		if s == "" {
-			panic(fmt.Sprintf("web: 非法路由。不允许使用 //a/b, /a//b 之类的路由, [%s]", path))
+	// handler 为 nil 意味着只是注册 middleware，例如 Use 和 Group，
+	// 这时候 middleware 是追加到节点上的，不会和已有的路由冲突
+	if handler != nil {
+		if root.handler != nil {
+			panic(fmt.Sprintf("web: 路由冲突[%s]", path))
 		}
-		root = root.childOrCreate(s)
-	}
-	if root.handler != nil {
-		panic(fmt.Sprintf("web: 路由冲突[%s]", path))
+		root.handler = handler
+		root.route = path
 	}
-	root.handler = handler
-	root.route = path
-	root.mdls = ms
+	root.mdls = append(root.mdls, ms...)
 }
 
 // findRoute 查找对应的节点
 // 注意，返回的 node 内部 HandleFunc 不为 nil 才算是注册了路由
+// 匹配的时候会回溯：如果某个分支走到死胡同，那么会退回去尝试优先级更低的分支。
+// 例如注册了 /a/b/c 和 /a/:x/d，那么 /a/b/d 会命中 /a/:x/d
 func (r *router) findRoute(method string, path string) (*matchInfo, bool) {
 	root, ok := r.trees[method]
 	if !ok {
@@ -81,23 +86,92 @@ func (r *router) findRoute(method string, path string) (*matchInfo, bool) {
 	}
 
 	segs := strings.Split(strings.Trim(path, "/"), "/")
-	mi := &matchInfo{}
-	cur := root
-	for _, s := range segs {
-		var matchParam bool
-		cur, matchParam, ok = cur.childOf(s)
+	// 优先找一个注册了 handler 的节点，
+	// 找不到的话再退而求其次，返回第一个能够完整匹配上的节点
+	n, params, ok := root.match(segs, nil, true)
+	if !ok {
+		n, params, ok = root.match(segs, nil, false)
 		if !ok {
 			return &matchInfo{}, false
 		}
-		if matchParam {
-			mi.addValue(root.path[1:], s)
-		}
 	}
-	mi.n = cur
+	mi := &matchInfo{n: n}
+	for i := 0; i < len(params); i += 2 {
+		mi.addValue(params[i], params[i+1])
+	}
 	mi.mdls = r.findMdls(root, segs)
 	return mi, true
 }
 
+// methodMdls 返回 method 下面 path 能够命中的 middleware，不要求能找到对应的节点
+func (r *router) methodMdls(method string, path string) []Middleware {
+	root, ok := r.trees[method]
+	if !ok {
+		return nil
+	}
+	if path == "/" {
+		return root.mdls
+	}
+	return r.findMdls(root, strings.Split(strings.Trim(path, "/"), "/"))
+}
+
+// allowedMethods 返回 path 在哪些 HTTP 方法下注册了路由
+// 注册了 GET 的，会同时支持 HEAD；只要有一个 HTTP 方法支持，那么就会支持 OPTIONS
+// 返回的 HTTP 方法是排好序的
+func (r *router) allowedMethods(path string) []string {
+	res := make([]string, 0, len(r.trees))
+	for method := range r.trees {
+		mi, ok := r.findRoute(method, path)
+		if ok && mi.n != nil && mi.n.handler != nil {
+			res = append(res, method)
+		}
+	}
+	if len(res) == 0 {
+		return res
+	}
+	if contains(res, http.MethodGet) && !contains(res, http.MethodHead) {
+		res = append(res, http.MethodHead)
+	}
+	if !contains(res, http.MethodOptions) {
+		res = append(res, http.MethodOptions)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func contains(methods []string, method string) bool {
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// match 从 n 开始匹配剩余的 segs，按照优先级依次尝试子节点，走不通就回溯
+// params 是按照 key, value, key, value 的形式存放的路径参数
+// needHandler 为 true 的时候，只有注册了 handler 的节点才算匹配成功
+func (n *node) match(segs []string, params []string, needHandler bool) (*node, []string, bool) {
+	if len(segs) == 0 {
+		if needHandler && n.handler == nil {
+			return nil, nil, false
+		}
+		return n, params, true
+	}
+	seg := segs[0]
+	for _, child := range n.candidatesOf(seg) {
+		childParams := params
+		if child.paramName != "" {
+			childParams = append(childParams, child.paramName, seg)
+		}
+		res, resParams, ok := child.match(segs[1:], childParams, needHandler)
+		if ok {
+			return res, resParams, true
+		}
+	}
+	return nil, nil, false
+}
+
 func (r *router) findMdls(root *node, segs []string) []Middleware {
 	queue := []*node{root}
 	res := make([]Middleware, 0, 16)
@@ -124,9 +198,10 @@ func (r *router) findMdls(root *node, segs []string) []Middleware {
 // node 代表路由树的节点
 // 路由树的匹配顺序是：
 // 1. 静态完全匹配
-// 2. 路径参数匹配：形式 :param_name
-// 3. 通配符匹配：*
-// 这是不回溯匹配
+// 2. 正则匹配，形式 :param_name(reg_expr)
+// 3. 路径参数匹配：形式 :param_name
+// 4. 通配符匹配：*
+// 如果按照优先级匹配失败，会回溯尝试下一个优先级的节点
 type node struct {
 	path string
 	// children 子节点
@@ -144,6 +219,12 @@ type node struct {
 	starChild *node
 
 	paramChild *node
+	// 正则路由和参数路由都会使用这个字段
+	paramName string
+
+	// 正则路由
+	regChild *node
+	regExpr  *regexp.Regexp
 
 	matchedMdls []Middleware
 }
@@ -160,36 +241,38 @@ func (n *node) childrenOf(path string) []*node {
 	if n.paramChild != nil {
 		res = append(res, n.paramChild)
 	}
+	if n.regChild != nil && n.regChild.regExpr.MatchString(path) {
+		res = append(res, n.regChild)
+	}
 	if static != nil {
 		res = append(res, static)
 	}
 	return res
 }
 
-// child 返回子节点
-// 第一个返回值 *node 是命中的节点
-// 第二个返回值 bool 代表是否是命中参数路由
-// 第三个返回值 bool 代表是否命中
-func (n *node) childOf(path string) (*node, bool, bool) {
-	if n.children == nil {
-		if n.paramChild != nil {
-			return n.paramChild, true, true
-		}
-		return n.starChild, false, n.starChild != nil
+// candidatesOf 按照匹配优先级返回能够匹配 path 的子节点
+// 顺序是静态节点、正则节点、参数节点、通配符节点
+func (n *node) candidatesOf(path string) []*node {
+	res := make([]*node, 0, 4)
+	if static, ok := n.children[path]; ok {
+		res = append(res, static)
 	}
-	res, ok := n.children[path]
-	if !ok {
-		if n.paramChild != nil {
-			return n.paramChild, true, true
-		}
-		return n.starChild, false, n.starChild != nil
+	if n.regChild != nil && n.regChild.regExpr.MatchString(path) {
+		res = append(res, n.regChild)
+	}
+	if n.paramChild != nil {
+		res = append(res, n.paramChild)
 	}
-	return res, false, ok
+	if n.starChild != nil {
+		res = append(res, n.starChild)
+	}
+	return res
 }
 
 // childOrCreate 查找子节点，
 // 首先会判断 path 是不是通配符路径
-// 其次判断 path 是不是参数路径，即以 : 开头的路径
+// 其次判断 path 是不是参数路径，即以 : 开头的路径，
+// 以 : 开头的路径还要进一步区分是不是正则路由
 // 最后会从 children 里面查找，
 // 如果没有找到，那么会创建一个新的节点，并且保存在 node 里面
 func (n *node) childOrCreate(path string) *node {
@@ -197,25 +280,22 @@ func (n *node) childOrCreate(path string) *node {
 		if n.paramChild != nil {
 			panic(fmt.Sprintf("web: 非法路由，已有路径参数路由。不允许同时注册通配符路由和参数路由 [%s]", path))
 		}
+		if n.regChild != nil {
+			panic(fmt.Sprintf("web: 非法路由，已有正则路由。不允许同时注册通配符路由和正则路由 [%s]", path))
+		}
 		if n.starChild == nil {
 			n.starChild = &node{path: path}
 		}
 		return n.starChild
 	}
 
-	// 以 : 开头，我们认为是参数路由
+	// 以 : 开头，需要进一步解析，判断是参数路由还是正则路由
 	if path[0] == ':' {
-		if n.starChild != nil {
-			panic(fmt.Sprintf("web: 非法路由，已有通配符路由。不允许同时注册通配符路由和参数路由 [%s]", path))
+		paramName, expr, isReg := n.parseParam(path)
+		if isReg {
+			return n.childOrCreateReg(path, expr, paramName)
 		}
-		if n.paramChild != nil {
-			if n.paramChild.path != path {
-				panic(fmt.Sprintf("web: 路由冲突，参数路由冲突，已有 %s，新注册 %s", n.paramChild.path, path))
-			}
-		} else {
-			n.paramChild = &node{path: path}
-		}
-		return n.paramChild
+		return n.childOrCreateParam(path, paramName)
 	}
 
 	if n.children == nil {
@@ -229,6 +309,63 @@ func (n *node) childOrCreate(path string) *node {
 	return child
 }
 
+func (n *node) childOrCreateParam(path string, paramName string) *node {
+	if n.regChild != nil {
+		panic(fmt.Sprintf("web: 非法路由，已有正则路由。不允许同时注册正则路由和参数路由 [%s]", path))
+	}
+	if n.starChild != nil {
+		panic(fmt.Sprintf("web: 非法路由，已有通配符路由。不允许同时注册通配符路由和参数路由 [%s]", path))
+	}
+	if n.paramChild != nil {
+		if n.paramChild.path != path {
+			panic(fmt.Sprintf("web: 路由冲突，参数路由冲突，已有 %s，新注册 %s", n.paramChild.path, path))
+		}
+	} else {
+		n.paramChild = &node{path: path, paramName: paramName}
+	}
+	return n.paramChild
+}
+
+func (n *node) childOrCreateReg(path string, expr string, paramName string) *node {
+	if n.starChild != nil {
+		panic(fmt.Sprintf("web: 非法路由，已有通配符路由。不允许同时注册通配符路由和正则路由 [%s]", path))
+	}
+	if n.paramChild != nil {
+		panic(fmt.Sprintf("web: 非法路由，已有路径参数路由。不允许同时注册正则路由和参数路由 [%s]", path))
+	}
+	// 正则要匹配整个路径段，例如 :id(\d+) 不能匹配 abc1
+	expr = "^(?:" + expr + ")$"
+	if n.regChild != nil {
+		if n.regChild.regExpr.String() != expr || n.regChild.paramName != paramName {
+			panic(fmt.Sprintf("web: 路由冲突，正则路由冲突，已有 %s，新注册 %s", n.regChild.path, path))
+		}
+	} else {
+		regExpr, err := regexp.Compile(expr)
+		if err != nil {
+			panic(fmt.Errorf("web: 正则表达式错误 %w", err))
+		}
+		n.regChild = &node{path: path, paramName: paramName, regExpr: regExpr}
+	}
+	return n.regChild
+}
+
+// parseParam 用于解析判断是不是正则表达式
+// 第一个返回值是参数名字
+// 第二个返回值是正则表达式
+// 第三个返回值为 true 则说明是正则路由
+func (n *node) parseParam(path string) (string, string, bool) {
+	// 去除 :
+	path = path[1:]
+	segs := strings.SplitN(path, "(", 2)
+	if len(segs) == 2 {
+		expr := segs[1]
+		if strings.HasSuffix(expr, ")") {
+			return segs[0], expr[:len(expr)-1], true
+		}
+	}
+	return path, "", false
+}
+
 type matchInfo struct {
 	n *node
 	pathParams map[string]string
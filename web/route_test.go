@@ -71,6 +71,15 @@ func Test_router_AddRoute(t *testing.T) {
 			method: http.MethodGet,
 			path: "/param/:id/*",
 		},
+		// 正则路由
+		{
+			method: http.MethodDelete,
+			path: "/reg/:id(.*)",
+		},
+		{
+			method: http.MethodDelete,
+			path: "/:name(^.+$)/abc",
+		},
 	}
 
 	mockHandler := func(ctx *Context) {}
@@ -94,6 +103,7 @@ func Test_router_AddRoute(t *testing.T) {
 						path: "param",
 						paramChild: &node{
 							path: ":id",
+							paramName: "id",
 							starChild: &node{
 								path: "*",
 								handler: mockHandler,
@@ -120,6 +130,26 @@ func Test_router_AddRoute(t *testing.T) {
 				}},
 				"login": {path: "login", handler: mockHandler},
 			}},
+			http.MethodDelete: {
+				path: "/",
+				children: map[string]*node{
+					"reg": {
+						path: "reg",
+						regChild: &node{
+							path: ":id(.*)",
+							paramName: "id",
+							handler: mockHandler,
+						},
+					},
+				},
+				regChild: &node{
+					path: ":name(^.+$)",
+					paramName: "name",
+					children: map[string]*node{
+						"abc": {path: "abc", handler: mockHandler},
+					},
+				},
+			},
 		},
 	}
 	msg, ok := wantRouter.equal(r)
@@ -187,6 +217,38 @@ func Test_router_AddRoute(t *testing.T) {
 		r.addRoute(http.MethodGet, "/a/b/c/:id", mockHandler)
 		r.addRoute(http.MethodGet, "/a/b/c/:name", mockHandler)
 	})
+
+	// 正则路由和参数路由、通配符路由冲突
+	r = newRouter()
+	assert.PanicsWithValue(t, "web: 非法路由，已有通配符路由。不允许同时注册通配符路由和正则路由 [:id(.*)]", func() {
+		r.addRoute(http.MethodGet, "/a/b/*", mockHandler)
+		r.addRoute(http.MethodGet, "/a/b/:id(.*)", mockHandler)
+	})
+	r = newRouter()
+	assert.PanicsWithValue(t, "web: 非法路由，已有路径参数路由。不允许同时注册正则路由和参数路由 [:id(.*)]", func() {
+		r.addRoute(http.MethodGet, "/a/b/:id", mockHandler)
+		r.addRoute(http.MethodGet, "/a/b/:id(.*)", mockHandler)
+	})
+	r = newRouter()
+	assert.PanicsWithValue(t, "web: 非法路由，已有正则路由。不允许同时注册通配符路由和正则路由 [*]", func() {
+		r.addRoute(http.MethodGet, "/a/b/:id(.*)", mockHandler)
+		r.addRoute(http.MethodGet, "/a/b/*", mockHandler)
+	})
+	r = newRouter()
+	assert.PanicsWithValue(t, "web: 非法路由，已有正则路由。不允许同时注册正则路由和参数路由 [:id]", func() {
+		r.addRoute(http.MethodGet, "/a/b/:id(.*)", mockHandler)
+		r.addRoute(http.MethodGet, "/a/b/:id", mockHandler)
+	})
+	r = newRouter()
+	assert.PanicsWithValue(t, "web: 路由冲突，正则路由冲突，已有 :id(.*)，新注册 :id([0-9]+)", func() {
+		r.addRoute(http.MethodGet, "/a/b/:id(.*)", mockHandler)
+		r.addRoute(http.MethodGet, "/a/b/:id([0-9]+)", mockHandler)
+	})
+	// 正则表达式本身非法
+	r = newRouter()
+	assert.Panics(t, func() {
+		r.addRoute(http.MethodGet, "/a/b/:id([)", mockHandler)
+	})
 }
 
 func (r router) equal(y router) (string, bool) {
@@ -217,12 +279,13 @@ func (n *node) equal(y *node) (string, bool) {
 		return fmt.Sprintf("%s 节点 handler 不相等 x %s, y %s", n.path, nhv.Type().String(), yhv.Type().String()), false
 	}
 
+	if n.paramName != y.paramName {
+		return fmt.Sprintf("%s 节点参数名字不相等 x %s, y %s", n.path, n.paramName, y.paramName), false
+	}
+
 	if len(n.children) != len(y.children) {
 		return fmt.Sprintf("%s 子节点长度不等", n.path), false
 	}
-	if len(n.children) == 0 {
-		return "", true
-	}
 
 	if n.starChild != nil {
 		str, ok := n.starChild.equal(y.starChild)
@@ -230,6 +293,18 @@ func (n *node) equal(y *node) (string, bool) {
 			return fmt.Sprintf("%s 通配符节点不匹配 %s", n.path, str), false
 		}
 	}
+	if n.paramChild != nil {
+		str, ok := n.paramChild.equal(y.paramChild)
+		if !ok {
+			return fmt.Sprintf("%s 路径参数节点不匹配 %s", n.path, str), false
+		}
+	}
+	if n.regChild != nil {
+		str, ok := n.regChild.equal(y.regChild)
+		if !ok {
+			return fmt.Sprintf("%s 正则节点不匹配 %s", n.path, str), false
+		}
+	}
 
 	for k, v := range n.children {
 		yv, ok := y.children[k]
@@ -282,6 +357,23 @@ func Test_router_findRoute(t *testing.T) {
 			method: http.MethodGet,
 			path: "/param/:id/*",
 		},
+		// 正则路由
+		{
+			method: http.MethodDelete,
+			path: "/reg/:id(.*)",
+		},
+		{
+			method: http.MethodDelete,
+			path: "/:id([0-9]+)/home",
+		},
+		{
+			method: http.MethodGet,
+			path: "/member/:id(^[0-9]+$)",
+		},
+		{
+			method: http.MethodGet,
+			path: "/member/profile",
+		},
 	}
 
 	mockHandler := func(ctx *Context) {}
@@ -427,6 +519,73 @@ func Test_router_findRoute(t *testing.T) {
 				pathParams: map[string]string{"id": "123"},
 			},
 		},
+		{
+			// 命中 /reg/:id(.*)
+			name: ":id(.*)",
+			method: http.MethodDelete,
+			path: "/reg/123",
+			found: true,
+			mi: &matchInfo{
+				n: &node{
+					path: ":id(.*)",
+					handler: mockHandler,
+				},
+				pathParams: map[string]string{"id": "123"},
+			},
+		},
+		{
+			// 命中 /:id([0-9]+)/home
+			name: ":id([0-9]+)",
+			method: http.MethodDelete,
+			path: "/123/home",
+			found: true,
+			mi: &matchInfo{
+				n: &node{
+					path: "home",
+					handler: mockHandler,
+				},
+				pathParams: map[string]string{"id": "123"},
+			},
+		},
+		{
+			// 未命中 /:id([0-9]+)/home
+			name: "not :id([0-9]+)",
+			method: http.MethodDelete,
+			path: "/abc/home",
+		},
+		{
+			// 静态路由和正则路由共存，静态路由优先
+			name: "static before reg",
+			method: http.MethodGet,
+			path: "/member/profile",
+			found: true,
+			mi: &matchInfo{
+				n: &node{
+					path: "profile",
+					handler: mockHandler,
+				},
+			},
+		},
+		{
+			// 命中 /user/:id(^[0-9]+$)
+			name: "member :id(^[0-9]+$)",
+			method: http.MethodGet,
+			path: "/member/123",
+			found: true,
+			mi: &matchInfo{
+				n: &node{
+					path: ":id(^[0-9]+$)",
+					handler: mockHandler,
+				},
+				pathParams: map[string]string{"id": "123"},
+			},
+		},
+		{
+			// 正则校验失败
+			name: "member not :id(^[0-9]+$)",
+			method: http.MethodGet,
+			path: "/member/abc",
+		},
 	}
 
 	r := newRouter()
@@ -450,6 +609,168 @@ func Test_router_findRoute(t *testing.T) {
 	}
 }
 
+// 回溯匹配的测试，这些路由树在不回溯的情况下都会匹配失败
+func Test_router_findRoute_Backtrack(t *testing.T) {
+	testRoutes := []struct{
+		method string
+		path string
+	} {
+		// 静态节点走不通，回溯到参数节点
+		{
+			method: http.MethodGet,
+			path: "/a/b/c",
+		},
+		{
+			method: http.MethodGet,
+			path: "/a/:x/d",
+		},
+		// 静态节点走不通，回溯到正则节点
+		{
+			method: http.MethodPost,
+			path: "/a/b/c",
+		},
+		{
+			method: http.MethodPost,
+			path: "/a/:x([0-9]+)/d",
+		},
+		// 静态节点走不通，回溯到通配符节点
+		{
+			method: http.MethodPatch,
+			path: "/a/b/c",
+		},
+		{
+			method: http.MethodPatch,
+			path: "/a/*/e",
+		},
+		// 静态节点能走到底，但是没有 handler，回溯到参数节点
+		{
+			method: http.MethodPut,
+			path: "/a/b/c",
+		},
+		{
+			method: http.MethodPut,
+			path: "/a/:x",
+		},
+		// 多层回溯
+		{
+			method: http.MethodDelete,
+			path: "/a/b/c/d",
+		},
+		{
+			method: http.MethodDelete,
+			path: "/a/b/:y/e",
+		},
+		{
+			method: http.MethodDelete,
+			path: "/a/:x/c/f",
+		},
+	}
+
+	mockHandler := func(ctx *Context) {}
+
+	testCases := []struct {
+		name string
+		method string
+		path string
+		found bool
+		route string
+		pathParams map[string]string
+	}{
+		{
+			name: "static",
+			method: http.MethodGet,
+			path: "/a/b/c",
+			found: true,
+			route: "/a/b/c",
+		},
+		{
+			name: "static to param",
+			method: http.MethodGet,
+			path: "/a/b/d",
+			found: true,
+			route: "/a/:x/d",
+			pathParams: map[string]string{"x": "b"},
+		},
+		{
+			name: "static to reg",
+			method: http.MethodPost,
+			path: "/a/123/d",
+			found: true,
+			route: "/a/:x([0-9]+)/d",
+			pathParams: map[string]string{"x": "123"},
+		},
+		{
+			name: "reg not match",
+			method: http.MethodPost,
+			path: "/a/abc/d",
+		},
+		{
+			// 正则要匹配整个路径段，部分匹配是不行的
+			name: "reg partial match suffix",
+			method: http.MethodPost,
+			path: "/a/abc1/d",
+		},
+		{
+			name: "reg partial match prefix",
+			method: http.MethodPost,
+			path: "/a/1abc/d",
+		},
+		{
+			name: "static to star",
+			method: http.MethodPatch,
+			path: "/a/b/e",
+			found: true,
+			route: "/a/*/e",
+		},
+		{
+			name: "no handler to param",
+			method: http.MethodPut,
+			path: "/a/b",
+			found: true,
+			route: "/a/:x",
+			pathParams: map[string]string{"x": "b"},
+		},
+		{
+			name: "two layers",
+			method: http.MethodDelete,
+			path: "/a/b/c/e",
+			found: true,
+			route: "/a/b/:y/e",
+			pathParams: map[string]string{"y": "c"},
+		},
+		{
+			name: "back to top",
+			method: http.MethodDelete,
+			path: "/a/b/c/f",
+			found: true,
+			route: "/a/:x/c/f",
+			pathParams: map[string]string{"x": "b"},
+		},
+		{
+			name: "dead end",
+			method: http.MethodDelete,
+			path: "/a/b/c/g",
+		},
+	}
+
+	r := newRouter()
+	for _, tr := range testRoutes {
+		r.addRoute(tr.method, tr.path, mockHandler)
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mi, found := r.findRoute(tc.method, tc.path)
+			assert.Equal(t, tc.found, found)
+			if !found {
+				return
+			}
+			assert.Equal(t, tc.route, mi.n.route)
+			assert.Equal(t, tc.pathParams, mi.pathParams)
+		})
+	}
+}
+
 func Test_findRoute_Middleware(t *testing.T) {
 	var mdlBuilder = func(i byte) Middleware {
 		return func(next HandleFunc) HandleFunc {
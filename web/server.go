@@ -1,8 +1,13 @@
 package web
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 type HandleFunc func(ctx *Context)
@@ -26,15 +31,53 @@ var _ Server = &HTTPServer{}
 
 type ServerOption func(server *HTTPServer)
 
+// ShutdownCallback 采用 context.Context 来控制超时，而不是用 time.After 是因为
+// - 超时本质上是使用这个回调的人控制的
+// - 我们还希望用户知道，他的回调必须要在一定时间内处理完毕，而且他必须显式处理超时错误
+type ShutdownCallback func(ctx context.Context)
+
+var (
+	errServerStarted = errors.New("web: 服务器已经启动")
+	errCtxReleased   = errors.New("web: Context 已经被释放，不能在请求处理完毕之后继续使用")
+)
+
 type HTTPServer struct {
 	router
 	tplEngine TemplateEngine
 	log Logger
+
+	// srv 是 Start 之后真正负责监听的 http.Server
+	// 在 Start 之前都是 nil
+	srv   *http.Server
+	mutex sync.Mutex
+	// srvOpts 用于在启动之前对 http.Server 进行配置，例如设置各种超时时间
+	srvOpts []func(srv *http.Server)
+
+	// 优雅退出时候执行的自定义回调
+	cbs []ShutdownCallback
+	// 自定义回调超时时间，默认三秒钟
+	cbTimeout time.Duration
+
+	// ctxPool 复用 Context，减少每个请求的内存分配
+	ctxPool sync.Pool
+	// ctxSafeMode 为 true 的时候，释放之后的 Context 不会被复用，
+	// 并且任何通过 Resp 的写操作都会 panic，用于排查 Context 被泄露到别的 goroutine 的问题
+	ctxSafeMode bool
+
+	// wsUpgrader WebSocket 路由使用的 WebSocketUpgrader
+	wsUpgrader *WebSocketUpgrader
 }
 
 func NewHTTPServer(opts ...ServerOption) *HTTPServer {
 	s := &HTTPServer{
-		router: newRouter(),
+		router:     newRouter(),
+		cbTimeout:  3 * time.Second,
+		wsUpgrader: &WebSocketUpgrader{},
+		ctxPool: sync.Pool{
+			New: func() any {
+				return &Context{}
+			},
+		},
 	}
 	for _, opt := range opts {
 		opt(s)
@@ -48,6 +91,37 @@ func ServerWithTemplateEngine(engine TemplateEngine) ServerOption {
 	}
 }
 
+// ServerWithCtxSafeMode 开启 Context 的安全模式
+// 一般只在开发和测试环境使用，因为它放弃了 Context 的复用
+func ServerWithCtxSafeMode() ServerOption {
+	return func(server *HTTPServer) {
+		server.ctxSafeMode = true
+	}
+}
+
+// ServerWithShutdownCallbacks 注册优雅退出的回调
+// 回调会在所有请求处理完毕之后并发执行
+func ServerWithShutdownCallbacks(cbs ...ShutdownCallback) ServerOption {
+	return func(server *HTTPServer) {
+		server.cbs = append(server.cbs, cbs...)
+	}
+}
+
+// ServerWithCallbackTimeout 设置每一个优雅退出回调的超时时间
+func ServerWithCallbackTimeout(timeout time.Duration) ServerOption {
+	return func(server *HTTPServer) {
+		server.cbTimeout = timeout
+	}
+}
+
+// ServerWithHTTPServerOption 允许用户在启动之前配置底层的 http.Server
+// 例如设置 ReadTimeout, WriteTimeout, TLSConfig 等
+func ServerWithHTTPServerOption(opt func(srv *http.Server)) ServerOption {
+	return func(server *HTTPServer) {
+		server.srvOpts = append(server.srvOpts, opt)
+	}
+}
+
 // func (s *HTTPServer) Use(mdls ...Middleware) {
 // 	if s.mdls == nil {
 // 		s.mdls = mdls
@@ -58,6 +132,8 @@ func ServerWithTemplateEngine(engine TemplateEngine) ServerOption {
 
 // Use 会执行路由匹配，只有匹配上了的 mdls 才会生效
 // 这个只需要稍微改造一下路由树就可以实现
+// 注意 HEAD 请求在没有注册 HEAD handler 的时候使用的是 GET 的路由和 middleware，
+// method 是 HEAD 的 mdls 在这种情况下不会生效
 func (s *HTTPServer) Use(method, path string, mdls ...Middleware) {
 	s.addRoute(method, path, nil, mdls...)
 }
@@ -75,25 +151,116 @@ func (s *HTTPServer) UseAny(path string, mdls ...Middleware) {
 	s.addRoute(http.MethodTrace, path, nil, mdls...)
 }
 // ServeHTTP HTTPServer 处理请求的入口
+// Context 是复用的，所以在 ServeHTTP 返回之后，
+// 不要在别的 goroutine 里面继续使用 Context，需要的数据要提前复制出来
 func (s *HTTPServer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
-	ctx := &Context{
-		Req:       request,
-		Resp:      writer,
-		tplEngine: s.tplEngine,
+	ctx := s.ctxPool.Get().(*Context)
+	ctx.Req = request
+	ctx.Resp = writer
+	ctx.tplEngine = s.tplEngine
+	defer s.releaseCtx(ctx)
+	s.serve(ctx)
+}
+
+func (s *HTTPServer) releaseCtx(ctx *Context) {
+	ctx.Reset()
+	if s.ctxSafeMode {
+		// 安全模式下不放回去，这样泄露出去的 Context 不会影响别的请求
+		ctx.Resp = releasedResponseWriter{}
+		return
 	}
+	s.ctxPool.Put(ctx)
+}
 
-	// ctx pool.Get()
-	// defer func(){
-	//     ctx.Reset()
-	//     pool.Put(ctx)
-	// }
-	s.serve(ctx)
+// releasedResponseWriter 用于安全模式，
+// 被释放的 Context 一旦还有人试图写响应，就直接 panic
+type releasedResponseWriter struct{}
+
+func (releasedResponseWriter) Header() http.Header {
+	panic(errCtxReleased)
+}
+
+func (releasedResponseWriter) Write([]byte) (int, error) {
+	panic(errCtxReleased)
+}
+
+func (releasedResponseWriter) WriteHeader(int) {
+	panic(errCtxReleased)
 }
 
 // Start 启动服务器，编程接口
 // 要求用户自己去配置文件读端口
+// 调用 Shutdown 优雅退出之后，Start 返回 nil
 func (s *HTTPServer) Start(addr string) error {
-	return http.ListenAndServe(addr, s)
+	srv, err := s.initServer(addr)
+	if err != nil {
+		return err
+	}
+	return ignoreServerClosed(srv.ListenAndServe())
+}
+
+// StartTLS 以 HTTPS 的形式启动服务器
+// 在没有设置 TLSConfig.NextProtos 的情况下，http.Server 会自动协商 HTTP/2
+func (s *HTTPServer) StartTLS(addr string, certFile, keyFile string) error {
+	srv, err := s.initServer(addr)
+	if err != nil {
+		return err
+	}
+	return ignoreServerClosed(srv.ListenAndServeTLS(certFile, keyFile))
+}
+
+func (s *HTTPServer) initServer(addr string) (*http.Server, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.srv != nil {
+		return nil, errServerStarted
+	}
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: s,
+	}
+	for _, opt := range s.srvOpts {
+		opt(srv)
+	}
+	s.srv = srv
+	return srv, nil
+}
+
+// Shutdown 优雅退出
+// 1. 关闭监听，拒绝新的连接
+// 2. 等待已有的请求处理完毕，ctx 控制了最多等待多长时间
+// 3. 并发执行注册的回调，每个回调的超时时间由 ServerWithCallbackTimeout 控制
+func (s *HTTPServer) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	srv := s.srv
+	s.mutex.Unlock()
+
+	var err error
+	if srv != nil {
+		err = srv.Shutdown(ctx)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(len(s.cbs))
+	for _, cb := range s.cbs {
+		c := cb
+		go func() {
+			defer wg.Done()
+			// 即便等待请求超时了，回调也要执行，所以这里不用 ctx
+			cbCtx, cancel := context.WithTimeout(context.Background(), s.cbTimeout)
+			defer cancel()
+			c(cbCtx)
+		}()
+	}
+	wg.Wait()
+	return err
+}
+
+func ignoreServerClosed(err error) error {
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
 }
 
 func (s *HTTPServer) Post(path string, handler HandleFunc) {
@@ -105,19 +272,20 @@ func (s *HTTPServer) Get(path string, handler HandleFunc) {
 }
 
 func (s *HTTPServer) serve(ctx *Context) {
-	mi, ok := s.findRoute(ctx.Req.Method, ctx.Req.URL.Path)
+	// handler panic 的时候 flashResp 不会执行，
+	// 这里兜底关闭流式响应，确保心跳的 goroutine 不会比请求活得更久
+	defer func() {
+		if ctx.stream != nil {
+			_ = ctx.stream.Close()
+		}
+	}()
+	mi, handler := s.matchHandler(ctx)
 	if mi.n != nil {
 		ctx.PathParams = mi.pathParams
 		ctx.MatchedRoute = mi.n.route
 	}
 	// 最后一个应该是执行用户代码
-	var root HandleFunc = func(ctx *Context) {
-		if !ok || mi.n == nil || mi.n.handler == nil {
-			ctx.RespStatusCode = 404
-			return
-		}
-		mi.n.handler(ctx)
-	}
+	var root HandleFunc = handler
 	// 从后往前组装
 	for i := len(mi.mdls) - 1; i >= 0; i-- {
 		root = mi.mdls[i](root)
@@ -135,11 +303,82 @@ func (s *HTTPServer) serve(ctx *Context) {
 	root(ctx)
 }
 
+// matchHandler 查找路由，并且返回最终执行的 HandleFunc
+// 如果当前 HTTP 方法下没有找到路由：
+// - HEAD 请求会使用 GET 的路由，包括 GET 的 middleware
+// - OPTIONS 请求会自动返回 204，并且在 Allow 里面带上这个路径支持的 HTTP 方法
+// - 其它 HTTP 方法下能找到路由的，返回 405，同样带上 Allow
+// - 都找不到就是 404
+//
+// HEAD 使用 GET 的路由的时候，通过 Use(http.MethodHead, ...) 注册的 middleware 是不会执行的。
+// 因为 UseAny 注册的 middleware 在 GET 和 HEAD 下面都有，两边合并的话会执行两遍；
+// 只用 HEAD 的又会跳过 GET 上面例如鉴权之类的 middleware。
+// 需要对 HEAD 生效的 middleware 请使用 UseAny，或者给 HEAD 单独注册 handler
+func (s *HTTPServer) matchHandler(ctx *Context) (*matchInfo, HandleFunc) {
+	method, path := ctx.Req.Method, ctx.Req.URL.Path
+	mi, ok := s.findRoute(method, path)
+	if mi == nil {
+		mi = &matchInfo{}
+	}
+	if ok && mi.n != nil && mi.n.handler != nil {
+		return mi, mi.n.handler
+	}
+
+	if method == http.MethodHead {
+		getMi, ok := s.findRoute(http.MethodGet, path)
+		if ok && getMi.n != nil && getMi.n.handler != nil {
+			return getMi, getMi.n.handler
+		}
+	}
+
+	allowed := s.allowedMethods(path)
+	if len(allowed) == 0 {
+		return mi, func(ctx *Context) {
+			ctx.RespStatusCode = http.StatusNotFound
+		}
+	}
+	allow := strings.Join(allowed, ", ")
+	// 这个方法下面没有能够完整匹配的节点，例如只用 UseAny 注册了 middleware，
+	// 但是 middleware 还是要执行的，例如 CORS 需要处理预检请求
+	if mi.n == nil {
+		mi.mdls = s.methodMdls(method, path)
+	}
+	if method == http.MethodOptions {
+		return mi, func(ctx *Context) {
+			ctx.Resp.Header().Set("Allow", allow)
+			ctx.RespStatusCode = http.StatusNoContent
+		}
+	}
+	return mi, func(ctx *Context) {
+		ctx.Resp.Header().Set("Allow", allow)
+		ctx.RespStatusCode = http.StatusMethodNotAllowed
+	}
+}
+
 func (s *HTTPServer) flashResp(ctx *Context) {
+	// 连接已经被 WebSocket 接管了
+	if ctx.hijacked {
+		return
+	}
+	// 流式响应已经把数据写出去了，这里只需要关闭
+	if ctx.stream != nil {
+		_ = ctx.stream.Close()
+		return
+	}
+	// 204 和 304 是不允许有响应体的
+	bodyAllowed := ctx.RespStatusCode != http.StatusNoContent &&
+		ctx.RespStatusCode != http.StatusNotModified
+	// header 必须在 WriteHeader 之前设置，否则不会生效
+	if bodyAllowed {
+		ctx.Resp.Header().Set("Content-Length", strconv.Itoa(len(ctx.RespData)))
+	}
 	if ctx.RespStatusCode > 0 {
 		ctx.Resp.WriteHeader(ctx.RespStatusCode)
 	}
-	ctx.Resp.Header().Set("Content-Length", strconv.Itoa(len(ctx.RespData)))
+	// HEAD 请求只需要返回 header
+	if !bodyAllowed || ctx.Req.Method == http.MethodHead || len(ctx.RespData) == 0 {
+		return
+	}
 	_, err := ctx.Resp.Write(ctx.RespData)
 	if err != nil {
 		// s.log.Fatalln("回写响应失败", err)
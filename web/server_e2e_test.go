@@ -10,6 +10,8 @@ import (
 // 这里放着端到端测试的代码
 
 func TestServer(t *testing.T) {
+	// 会启动服务器并且一直阻塞，手动运行的时候去掉这一行
+	t.Skip("手动运行的测试")
 	s := NewHTTPServer()
 	s.Get("/", func(ctx *Context) {
 		ctx.Resp.Write([]byte("hello, world"))
@@ -54,6 +56,8 @@ func TestServer(t *testing.T) {
 }
 
 func TestServerWithRenderEngine(t *testing.T) {
+	// 会启动服务器并且一直阻塞，手动运行的时候去掉这一行
+	t.Skip("手动运行的测试")
 	tpl, err := template.ParseGlob("testdata/tpls/*.gohtml")
 	if err != nil {
 		t.Fatal(err)
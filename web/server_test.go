@@ -1 +1,306 @@
 package web
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHTTPServer_ServeHTTP_MethodNotMatch(t *testing.T) {
+	s := NewHTTPServer()
+	s.Get("/user/:id", func(ctx *Context) {
+		ctx.RespData = []byte("get")
+	})
+	s.Post("/user/:id", func(ctx *Context) {
+		ctx.RespData = []byte("post")
+	})
+	s.addRoute(http.MethodDelete, "/order", func(ctx *Context) {
+		ctx.RespData = []byte("delete")
+	})
+	s.addRoute(http.MethodOptions, "/order", func(ctx *Context) {
+		ctx.RespData = []byte("options")
+	})
+
+	testCases := []struct {
+		name      string
+		method    string
+		path      string
+		wantCode  int
+		wantAllow string
+		wantResp  string
+	}{
+		{
+			name:     "found",
+			method:   http.MethodGet,
+			path:     "/user/123",
+			wantCode: http.StatusOK,
+			wantResp: "get",
+		},
+		{
+			name:     "head fallback to get",
+			method:   http.MethodHead,
+			path:     "/user/123",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:      "auto options",
+			method:    http.MethodOptions,
+			path:      "/user/123",
+			wantCode:  http.StatusNoContent,
+			wantAllow: "GET, HEAD, OPTIONS, POST",
+		},
+		{
+			// 用户自己注册了 OPTIONS，那么就用用户的
+			name:     "registered options",
+			method:   http.MethodOptions,
+			path:     "/order",
+			wantCode: http.StatusOK,
+			wantResp: "options",
+		},
+		{
+			name:      "method not allowed",
+			method:    http.MethodPut,
+			path:      "/user/123",
+			wantCode:  http.StatusMethodNotAllowed,
+			wantAllow: "GET, HEAD, OPTIONS, POST",
+		},
+		{
+			// 没有 GET，也就没有 HEAD
+			name:      "head not allowed",
+			method:    http.MethodHead,
+			path:      "/order",
+			wantCode:  http.StatusMethodNotAllowed,
+			wantAllow: "DELETE, OPTIONS",
+		},
+		{
+			name:     "not found",
+			method:   http.MethodGet,
+			path:     "/user/123/detail",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "options not found",
+			method:   http.MethodOptions,
+			path:     "/abc",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(tc.method, tc.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			assert.Equal(t, tc.wantAllow, recorder.Header().Get("Allow"))
+			assert.Equal(t, tc.wantResp, recorder.Body.String())
+		})
+	}
+}
+
+// TestHTTPServer_ServeHTTP_HeadFallbackMiddleware HEAD 使用 GET 的路由的时候，
+// 执行的是 GET 的 middleware，只注册在 HEAD 上的 middleware 不会执行
+func TestHTTPServer_ServeHTTP_HeadFallbackMiddleware(t *testing.T) {
+	s := NewHTTPServer()
+	mdl := func(name string) Middleware {
+		return func(next HandleFunc) HandleFunc {
+			return func(ctx *Context) {
+				ctx.Resp.Header().Add("X-Mdl", name)
+				next(ctx)
+			}
+		}
+	}
+	s.UseAny("/user", mdl("any"))
+	s.Use(http.MethodGet, "/user", mdl("get"))
+	s.Use(http.MethodHead, "/user", mdl("head"))
+	s.Get("/user", func(ctx *Context) {})
+	s.Use(http.MethodHead, "/order", mdl("head"))
+	s.Get("/order", func(ctx *Context) {})
+	s.addRoute(http.MethodHead, "/order", func(ctx *Context) {})
+
+	testCases := []struct {
+		name    string
+		path    string
+		wantMdl []string
+	}{
+		{
+			name:    "fallback to get",
+			path:    "/user",
+			wantMdl: []string{"any", "get"},
+		},
+		{
+			name:    "head handler",
+			path:    "/order",
+			wantMdl: []string{"head"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, httptest.NewRequest(http.MethodHead, tc.path, nil))
+			assert.Equal(t, http.StatusOK, recorder.Code)
+			assert.Equal(t, tc.wantMdl, recorder.Header().Values("X-Mdl"))
+		})
+	}
+}
+
+func TestHTTPServer_Shutdown(t *testing.T) {
+	var cbCalled int32
+	s := NewHTTPServer(ServerWithShutdownCallbacks(func(ctx context.Context) {
+		atomic.AddInt32(&cbCalled, 1)
+	}))
+	handling := make(chan struct{})
+	s.Get("/slow", func(ctx *Context) {
+		close(handling)
+		time.Sleep(200 * time.Millisecond)
+		ctx.RespData = []byte("done")
+	})
+
+	addr := "127.0.0.1:18082"
+	startErr := make(chan error, 1)
+	go func() {
+		startErr <- s.Start(addr)
+	}()
+	// 等待服务器启动
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return false
+		}
+		_ = conn.Close()
+		return true
+	}, time.Second, 10*time.Millisecond)
+
+	type result struct {
+		body string
+		err  error
+	}
+	respCh := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/slow")
+		if err != nil {
+			respCh <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		respCh <- result{body: string(body), err: err}
+	}()
+	<-handling
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, s.Shutdown(ctx))
+
+	// 正在处理的请求会被处理完
+	res := <-respCh
+	require.NoError(t, res.err)
+	assert.Equal(t, "done", res.body)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&cbCalled))
+	// 优雅退出之后 Start 返回 nil
+	assert.NoError(t, <-startErr)
+	// 不能重复启动
+	assert.Equal(t, errServerStarted, s.Start(addr))
+	// 新的连接会被拒绝
+	_, err := http.Get("http://" + addr + "/slow")
+	assert.Error(t, err)
+}
+
+func TestHTTPServer_CtxSafeMode(t *testing.T) {
+	s := NewHTTPServer(ServerWithCtxSafeMode())
+	var leaked *Context
+	s.Get("/user", func(ctx *Context) {
+		leaked = ctx
+		ctx.UserValues = map[string]any{"key": "value"}
+		ctx.RespData = []byte("hello")
+	})
+	req, err := http.NewRequest(http.MethodGet, "/user", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, req)
+	assert.Equal(t, "hello", recorder.Body.String())
+
+	// 释放之后，数据都被清空了
+	assert.Nil(t, leaked.Req)
+	assert.Nil(t, leaked.UserValues)
+	assert.Nil(t, leaked.RespData)
+	// 继续写响应会 panic
+	assert.PanicsWithValue(t, errCtxReleased, func() {
+		_, _ = leaked.Resp.Write([]byte("hello"))
+	})
+	assert.PanicsWithValue(t, errCtxReleased, func() {
+		_ = leaked.RespJSONOK("hello")
+	})
+}
+
+// 对比复用 Context 和每次都创建 Context 的内存分配
+// goos: linux
+// goarch: amd64
+// pkg: gitee.com/geektime-geekbang/geektime-go/web
+// cpu: Intel(R) Xeon(R) Processor
+// BenchmarkHTTPServer_ServeHTTP/pool         	  200000	       859.4 ns/op	     603 B/op	      14 allocs/op
+// BenchmarkHTTPServer_ServeHTTP/new          	  200000	       833.7 ns/op	     715 B/op	      15 allocs/op
+func BenchmarkHTTPServer_ServeHTTP(b *testing.B) {
+	s := NewHTTPServer()
+	mockHandler := func(ctx *Context) {
+		ctx.RespData = []byte("hello")
+	}
+	s.Get("/user", mockHandler)
+	s.Get("/user/*/home", mockHandler)
+	s.Get("/param/:id/detail", mockHandler)
+	s.Get("/reg/:id(^[0-9]+$)", mockHandler)
+	paths := []string{"/user", "/user/Tom/home", "/param/123/detail", "/reg/123"}
+	reqs := make([]*http.Request, 0, len(paths))
+	for _, p := range paths {
+		req, err := http.NewRequest(http.MethodGet, p, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		reqs = append(reqs, req)
+	}
+	writer := &discardResponseWriter{header: http.Header{}}
+
+	b.Run("pool", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			s.ServeHTTP(writer, reqs[i%len(reqs)])
+		}
+	})
+
+	b.Run("new", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			s.serve(&Context{
+				Req:       reqs[i%len(reqs)],
+				Resp:      writer,
+				tplEngine: s.tplEngine,
+			})
+		}
+	})
+}
+
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (d *discardResponseWriter) Header() http.Header {
+	return d.header
+}
+
+func (d *discardResponseWriter) Write(bs []byte) (int, error) {
+	return len(bs), nil
+}
+
+func (d *discardResponseWriter) WriteHeader(statusCode int) {}
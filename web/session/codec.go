@@ -0,0 +1,45 @@
+package session
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+)
+
+// ErrKeyNotFound session 里面没有这个 key
+var ErrKeyNotFound = errors.New("session: key 不存在")
+
+// Codec 负责 GetValue/SetValue 里面值的序列化和反序列化
+type Codec interface {
+	Encode(val any) (string, error)
+	Decode(data string, val any) error
+}
+
+// JSONCodec 默认的 Codec，存进去的数据可读性比较好
+type JSONCodec struct{}
+
+func (JSONCodec) Encode(val any) (string, error) {
+	data, err := json.Marshal(val)
+	return string(data), err
+}
+
+func (JSONCodec) Decode(data string, val any) error {
+	return json.Unmarshal([]byte(data), val)
+}
+
+// GobCodec 使用 gob 序列化，
+// 如果存的是接口类型的值，记得用 gob.Register 注册具体类型
+type GobCodec struct{}
+
+func (GobCodec) Encode(val any) (string, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(val); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (GobCodec) Decode(data string, val any) error {
+	return gob.NewDecoder(bytes.NewBufferString(data)).Decode(val)
+}
@@ -1,6 +1,8 @@
 package cookie
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
 	"net/http"
 )
 
@@ -12,23 +14,62 @@ func WithCookieOption(opt func(c *http.Cookie)) PropagatorOption {
 	}
 }
 
+// WithSignKeys 用 HMAC-SHA256 给 cookie 的值签名，防止客户端篡改 session id
+// 第一个 key 用于签名，所有的 key 都可以用于校验，
+// 所以轮换密钥的时候把新的 key 放在最前面，旧的 key 保留一段时间再删掉
+func WithSignKeys(keys ...[]byte) PropagatorOption {
+	return func(propagator *Propagator) {
+		propagator.signKeys = keys
+	}
+}
+
+// WithEncryptKeys 用 AES-GCM 加密 cookie 的值，客户端看不到真实的 session id
+// key 的长度必须是 16、24 或者 32 字节，否则会 panic
+// 轮换密钥的规则和 WithSignKeys 一样
+func WithEncryptKeys(keys ...[]byte) PropagatorOption {
+	return func(propagator *Propagator) {
+		aeads := make([]cipher.AEAD, 0, len(keys))
+		for _, key := range keys {
+			block, err := aes.NewCipher(key)
+			if err != nil {
+				panic("cookie-session: 非法的加密密钥")
+			}
+			aead, err := cipher.NewGCM(block)
+			if err != nil {
+				panic("cookie-session: 非法的加密密钥")
+			}
+			aeads = append(aeads, aead)
+		}
+		propagator.aeads = aeads
+	}
+}
+
 type Propagator struct {
 	cookieName string
-	cookieOpt func(c *http.Cookie)
+	cookieOpt  func(c *http.Cookie)
+	signKeys   [][]byte
+	aeads      []cipher.AEAD
 }
 
-func NewPropagator(cookieName string, opts...PropagatorOption) *Propagator {
+func NewPropagator(cookieName string, opts ...PropagatorOption) *Propagator {
 	res := &Propagator{
 		cookieName: cookieName,
-		cookieOpt: func(c *http.Cookie) {},
+		cookieOpt:  func(c *http.Cookie) {},
+	}
+	for _, opt := range opts {
+		opt(res)
 	}
 	return res
 }
 
 func (c *Propagator) Inject(id string, writer http.ResponseWriter) error {
+	val, err := c.encode(id)
+	if err != nil {
+		return err
+	}
 	cookie := &http.Cookie{
-		Name: c.cookieName,
-		Value: id,
+		Name:  c.cookieName,
+		Value: val,
 	}
 	c.cookieOpt(cookie)
 	http.SetCookie(writer, cookie)
@@ -40,16 +81,15 @@ func (c *Propagator) Extract(req *http.Request) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return cookie.Value, nil
+	return c.decode(cookie.Value)
 }
 
 func (c *Propagator) Remove(writer http.ResponseWriter) error {
 	cookie := &http.Cookie{
-		Name: c.cookieName,
+		Name:   c.cookieName,
 		MaxAge: -1,
 	}
 	c.cookieOpt(cookie)
 	http.SetCookie(writer, cookie)
 	return nil
 }
-
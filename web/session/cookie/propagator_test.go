@@ -0,0 +1,133 @@
+package cookie
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPropagator(t *testing.T) {
+	oldKey := []byte("old-sign-key")
+	newKey := []byte("new-sign-key")
+	oldEncKey := []byte("0123456789abcdef")
+	newEncKey := []byte("fedcba9876543210")
+	testCases := []struct {
+		name string
+		// injector 写 cookie，extractor 读 cookie，用于模拟密钥轮换
+		injector  *Propagator
+		extractor *Propagator
+
+		wantPlain bool
+		wantErr   error
+	}{
+		{
+			name:      "plain",
+			injector:  NewPropagator("sessid"),
+			extractor: NewPropagator("sessid"),
+			wantPlain: true,
+		},
+		{
+			name:      "signed",
+			injector:  NewPropagator("sessid", WithSignKeys(newKey)),
+			extractor: NewPropagator("sessid", WithSignKeys(newKey)),
+		},
+		{
+			name:      "signed with old key",
+			injector:  NewPropagator("sessid", WithSignKeys(oldKey)),
+			extractor: NewPropagator("sessid", WithSignKeys(newKey, oldKey)),
+		},
+		{
+			name:      "signed with removed key",
+			injector:  NewPropagator("sessid", WithSignKeys(oldKey)),
+			extractor: NewPropagator("sessid", WithSignKeys(newKey)),
+			wantErr:   errInvalidCookie,
+		},
+		{
+			name:      "not signed",
+			injector:  NewPropagator("sessid"),
+			extractor: NewPropagator("sessid", WithSignKeys(newKey)),
+			wantPlain: true,
+			wantErr:   errInvalidCookie,
+		},
+		{
+			name:      "encrypted with old key",
+			injector:  NewPropagator("sessid", WithEncryptKeys(oldEncKey)),
+			extractor: NewPropagator("sessid", WithEncryptKeys(newEncKey, oldEncKey)),
+		},
+		{
+			name:      "encrypted with removed key",
+			injector:  NewPropagator("sessid", WithEncryptKeys(oldEncKey)),
+			extractor: NewPropagator("sessid", WithEncryptKeys(newEncKey)),
+			wantErr:   errInvalidCookie,
+		},
+		{
+			name:      "encrypted and signed",
+			injector:  NewPropagator("sessid", WithEncryptKeys(newEncKey), WithSignKeys(newKey)),
+			extractor: NewPropagator("sessid", WithEncryptKeys(newEncKey), WithSignKeys(newKey)),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			require.NoError(t, tc.injector.Inject("my-session-id", recorder))
+			cookies := recorder.Result().Cookies()
+			require.Len(t, cookies, 1)
+			assert.Equal(t, tc.wantPlain, cookies[0].Value == "my-session-id")
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.AddCookie(cookies[0])
+			id, err := tc.extractor.Extract(req)
+			assert.Equal(t, tc.wantErr, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, "my-session-id", id)
+		})
+	}
+}
+
+func TestPropagator_Tampered(t *testing.T) {
+	p := NewPropagator("sessid", WithSignKeys([]byte("sign-key")))
+	recorder := httptest.NewRecorder()
+	require.NoError(t, p.Inject("my-session-id", recorder))
+	cookie := recorder.Result().Cookies()[0]
+
+	// 换成别的 session id，签名就对不上了
+	cookie.Value = "other-session-id" + cookie.Value[len("my-session-id"):]
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookie)
+	_, err := p.Extract(req)
+	assert.Equal(t, errInvalidCookie, err)
+
+	// 同样的签名不能用在别的 cookie 上
+	other := NewPropagator("other", WithSignKeys([]byte("sign-key")))
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: "other", Value: recorder.Result().Cookies()[0].Value})
+	_, err = other.Extract(req)
+	assert.Equal(t, errInvalidCookie, err)
+}
+
+func TestWithCookieOption(t *testing.T) {
+	p := NewPropagator("sessid", WithCookieOption(func(c *http.Cookie) {
+		c.HttpOnly = true
+		c.Path = "/"
+	}))
+	recorder := httptest.NewRecorder()
+	require.NoError(t, p.Inject("my-session-id", recorder))
+	require.NoError(t, p.Remove(recorder))
+	cookies := recorder.Result().Cookies()
+	require.Len(t, cookies, 2)
+	for _, c := range cookies {
+		assert.True(t, c.HttpOnly)
+		assert.Equal(t, "/", c.Path)
+	}
+	assert.Equal(t, -1, cookies[1].MaxAge)
+}
+
+func TestWithEncryptKeys(t *testing.T) {
+	assert.PanicsWithValue(t, "cookie-session: 非法的加密密钥", func() {
+		NewPropagator("sessid", WithEncryptKeys([]byte("short")))
+	})
+}
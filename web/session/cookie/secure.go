@@ -0,0 +1,92 @@
+package cookie
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+var errInvalidCookie = errors.New("cookie-session: cookie 的签名或者密文不合法")
+
+// encode 先加密再签名，都没有设置的话就是原本的 id
+func (c *Propagator) encode(id string) (string, error) {
+	val := id
+	if len(c.aeads) > 0 {
+		var err error
+		if val, err = c.encrypt(val); err != nil {
+			return "", err
+		}
+	}
+	if len(c.signKeys) > 0 {
+		val = val + "." + c.sign(c.signKeys[0], val)
+	}
+	return val, nil
+}
+
+func (c *Propagator) decode(val string) (string, error) {
+	if len(c.signKeys) > 0 {
+		var err error
+		if val, err = c.verify(val); err != nil {
+			return "", err
+		}
+	}
+	if len(c.aeads) > 0 {
+		return c.decrypt(val)
+	}
+	return val, nil
+}
+
+// sign 签名的时候带上 cookie 的名字，防止把别的 cookie 的值拿过来用
+func (c *Propagator) sign(key []byte, val string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(c.cookieName))
+	mac.Write([]byte{'|'})
+	mac.Write([]byte(val))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (c *Propagator) verify(val string) (string, error) {
+	idx := strings.LastIndexByte(val, '.')
+	if idx < 0 {
+		return "", errInvalidCookie
+	}
+	data, sig := val[:idx], val[idx+1:]
+	for _, key := range c.signKeys {
+		if hmac.Equal([]byte(sig), []byte(c.sign(key, data))) {
+			return data, nil
+		}
+	}
+	return "", errInvalidCookie
+}
+
+// encrypt 密文的格式是 base64(nonce + ciphertext)
+func (c *Propagator) encrypt(val string) (string, error) {
+	aead := c.aeads[0]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(val)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	data := aead.Seal(nonce, nonce, []byte(val), []byte(c.cookieName))
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func (c *Propagator) decrypt(val string) (string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(val)
+	if err != nil {
+		return "", errInvalidCookie
+	}
+	for _, aead := range c.aeads {
+		if len(data) < aead.NonceSize() {
+			continue
+		}
+		nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+		plain, err := aead.Open(nil, nonce, ciphertext, []byte(c.cookieName))
+		if err == nil {
+			return string(plain), nil
+		}
+	}
+	return "", errInvalidCookie
+}
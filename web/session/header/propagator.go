@@ -0,0 +1,79 @@
+package header
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var errNoSessionID = errors.New("header-session: 请求里面没有 session id")
+
+type PropagatorOption func(propagator *Propagator)
+
+// WithScheme 请求头的值带有认证方案，例如 Authorization: Bearer xxx
+// 这时候只有方案匹配的才会被当成 session id
+func WithScheme(scheme string) PropagatorOption {
+	return func(propagator *Propagator) {
+		propagator.scheme = scheme
+	}
+}
+
+// WithRespHeader Inject 的时候写到哪个响应头，默认和请求头一样
+// 例如请求头是 Authorization 的时候，一般会设置成 X-Session-ID
+func WithRespHeader(name string) PropagatorOption {
+	return func(propagator *Propagator) {
+		propagator.respHeader = name
+	}
+}
+
+// Propagator 通过 HTTP 头部传递 session id，适用于 APP 之类的不方便使用 cookie 的客户端
+// Inject 会把 session id 放到响应头里面，客户端后续的请求需要自己带上
+type Propagator struct {
+	reqHeader  string
+	respHeader string
+	scheme     string
+}
+
+func NewPropagator(headerName string, opts ...PropagatorOption) *Propagator {
+	res := &Propagator{
+		reqHeader:  headerName,
+		respHeader: headerName,
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+// NewBearerPropagator 从 Authorization: Bearer xxx 里面拿 session id，
+// Inject 的时候写到 X-Session-ID 响应头
+func NewBearerPropagator() *Propagator {
+	return NewPropagator("Authorization", WithScheme("Bearer"),
+		WithRespHeader("X-Session-ID"))
+}
+
+func (p *Propagator) Inject(id string, writer http.ResponseWriter) error {
+	writer.Header().Set(p.respHeader, id)
+	return nil
+}
+
+func (p *Propagator) Extract(req *http.Request) (string, error) {
+	val := strings.TrimSpace(req.Header.Get(p.reqHeader))
+	if p.scheme != "" {
+		scheme, token, ok := strings.Cut(val, " ")
+		// 认证方案是大小写不敏感的
+		if !ok || !strings.EqualFold(scheme, p.scheme) {
+			return "", errNoSessionID
+		}
+		val = strings.TrimSpace(token)
+	}
+	if val == "" {
+		return "", errNoSessionID
+	}
+	return val, nil
+}
+
+func (p *Propagator) Remove(writer http.ResponseWriter) error {
+	writer.Header().Del(p.respHeader)
+	return nil
+}
@@ -0,0 +1,16 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+)
+
+// NewID 生成一个 session id
+// 用的是 crypto/rand 生成的 32 字节随机数，没办法被猜出来
+func NewID() (string, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
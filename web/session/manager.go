@@ -8,6 +8,8 @@ type Manager struct {
 	Store
 	Propagator
 	SessCtxKey string
+	// GenID 生成 session id，默认是 NewID
+	GenID func() (string, error)
 }
 
 // GetSession 将会尝试从 ctx 中拿到 Session，
@@ -42,9 +44,61 @@ func (m *Manager) InitSession(ctx *web.Context, id string) (Session, error) {
 	if err = m.Inject(id, ctx.Resp); err != nil {
 		return nil, err
 	}
+	// 同一个请求后面再 GetSession 的时候拿到的是新的 session
+	if ctx.UserValues == nil {
+		ctx.UserValues = make(map[string]any, 1)
+	}
+	ctx.UserValues[m.SessCtxKey] = sess
 	return sess, nil
 }
 
+// NewSession 用 GenID 生成 id，初始化一个 session
+func (m *Manager) NewSession(ctx *web.Context) (Session, error) {
+	id, err := m.genID()
+	if err != nil {
+		return nil, err
+	}
+	return m.InitSession(ctx, id)
+}
+
+// LoginSession 登录或者权限提升的时候调用
+// 如果已经有 session 了，那么保留数据换一个新的 id，否则初始化一个新的 session。
+// 这样攻击者提前塞给用户的 session id 在登录之后就失效了
+func (m *Manager) LoginSession(ctx *web.Context) (Session, error) {
+	if _, err := m.GetSession(ctx); err == nil {
+		return m.RotateSession(ctx)
+	}
+	return m.NewSession(ctx)
+}
+
+// RotateSession 换一个新的 session id，数据会迁移到新的 session 上，旧的 session 会被删除
+func (m *Manager) RotateSession(ctx *web.Context) (Session, error) {
+	sess, err := m.GetSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	id, err := m.genID()
+	if err != nil {
+		return nil, err
+	}
+	newSess, err := m.Rotate(ctx.Req.Context(), sess.ID(), id)
+	if err != nil {
+		return nil, err
+	}
+	if err = m.Inject(id, ctx.Resp); err != nil {
+		return nil, err
+	}
+	ctx.UserValues[m.SessCtxKey] = newSess
+	return newSess, nil
+}
+
+func (m *Manager) genID() (string, error) {
+	if m.GenID != nil {
+		return m.GenID()
+	}
+	return NewID()
+}
+
 // RefreshSession 刷新 Session
 func (m *Manager) RefreshSession(ctx *web.Context) (Session, error) {
 	sess, err := m.GetSession(ctx)
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"gitee.com/geektime-geekbang/geektime-go/web/session"
 	cache "github.com/patrickmn/go-cache"
+	"strings"
 	"sync"
 	"time"
 )
@@ -15,23 +16,39 @@ type Store struct {
 	// 利用一个内存缓存来帮助我们管理过期时间
 	c          *cache.Cache
 	expiration time.Duration
+	codec      session.Codec
+}
+
+type StoreOption func(store *Store)
+
+// StoreWithCodec 设置 GetValue/SetValue 使用的 Codec，默认是 session.JSONCodec
+func StoreWithCodec(codec session.Codec) StoreOption {
+	return func(store *Store) {
+		store.codec = codec
+	}
 }
 
 // NewStore 创建一个 Store 的实例
 // 实际上，这里也可以考虑使用 Option 设计模式，允许用户控制过期检查的间隔
-func NewStore(expiration time.Duration) *Store {
-	return &Store{
+func NewStore(expiration time.Duration, opts ...StoreOption) *Store {
+	res := &Store{
 		c:          cache.New(expiration, time.Second),
 		expiration: expiration,
+		codec:      session.JSONCodec{},
+	}
+	for _, opt := range opts {
+		opt(res)
 	}
+	return res
 }
 
 func (m *Store) Generate(ctx context.Context, id string) (session.Session, error) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 	sess := &memorySession{
-		id:   id,
-		data: make(map[string]string),
+		id:    id,
+		data:  make(map[string]string),
+		codec: m.codec,
 	}
 	m.c.Set(sess.ID(), sess, m.expiration)
 	return sess, nil
@@ -48,6 +65,30 @@ func (m *Store) Refresh(ctx context.Context, id string) error {
 	return nil
 }
 
+func (m *Store) Rotate(ctx context.Context, oldID string, newID string) (session.Session, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	val, ok := m.c.Get(oldID)
+	if !ok {
+		return nil, errors.New("session not found")
+	}
+	old := val.(*memorySession)
+	old.mutex.RLock()
+	data := make(map[string]string, len(old.data))
+	for k, v := range old.data {
+		data[k] = v
+	}
+	old.mutex.RUnlock()
+	sess := &memorySession{
+		id:    newID,
+		data:  data,
+		codec: m.codec,
+	}
+	m.c.Set(newID, sess, m.expiration)
+	m.c.Delete(oldID)
+	return sess, nil
+}
+
 func (m *Store) Remove(ctx context.Context, id string) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
@@ -65,10 +106,14 @@ func (m *Store) Get(ctx context.Context, id string) (session.Session, error) {
 	return sess.(*memorySession), nil
 }
 
+// flashPrefix flash 消息在 data 里面的 key 前缀
+const flashPrefix = "_flash_"
+
 type memorySession struct {
-	mutex      sync.RWMutex
-	id         string
-	data       map[string]string
+	mutex sync.RWMutex
+	id    string
+	data  map[string]string
+	codec session.Codec
 }
 
 func (m *memorySession) Get(ctx context.Context, key string) (string, error) {
@@ -76,7 +121,7 @@ func (m *memorySession) Get(ctx context.Context, key string) (string, error) {
 	defer m.mutex.RUnlock()
 	val, ok := m.data[key]
 	if !ok {
-		return "", errors.New("找不到这个 key")
+		return "", session.ErrKeyNotFound
 	}
 	return val, nil
 }
@@ -88,6 +133,66 @@ func (m *memorySession) Set(ctx context.Context, key string, val string) error {
 	return nil
 }
 
+func (m *memorySession) GetValue(ctx context.Context, key string, val any) error {
+	data, err := m.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	return m.codec.Decode(data, val)
+}
+
+func (m *memorySession) SetValue(ctx context.Context, key string, val any) error {
+	data, err := m.codec.Encode(val)
+	if err != nil {
+		return err
+	}
+	return m.Set(ctx, key, data)
+}
+
+func (m *memorySession) GetAll(ctx context.Context) (map[string]string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	res := make(map[string]string, len(m.data))
+	for k, v := range m.data {
+		if strings.HasPrefix(k, flashPrefix) {
+			continue
+		}
+		res[k] = v
+	}
+	return res, nil
+}
+
+func (m *memorySession) SetMulti(ctx context.Context, vals map[string]string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for k, v := range vals {
+		m.data[k] = v
+	}
+	return nil
+}
+
+func (m *memorySession) Delete(ctx context.Context, key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.data, key)
+	return nil
+}
+
+func (m *memorySession) SetFlash(ctx context.Context, key string, val any) error {
+	return m.SetValue(ctx, flashPrefix+key, val)
+}
+
+func (m *memorySession) Flash(ctx context.Context, key string, val any) error {
+	m.mutex.Lock()
+	data, ok := m.data[flashPrefix+key]
+	delete(m.data, flashPrefix+key)
+	m.mutex.Unlock()
+	if !ok {
+		return session.ErrKeyNotFound
+	}
+	return m.codec.Decode(data, val)
+}
+
 func (m *memorySession) ID() string {
 	return m.id
 }
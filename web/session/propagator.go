@@ -0,0 +1,55 @@
+package session
+
+import (
+	"errors"
+	"net/http"
+)
+
+var errNoPropagator = errors.New("session: 没有设置 Propagator")
+
+// CompositePropagator 组合多个 Propagator
+// Extract 按照顺序尝试，用第一个成功的结果；Inject 和 Remove 会作用于所有的 Propagator
+// 例如同时支持浏览器的 cookie 和 APP 的 Authorization 头部
+type CompositePropagator struct {
+	propagators []Propagator
+}
+
+func NewCompositePropagator(propagators ...Propagator) *CompositePropagator {
+	return &CompositePropagator{
+		propagators: propagators,
+	}
+}
+
+// Inject 任何一个出错都不会中断，返回第一个错误
+func (c *CompositePropagator) Inject(id string, writer http.ResponseWriter) error {
+	var res error
+	for _, p := range c.propagators {
+		if err := p.Inject(id, writer); err != nil && res == nil {
+			res = err
+		}
+	}
+	return res
+}
+
+// Extract 全部失败的时候，返回最后一个 Propagator 的错误
+func (c *CompositePropagator) Extract(req *http.Request) (string, error) {
+	err := errNoPropagator
+	for _, p := range c.propagators {
+		var id string
+		id, err = p.Extract(req)
+		if err == nil {
+			return id, nil
+		}
+	}
+	return "", err
+}
+
+func (c *CompositePropagator) Remove(writer http.ResponseWriter) error {
+	var res error
+	for _, p := range c.propagators {
+		if err := p.Remove(writer); err != nil && res == nil {
+			res = err
+		}
+	}
+	return res
+}
@@ -0,0 +1,55 @@
+package query
+
+import (
+	"errors"
+	"net/http"
+)
+
+var errNoSessionID = errors.New("query-session: 请求里面没有 session id")
+
+type PropagatorOption func(propagator *Propagator)
+
+// WithRespHeader Inject 的时候写到哪个响应头，默认是 X-Session-ID
+func WithRespHeader(name string) PropagatorOption {
+	return func(propagator *Propagator) {
+		propagator.respHeader = name
+	}
+}
+
+// Propagator 从 URL 的查询参数里面拿 session id，例如 /user?sessid=xxx
+// 只是为了兼容一些老的客户端，session id 会出现在日志和 Referer 里面，能不用就不用
+// 服务端没办法修改客户端的 URL，所以 Inject 会把 session id 放到响应头里面，
+// 由客户端自己拼接到后续请求的 URL 上
+type Propagator struct {
+	paramName  string
+	respHeader string
+}
+
+func NewPropagator(paramName string, opts ...PropagatorOption) *Propagator {
+	res := &Propagator{
+		paramName:  paramName,
+		respHeader: "X-Session-ID",
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+func (p *Propagator) Inject(id string, writer http.ResponseWriter) error {
+	writer.Header().Set(p.respHeader, id)
+	return nil
+}
+
+func (p *Propagator) Extract(req *http.Request) (string, error) {
+	val := req.URL.Query().Get(p.paramName)
+	if val == "" {
+		return "", errNoSessionID
+	}
+	return val, nil
+}
+
+func (p *Propagator) Remove(writer http.ResponseWriter) error {
+	writer.Header().Del(p.respHeader)
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"gitee.com/geektime-geekbang/geektime-go/web/session"
 	"github.com/go-redis/redis/v9"
+	"strings"
 	"time"
 )
 
@@ -16,6 +17,14 @@ type Store struct {
 	prefix string
 	client redis.Cmdable
 	expiration time.Duration
+	codec session.Codec
+}
+
+// StoreWithCodec 设置 GetValue/SetValue 使用的 Codec，默认是 session.JSONCodec
+func StoreWithCodec(codec session.Codec) StoreOption {
+	return func(store *Store) {
+		store.codec = codec
+	}
 }
 
 // NewStore 创建一个 Store 的实例
@@ -25,6 +34,7 @@ func NewStore(client redis.Cmdable, opts...StoreOption) *Store {
 		client: client,
 		prefix: "session",
 		expiration: time.Minute * 15,
+		codec: session.JSONCodec{},
 	}
 	for _, opt := range opts {
 		opt(res)
@@ -38,15 +48,20 @@ redis.call("hset", KEYS[1], ARGV[1], ARGV[2])
 return redis.call("pexpire", KEYS[1], ARGV[3])
 `
 	key := s.key(id)
-	_, err := s.client.Eval(ctx, lua, []string{key}, "_sess_id", id, s.expiration.Milliseconds()).Result()
+	_, err := s.client.Eval(ctx, lua, []string{key}, sessIDField, id, s.expiration.Milliseconds()).Result()
 	if err != nil {
 		return nil, err
 	}
+	return s.newSession(key, id), nil
+}
+
+func (s *Store) newSession(key string, id string) *Session {
 	return &Session{
 		key:    key,
 		id:     id,
 		client: s.client,
-	}, nil
+		codec:  s.codec,
+	}
 }
 
 func (s *Store) key(id string) string {
@@ -66,6 +81,37 @@ func (s *Store) Refresh(ctx context.Context, id string) error {
 	return nil
 }
 
+func (s *Store) Rotate(ctx context.Context, oldID string, newID string) (session.Session, error) {
+	// 在一个 lua 脚本里面完成复制和删除，保证原子性
+	// 注意在 Redis 集群里面，新旧两个 key 可能不在同一个 slot 上，
+	// 这时候需要通过 prefix 使用 hash tag，例如 {session}
+	const lua = `
+if redis.call("exists", KEYS[1]) == 0
+then
+	return 0
+end
+local data = redis.call("hgetall", KEYS[1])
+redis.call("del", KEYS[2])
+for i = 1, #data, 2 do
+	redis.call("hset", KEYS[2], data[i], data[i + 1])
+end
+redis.call("hset", KEYS[2], ARGV[1], ARGV[2])
+redis.call("pexpire", KEYS[2], ARGV[3])
+redis.call("del", KEYS[1])
+return 1
+`
+	key := s.key(newID)
+	res, err := s.client.Eval(ctx, lua, []string{s.key(oldID), key},
+		sessIDField, newID, s.expiration.Milliseconds()).Int()
+	if err != nil {
+		return nil, err
+	}
+	if res == 0 {
+		return nil, errSessionNotExist
+	}
+	return s.newSession(key, newID), nil
+}
+
 func (s *Store) Remove(ctx context.Context, id string) error {
 	_, err := s.client.Del(ctx, s.key(id)).Result()
 	return err
@@ -78,25 +124,29 @@ func (s *Store) Get(ctx context.Context, id string) (session.Session, error) {
 	if err != nil {
 		return nil, err
 	}
-	if i < 0 {
-		return nil, errors.New("redis-session: session 不存在")
+	if i == 0 {
+		return nil, errSessionNotExist
 	}
-	return &Session{
-		id:     id,
-		key:    key,
-		client: s.client,
-	}, nil
+	return s.newSession(key, id), nil
 }
 
+const (
+	// sessIDField 每个 session 的 hash 里面都有这个字段，用于判断 session 是否存在
+	sessIDField = "_sess_id"
+	// flashPrefix flash 消息在 hash 里面的字段前缀
+	flashPrefix = "_flash_"
+)
+
 type Session struct {
 	key string
 	id string
 	client redis.Cmdable
+	codec session.Codec
 }
 
 func (m *Session) Set(ctx context.Context, key string, val string) error {
 	const lua = `
-if redis.call("exists", KEYS[1])
+if redis.call("exists", KEYS[1]) == 1
 then
 	return redis.call("hset", KEYS[1], ARGV[1], ARGV[2])
 else
@@ -114,7 +164,100 @@ end
 }
 
 func (m *Session) Get(ctx context.Context, key string) (string, error) {
-	return m.client.HGet(ctx, m.key, key).Result()
+	val, err := m.client.HGet(ctx, m.key, key).Result()
+	if err == redis.Nil {
+		return "", session.ErrKeyNotFound
+	}
+	return val, err
+}
+
+func (m *Session) GetValue(ctx context.Context, key string, val any) error {
+	data, err := m.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	return m.codec.Decode(data, val)
+}
+
+func (m *Session) SetValue(ctx context.Context, key string, val any) error {
+	data, err := m.codec.Encode(val)
+	if err != nil {
+		return err
+	}
+	return m.Set(ctx, key, data)
+}
+
+// GetAll 一次 HGETALL 取出所有的数据
+func (m *Session) GetAll(ctx context.Context) (map[string]string, error) {
+	res, err := m.client.HGetAll(ctx, m.key).Result()
+	if err != nil {
+		return nil, err
+	}
+	// 存在的 session 至少有 _sess_id 这个字段
+	if len(res) == 0 {
+		return nil, errSessionNotExist
+	}
+	for k := range res {
+		if k == sessIDField || strings.HasPrefix(k, flashPrefix) {
+			delete(res, k)
+		}
+	}
+	return res, nil
+}
+
+// SetMulti 在一个 lua 脚本里面用 HMSET 一次性写进去
+func (m *Session) SetMulti(ctx context.Context, vals map[string]string) error {
+	if len(vals) == 0 {
+		return nil
+	}
+	const lua = `
+if redis.call("exists", KEYS[1]) == 1
+then
+	redis.call("hmset", KEYS[1], unpack(ARGV))
+	return 1
+else
+	return -1
+end
+`
+	args := make([]any, 0, len(vals)*2)
+	for k, v := range vals {
+		args = append(args, k, v)
+	}
+	res, err := m.client.Eval(ctx, lua, []string{m.key}, args...).Int()
+	if err != nil {
+		return err
+	}
+	if res < 0 {
+		return errSessionNotExist
+	}
+	return nil
+}
+
+func (m *Session) Delete(ctx context.Context, key string) error {
+	return m.client.HDel(ctx, m.key, key).Err()
+}
+
+func (m *Session) SetFlash(ctx context.Context, key string, val any) error {
+	return m.SetValue(ctx, flashPrefix+key, val)
+}
+
+// Flash 读取和删除要在一个 lua 脚本里面完成，不然并发的请求可能读到同一个 flash 消息
+func (m *Session) Flash(ctx context.Context, key string, val any) error {
+	const lua = `
+local val = redis.call("hget", KEYS[1], ARGV[1])
+if val then
+	redis.call("hdel", KEYS[1], ARGV[1])
+end
+return val
+`
+	data, err := m.client.Eval(ctx, lua, []string{m.key}, flashPrefix+key).Text()
+	if err == redis.Nil {
+		return session.ErrKeyNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return m.codec.Decode(data, val)
 }
 
 func (m *Session) ID() string {
@@ -3,6 +3,7 @@ package redis
 
 import (
 	"context"
+	"gitee.com/geektime-geekbang/geektime-go/web/session"
 	"github.com/go-redis/redis/v9"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -30,3 +31,73 @@ func newStore() *Store {
 	})
 	return NewStore(rc)
 }
+
+func TestStore_Rotate(t *testing.T) {
+	s := newStore()
+	ctx := context.Background()
+	sess, err := s.Generate(ctx, "sess_old_id")
+	require.NoError(t, err)
+	err = sess.Set(ctx, "key1", "123")
+	require.NoError(t, err)
+
+	newSess, err := s.Rotate(ctx, "sess_old_id", "sess_new_id")
+	require.NoError(t, err)
+	defer s.Remove(ctx, "sess_new_id")
+	assert.Equal(t, "sess_new_id", newSess.ID())
+	val, err := newSess.Get(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, "123", val)
+
+	// 旧的 session 已经没了，也不能通过旧的 Session 复活
+	_, err = s.Get(ctx, "sess_old_id")
+	assert.Equal(t, errSessionNotExist, err)
+	assert.Equal(t, errSessionNotExist, sess.Set(ctx, "key1", "456"))
+	_, err = s.Rotate(ctx, "sess_old_id", "sess_other_id")
+	assert.Equal(t, errSessionNotExist, err)
+}
+
+func TestSession_Values(t *testing.T) {
+	s := newStore()
+	ctx := context.Background()
+	sess, err := s.Generate(ctx, "sess_values_id")
+	require.NoError(t, err)
+	defer s.Remove(ctx, "sess_values_id")
+
+	err = sess.SetMulti(ctx, map[string]string{"key1": "123", "key2": "456"})
+	require.NoError(t, err)
+	type user struct {
+		Name string
+		Age  int
+	}
+	err = sess.SetValue(ctx, "user", user{Name: "Tom", Age: 18})
+	require.NoError(t, err)
+	err = sess.SetFlash(ctx, "msg", "保存成功")
+	require.NoError(t, err)
+
+	var u user
+	err = sess.GetValue(ctx, "user", &u)
+	require.NoError(t, err)
+	assert.Equal(t, user{Name: "Tom", Age: 18}, u)
+
+	// GetAll 不包含 _sess_id 和 flash 消息
+	all, err := sess.GetAll(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"key1": "123", "key2": "456", "user": `{"Name":"Tom","Age":18}`}, all)
+
+	var msg string
+	err = sess.Flash(ctx, "msg", &msg)
+	require.NoError(t, err)
+	assert.Equal(t, "保存成功", msg)
+	err = sess.Flash(ctx, "msg", &msg)
+	assert.Equal(t, session.ErrKeyNotFound, err)
+
+	err = sess.Delete(ctx, "key1")
+	require.NoError(t, err)
+	_, err = sess.Get(ctx, "key1")
+	assert.Equal(t, session.ErrKeyNotFound, err)
+
+	require.NoError(t, s.Remove(ctx, "sess_values_id"))
+	assert.Equal(t, errSessionNotExist, sess.SetMulti(ctx, map[string]string{"key1": "123"}))
+	_, err = sess.GetAll(ctx)
+	assert.Equal(t, errSessionNotExist, err)
+}
@@ -1,17 +1,23 @@
 package test
 
 import (
+	"context"
 	"gitee.com/geektime-geekbang/geektime-go/web"
 	"gitee.com/geektime-geekbang/geektime-go/web/session"
 	"gitee.com/geektime-geekbang/geektime-go/web/session/cookie"
 	"gitee.com/geektime-geekbang/geektime-go/web/session/memory"
 	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
 
 func TestManager(t *testing.T) {
+	// 会启动服务器并且一直阻塞，手动运行的时候去掉这一行
+	t.Skip("手动运行的测试")
 	s := web.NewHTTPServer()
 
 	m := session.Manager{
@@ -52,7 +58,7 @@ func TestManager(t *testing.T) {
 		_ = m.RemoveSession(ctx)
 	})
 
-	s.Use(func(next web.HandleFunc) web.HandleFunc {
+	s.UseAny("/*", func(next web.HandleFunc) web.HandleFunc {
 		return func(ctx *web.Context) {
 			// 执行校验
 			if ctx.Req.URL.Path != "/login" {
@@ -71,3 +77,124 @@ func TestManager(t *testing.T) {
 
 	s.Start(":8081")
 }
+
+func TestManager_LoginSession(t *testing.T) {
+	m := &session.Manager{
+		SessCtxKey: "_sess",
+		Store:      memory.NewStore(30 * time.Minute),
+		Propagator: cookie.NewPropagator("sessid"),
+	}
+	s := web.NewHTTPServer()
+	s.Post("/login", func(ctx *web.Context) {
+		sess, err := m.LoginSession(ctx)
+		if err != nil {
+			ctx.RespStatusCode = http.StatusInternalServerError
+			return
+		}
+		_ = sess.Set(ctx.Req.Context(), "uid", "123")
+		ctx.RespStatusCode = http.StatusOK
+	})
+
+	// 攻击者提前准备好的 session，里面的数据要保留，但是 id 要换掉
+	_, err := m.Generate(context.Background(), "attacker-id")
+	require.NoError(t, err)
+	fixed, err := m.Get(context.Background(), "attacker-id")
+	require.NoError(t, err)
+	require.NoError(t, fixed.Set(context.Background(), "cart", "book"))
+
+	testCases := []struct {
+		name     string
+		cookie   string
+		wantCart string
+	}{
+		{
+			name: "new session",
+		},
+		{
+			name:     "rotate",
+			cookie:   "attacker-id",
+			wantCart: "book",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/login", nil)
+			if tc.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "sessid", Value: tc.cookie})
+			}
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, req)
+			require.Equal(t, http.StatusOK, recorder.Code)
+			cookies := recorder.Result().Cookies()
+			require.Len(t, cookies, 1)
+			id := cookies[0].Value
+			assert.NotEqual(t, tc.cookie, id)
+			// 43 = base64(32 字节)
+			assert.Len(t, id, 43)
+
+			sess, err := m.Get(context.Background(), id)
+			require.NoError(t, err)
+			uid, err := sess.Get(context.Background(), "uid")
+			require.NoError(t, err)
+			assert.Equal(t, "123", uid)
+			cart, _ := sess.Get(context.Background(), "cart")
+			assert.Equal(t, tc.wantCart, cart)
+			if tc.cookie != "" {
+				_, err = m.Get(context.Background(), tc.cookie)
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestMemorySession_Values(t *testing.T) {
+	type user struct {
+		Name string
+		Age  int
+	}
+	testCases := []struct {
+		name  string
+		codec session.Codec
+	}{
+		{
+			name:  "json",
+			codec: session.JSONCodec{},
+		},
+		{
+			name:  "gob",
+			codec: session.GobCodec{},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := memory.NewStore(time.Minute, memory.StoreWithCodec(tc.codec))
+			sess, err := store.Generate(ctx, "sess_id")
+			require.NoError(t, err)
+
+			require.NoError(t, sess.SetMulti(ctx, map[string]string{"key1": "123", "key2": "456"}))
+			require.NoError(t, sess.SetValue(ctx, "user", user{Name: "Tom", Age: 18}))
+			var u user
+			require.NoError(t, sess.GetValue(ctx, "user", &u))
+			assert.Equal(t, user{Name: "Tom", Age: 18}, u)
+			assert.Equal(t, session.ErrKeyNotFound, sess.GetValue(ctx, "not-exist", &u))
+
+			require.NoError(t, sess.SetFlash(ctx, "msg", "保存成功"))
+			all, err := sess.GetAll(ctx)
+			require.NoError(t, err)
+			assert.Len(t, all, 3)
+			assert.Equal(t, "456", all["key2"])
+			assert.NotContains(t, all, "msg")
+
+			var msg string
+			require.NoError(t, sess.Flash(ctx, "msg", &msg))
+			assert.Equal(t, "保存成功", msg)
+			// flash 消息只能读一次
+			assert.Equal(t, session.ErrKeyNotFound, sess.Flash(ctx, "msg", &msg))
+
+			require.NoError(t, sess.Delete(ctx, "key1"))
+			_, err = sess.Get(ctx, "key1")
+			assert.Equal(t, session.ErrKeyNotFound, err)
+		})
+	}
+}
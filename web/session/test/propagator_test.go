@@ -0,0 +1,122 @@
+package test
+
+import (
+	"gitee.com/geektime-geekbang/geektime-go/web/session"
+	"gitee.com/geektime-geekbang/geektime-go/web/session/cookie"
+	"gitee.com/geektime-geekbang/geektime-go/web/session/header"
+	"gitee.com/geektime-geekbang/geektime-go/web/session/query"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPropagator_Extract(t *testing.T) {
+	testCases := []struct {
+		name       string
+		propagator session.Propagator
+		req        func() *http.Request
+
+		wantID  string
+		wantErr bool
+	}{
+		{
+			name:       "header",
+			propagator: header.NewPropagator("X-Session-ID"),
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.Header.Set("X-Session-ID", "abc")
+				return req
+			},
+			wantID: "abc",
+		},
+		{
+			name:       "header not found",
+			propagator: header.NewPropagator("X-Session-ID"),
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/", nil)
+			},
+			wantErr: true,
+		},
+		{
+			name:       "bearer",
+			propagator: header.NewBearerPropagator(),
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.Header.Set("Authorization", "bearer abc")
+				return req
+			},
+			wantID: "abc",
+		},
+		{
+			name:       "basic",
+			propagator: header.NewBearerPropagator(),
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.SetBasicAuth("tom", "123")
+				return req
+			},
+			wantErr: true,
+		},
+		{
+			name:       "query",
+			propagator: query.NewPropagator("sessid"),
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/user?sessid=abc", nil)
+			},
+			wantID: "abc",
+		},
+		{
+			name: "composite",
+			propagator: session.NewCompositePropagator(cookie.NewPropagator("sessid"),
+				header.NewBearerPropagator(), query.NewPropagator("sessid")),
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/user?sessid=from-query", nil)
+				req.Header.Set("Authorization", "Bearer from-header")
+				return req
+			},
+			wantID: "from-header",
+		},
+		{
+			name: "composite not found",
+			propagator: session.NewCompositePropagator(cookie.NewPropagator("sessid"),
+				header.NewBearerPropagator()),
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/user?sessid=from-query", nil)
+			},
+			wantErr: true,
+		},
+		{
+			name:       "empty composite",
+			propagator: session.NewCompositePropagator(),
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/", nil)
+			},
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			id, err := tc.propagator.Extract(tc.req())
+			assert.Equal(t, tc.wantErr, err != nil)
+			assert.Equal(t, tc.wantID, id)
+		})
+	}
+}
+
+func TestCompositePropagator_Inject(t *testing.T) {
+	p := session.NewCompositePropagator(cookie.NewPropagator("sessid"),
+		header.NewBearerPropagator(), query.NewPropagator("sessid", query.WithRespHeader("X-Sess")))
+	recorder := httptest.NewRecorder()
+	require.NoError(t, p.Inject("abc", recorder))
+	assert.Equal(t, "abc", recorder.Header().Get("X-Session-ID"))
+	assert.Equal(t, "abc", recorder.Header().Get("X-Sess"))
+	cookies := recorder.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "abc", cookies[0].Value)
+
+	require.NoError(t, p.Remove(recorder))
+	assert.Empty(t, recorder.Header().Get("X-Session-ID"))
+	assert.Empty(t, recorder.Header().Get("X-Sess"))
+}
@@ -8,6 +8,21 @@ import (
 type Session interface {
 	Get(ctx context.Context, key string) (string, error)
 	Set(ctx context.Context, key string, val string) error
+	// GetValue 取出 key 对应的值，并且用 Store 的 Codec 反序列化到 val 里面
+	// val 必须是指针
+	GetValue(ctx context.Context, key string, val any) error
+	// SetValue 用 Store 的 Codec 序列化 val 之后再存进去
+	SetValue(ctx context.Context, key string, val any) error
+	// GetAll 一次性取出所有的数据，不包含 flash 消息
+	GetAll(ctx context.Context) (map[string]string, error)
+	// SetMulti 一次性设置多个值
+	SetMulti(ctx context.Context, vals map[string]string) error
+	Delete(ctx context.Context, key string) error
+	// SetFlash 设置一个 flash 消息，它只能被 Flash 读取一次
+	// 典型的场景是跳转之后展示的"保存成功"之类的提示
+	SetFlash(ctx context.Context, key string, val any) error
+	// Flash 读取 flash 消息，读完之后就删除了。没有的话返回 ErrKeyNotFound
+	Flash(ctx context.Context, key string, val any) error
 	ID() string
 }
 
@@ -19,11 +34,11 @@ type Session interface {
 type Store interface {
 	// Generate 生成一个 session
 	Generate(ctx context.Context, id string) (Session, error)
-	// Refresh 这种设计是一直用同一个 id 的
-	// 如果想支持 Refresh 换 ID，那么可以重新生成一个，并移除原有的
-	// 又或者 Refresh(ctx context.Context, id string) (Session, error)
-	// 其中返回的是一个新的 Session
+	// Refresh 这种设计是一直用同一个 id 的，需要换 ID 的话用 Rotate
 	Refresh(ctx context.Context, id string) error
+	// Rotate 把 oldID 的数据原子地迁移到 newID 上，并且删除 oldID
+	// 返回的是新的 Session。用于登录之类的权限变化的场景，防止 session fixation 攻击
+	Rotate(ctx context.Context, oldID string, newID string) (Session, error)
 	Remove(ctx context.Context, id string) error
 	Get(ctx context.Context, id string) (Session, error)
 }
@@ -0,0 +1,186 @@
+package web
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errStreamNotSupported = errors.New("web: ResponseWriter 没有实现 http.Flusher，不支持流式响应")
+	errStreamClosed       = errors.New("web: 流式响应已经关闭")
+	errCtxDetached        = errors.New("web: Clone 出来的 Context 不支持流式响应和 WebSocket")
+)
+
+// StreamWriter 流式响应
+// 开启流式响应之后，状态码和响应头会立刻发送给前端，
+// 之后每一次 Write 都会立刻 Flush，HTTP/1.1 下会使用 chunked 编码。
+// RespData 将不再生效，但是 RespStatusCode 和 RespSize 依旧可以被 Middleware 观察到。
+// 客户端断开连接可以通过 ctx.Req.Context().Done() 感知。
+// StreamWriter 是并发安全的
+type StreamWriter struct {
+	ctx     *Context
+	flusher http.Flusher
+
+	mutex   sync.Mutex
+	closed  bool
+	written int
+
+	// 心跳相关
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Stream 开启流式响应，code 是响应码
+// 重复调用会返回同一个 StreamWriter
+func (c *Context) Stream(code int) (*StreamWriter, error) {
+	if c.detached {
+		return nil, errCtxDetached
+	}
+	if c.stream != nil {
+		return c.stream, nil
+	}
+	flusher, ok := c.Resp.(http.Flusher)
+	if !ok {
+		return nil, errStreamNotSupported
+	}
+	c.RespStatusCode = code
+	c.Resp.Header().Del("Content-Length")
+	c.Resp.WriteHeader(code)
+	flusher.Flush()
+	c.stream = &StreamWriter{
+		ctx:     c,
+		flusher: flusher,
+		stop:    make(chan struct{}),
+	}
+	return c.stream, nil
+}
+
+// RespSize 响应体的大小
+// 普通响应就是 RespData 的长度；流式响应则是已经写出去的字节数
+func (c *Context) RespSize() int {
+	if c.stream != nil {
+		return c.stream.size()
+	}
+	return len(c.RespData)
+}
+
+func (s *StreamWriter) Write(data []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return 0, errStreamClosed
+	}
+	n, err := s.ctx.Resp.Write(data)
+	s.written += n
+	if err != nil {
+		return n, err
+	}
+	s.flusher.Flush()
+	return n, nil
+}
+
+// Close 关闭流式响应，并且停止心跳
+// 在 handler 返回之后，包括 handler panic 的情况，框架都会自动调用 Close，所以用户可以不调用
+func (s *StreamWriter) Close() error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.stop)
+	s.mutex.Unlock()
+	// 等待心跳的 goroutine 退出，确保 handler 返回之后不会再有任何写操作
+	s.wg.Wait()
+	return nil
+}
+
+// Heartbeat 每隔 interval 发送一次 data，用于保持连接，
+// 避免被中间的代理认为连接空闲而断开
+func (s *StreamWriter) Heartbeat(interval time.Duration, data []byte) {
+	reqDone := s.ctx.Req.Context().Done()
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := s.Write(data); err != nil {
+					return
+				}
+			case <-reqDone:
+				return
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (s *StreamWriter) size() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.written
+}
+
+// SSEEvent 一个 Server-Sent Events 事件
+type SSEEvent struct {
+	ID    string
+	Event string
+	// Retry 告诉浏览器断开之后多久重连，0 代表不设置
+	Retry time.Duration
+	// Data 可以是多行的，每一行都会被编码成一个 data 字段
+	Data string
+}
+
+// SSEWriter 用于发送 Server-Sent Events
+type SSEWriter struct {
+	*StreamWriter
+}
+
+// SSE 开启 Server-Sent Events 响应
+func (c *Context) SSE() (*SSEWriter, error) {
+	header := c.Resp.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	// 禁止 nginx 缓冲响应
+	header.Set("X-Accel-Buffering", "no")
+	sw, err := c.Stream(http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return &SSEWriter{StreamWriter: sw}, nil
+}
+
+// Send 发送一个事件
+func (w *SSEWriter) Send(evt SSEEvent) error {
+	buf := &bytes.Buffer{}
+	if evt.ID != "" {
+		fmt.Fprintf(buf, "id: %s\n", evt.ID)
+	}
+	if evt.Event != "" {
+		fmt.Fprintf(buf, "event: %s\n", evt.Event)
+	}
+	if evt.Retry > 0 {
+		fmt.Fprintf(buf, "retry: %d\n", evt.Retry.Milliseconds())
+	}
+	for _, line := range strings.Split(evt.Data, "\n") {
+		fmt.Fprintf(buf, "data: %s\n", line)
+	}
+	buf.WriteByte('\n')
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Heartbeat 每隔 interval 发送一个 SSE 注释作为心跳，浏览器会忽略注释
+func (w *SSEWriter) Heartbeat(interval time.Duration) {
+	w.StreamWriter.Heartbeat(interval, []byte(": heartbeat\n\n"))
+}
@@ -0,0 +1,111 @@
+package web
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContext_Stream(t *testing.T) {
+	s := NewHTTPServer()
+	var status, size int
+	s.Use(http.MethodGet, "/stream", func(next HandleFunc) HandleFunc {
+		return func(ctx *Context) {
+			next(ctx)
+			status = ctx.RespStatusCode
+			size = ctx.RespSize()
+		}
+	})
+	var leaked *StreamWriter
+	s.Get("/stream", func(ctx *Context) {
+		sw, err := ctx.Stream(http.StatusAccepted)
+		require.NoError(t, err)
+		leaked = sw
+		for _, chunk := range []string{"hello", ", ", "world"} {
+			_, err = sw.Write([]byte(chunk))
+			require.NoError(t, err)
+		}
+		// 流式响应之后，RespData 不再生效
+		ctx.RespData = []byte("ignored")
+	})
+	req := httptest.NewRequest(http.MethodGet, "/stream", nil)
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, req)
+
+	assert.Equal(t, http.StatusAccepted, recorder.Code)
+	assert.Equal(t, "hello, world", recorder.Body.String())
+	assert.True(t, recorder.Flushed)
+	assert.Equal(t, http.StatusAccepted, status)
+	assert.Equal(t, len("hello, world"), size)
+	// handler 返回之后，流已经被关闭了
+	_, err := leaked.Write([]byte("abc"))
+	assert.Equal(t, errStreamClosed, err)
+}
+
+func TestContext_SSE(t *testing.T) {
+	s := NewHTTPServer()
+	s.Get("/events", func(ctx *Context) {
+		sse, err := ctx.SSE()
+		require.NoError(t, err)
+		sse.Heartbeat(10 * time.Millisecond)
+		require.NoError(t, sse.Send(SSEEvent{
+			ID:    "1",
+			Event: "greeting",
+			Retry: 3 * time.Second,
+			Data:  "hello\nworld",
+		}))
+		time.Sleep(35 * time.Millisecond)
+		require.NoError(t, sse.Send(SSEEvent{Data: "bye"}))
+	})
+	req := httptest.NewRequest(http.MethodGet, "/events", nil)
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, req)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "text/event-stream", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, "no-cache", recorder.Header().Get("Cache-Control"))
+	body := recorder.Body.String()
+	assert.True(t, strings.HasPrefix(body,
+		"id: 1\nevent: greeting\nretry: 3000\ndata: hello\ndata: world\n\n"), body)
+	assert.True(t, strings.HasSuffix(body, "data: bye\n\n"), body)
+	assert.Contains(t, body, ": heartbeat\n\n")
+}
+
+// TestContext_Stream_HandlerPanic handler panic 的时候，心跳也要停下来
+func TestContext_Stream_HandlerPanic(t *testing.T) {
+	s := NewHTTPServer()
+	var leaked *StreamWriter
+	s.Get("/stream", func(ctx *Context) {
+		sw, err := ctx.Stream(http.StatusOK)
+		require.NoError(t, err)
+		leaked = sw
+		sw.Heartbeat(time.Millisecond, []byte("ping"))
+		time.Sleep(10 * time.Millisecond)
+		panic("handler panic")
+	})
+	req := httptest.NewRequest(http.MethodGet, "/stream", nil)
+	recorder := httptest.NewRecorder()
+	assert.PanicsWithValue(t, "handler panic", func() {
+		s.ServeHTTP(recorder, req)
+	})
+	// 心跳已经停止，不会再写数据
+	body := recorder.Body.String()
+	assert.Contains(t, body, "ping")
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, body, recorder.Body.String())
+	_, err := leaked.Write([]byte("abc"))
+	assert.Equal(t, errStreamClosed, err)
+}
+
+func TestContext_Stream_NotSupported(t *testing.T) {
+	ctx := &Context{
+		Req:  httptest.NewRequest(http.MethodGet, "/", nil),
+		Resp: &discardResponseWriter{header: http.Header{}},
+	}
+	_, err := ctx.Stream(http.StatusOK)
+	assert.Equal(t, errStreamNotSupported, err)
+}
@@ -0,0 +1,182 @@
+package web
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// FieldError 某个字段绑定或者校验失败
+type FieldError struct {
+	// Field 字段名，嵌套的结构体用 . 连接，例如 Address.City
+	Field string `json:"field"`
+	// Rule 失败的规则，例如 required, min
+	// 如果是绑定的时候类型转换失败，那么就是数据来源，例如 query, path
+	Rule string `json:"rule"`
+	// Param 规则的参数，例如 min=1 中的 1
+	Param string `json:"param,omitempty"`
+	Msg   string `json:"msg"`
+}
+
+func (f *FieldError) Error() string {
+	return fmt.Sprintf("web: 字段 %s 不满足 %s: %s", f.Field, f.Rule, f.Msg)
+}
+
+// FieldErrors 所有失败的字段
+// 一般来说，handler 拿到之后直接作为 400 的响应返回就可以
+type FieldErrors []*FieldError
+
+func (f FieldErrors) Error() string {
+	msgs := make([]string, 0, len(f))
+	for _, e := range f {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Validate 根据 validate 标签校验结构体，val 可以是结构体也可以是结构体指针
+// 多个规则之间用逗号分隔，例如 validate:"required,min=1,max=10"
+// 支持的规则：
+// - required 不能是零值
+// - min, max 对于数字来说，比较的是值；对于字符串、切片和 map 来说，比较的是长度
+// - len 字符串、切片和 map 的长度
+// - oneof 只能是其中一个值，多个值之间用空格分隔，例如 oneof=male female
+// - omitempty 字段是零值的时候跳过后面所有的规则，例如 validate:"omitempty,min=1"
+// 零值也会被校验，例如 min=1 不接受 0，len=3 不接受 ""；
+// 可选的字段要么使用 omitempty，要么使用指针，nil 指针只会被 required 拦截
+func Validate(val any) error {
+	rv := reflect.ValueOf(val)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+	var errs FieldErrors
+	validateStruct(rv, "", &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validateStruct(rv reflect.Value, prefix string, errs *FieldErrors) {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		ft := rt.Field(i)
+		if !ft.IsExported() {
+			continue
+		}
+		fv := rv.Field(i)
+		name := prefix + ft.Name
+		if ft.Anonymous && ft.Type.Kind() == reflect.Struct {
+			validateStruct(fv, prefix, errs)
+			continue
+		}
+		if tag, ok := ft.Tag.Lookup("validate"); ok && tag != "" && tag != "-" {
+			for _, rule := range strings.Split(tag, ",") {
+				if strings.TrimSpace(rule) == "omitempty" {
+					if fv.IsZero() {
+						break
+					}
+					continue
+				}
+				if fe := validateRule(fv, name, rule); fe != nil {
+					*errs = append(*errs, fe)
+					// 一个字段只报告第一个失败的规则
+					break
+				}
+			}
+		}
+		// 嵌套的结构体
+		inner := fv
+		if inner.Kind() == reflect.Pointer && !inner.IsNil() {
+			inner = inner.Elem()
+		}
+		if inner.Kind() == reflect.Struct && inner.Type() != timeType {
+			validateStruct(inner, name+".", errs)
+		}
+	}
+}
+
+func validateRule(fv reflect.Value, name string, rule string) *FieldError {
+	rule = strings.TrimSpace(rule)
+	ruleName, param, _ := strings.Cut(rule, "=")
+	fe := &FieldError{Field: name, Rule: ruleName, Param: param}
+	if ruleName == "required" {
+		if fv.IsZero() {
+			fe.Msg = "不能为空"
+			return fe
+		}
+		return nil
+	}
+	if fv.Kind() == reflect.Pointer {
+		if fv.IsNil() {
+			return nil
+		}
+		fv = fv.Elem()
+	}
+	switch ruleName {
+	case "min", "max", "len":
+		limit, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			fe.Msg = fmt.Sprintf("非法的规则参数 %s", param)
+			return fe
+		}
+		val, isLen, ok := numberOrLen(fv)
+		if !ok {
+			fe.Msg = fmt.Sprintf("类型 %s 不支持该规则", fv.Type())
+			return fe
+		}
+		desc := "值"
+		if isLen {
+			desc = "长度"
+		}
+		switch {
+		case ruleName == "min" && val < limit:
+			fe.Msg = fmt.Sprintf("%s不能小于 %s", desc, param)
+			return fe
+		case ruleName == "max" && val > limit:
+			fe.Msg = fmt.Sprintf("%s不能大于 %s", desc, param)
+			return fe
+		case ruleName == "len" && (!isLen || val != limit):
+			fe.Msg = fmt.Sprintf("长度必须是 %s", param)
+			return fe
+		}
+	case "oneof":
+		str := fmt.Sprint(fv.Interface())
+		for _, candidate := range strings.Fields(param) {
+			if candidate == str {
+				return nil
+			}
+		}
+		fe.Msg = fmt.Sprintf("必须是 [%s] 其中之一", param)
+		return fe
+	default:
+		fe.Msg = "未知的校验规则"
+		return fe
+	}
+	return nil
+}
+
+// numberOrLen 数字返回值，字符串、切片、map 返回长度
+// 第二个返回值为 true 代表返回的是长度
+func numberOrLen(fv reflect.Value) (float64, bool, bool) {
+	switch fv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(fv.Int()), false, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(fv.Uint()), false, true
+	case reflect.Float32, reflect.Float64:
+		return fv.Float(), false, true
+	case reflect.String:
+		return float64(len([]rune(fv.String()))), true, true
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(fv.Len()), true, true
+	}
+	return 0, false, false
+}
@@ -0,0 +1,455 @@
+package web
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// WebSocket 的消息类型，也就是 RFC 6455 中的 opcode
+const (
+	WSContinuationFrame = 0
+	WSTextMessage       = 1
+	WSBinaryMessage     = 2
+	WSCloseMessage      = 8
+	WSPingMessage       = 9
+	WSPongMessage       = 10
+)
+
+// WebSocket 关闭连接的状态码，参考 RFC 6455 7.4.1
+const (
+	WSCloseNormal           = 1000
+	WSCloseGoingAway        = 1001
+	WSCloseProtocolError    = 1002
+	WSCloseUnsupportedData  = 1003
+	WSCloseNoStatusReceived = 1005
+	WSCloseAbnormal         = 1006
+	WSCloseInvalidPayload   = 1007
+	WSClosePolicyViolation  = 1008
+	WSCloseMessageTooBig    = 1009
+	WSCloseInternalError    = 1011
+)
+
+// websocketGUID 是 RFC 6455 规定的，用于计算 Sec-WebSocket-Accept
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// defaultWSReadLimit 默认单条消息最大 32KB
+const defaultWSReadLimit = 32 << 10
+
+var (
+	errWSNotWebSocket       = errors.New("web: 不是 WebSocket 握手请求")
+	errWSBadVersion         = errors.New("web: 不支持的 WebSocket 版本")
+	errWSBadOrigin          = errors.New("web: WebSocket 请求的 Origin 不被允许")
+	errWSHijackNotSupported = errors.New("web: ResponseWriter 没有实现 http.Hijacker，不支持 WebSocket")
+	errWSConnClosed         = errors.New("web: WebSocket 连接已经关闭")
+)
+
+// WebSocketHandler 处理 WebSocket 连接
+// handler 返回之后，连接会被关闭
+type WebSocketHandler func(ctx *Context, conn *WebSocketConn)
+
+// WebSocket 注册一个 WebSocket 路由，它本质上是一个 GET 路由，
+// 所以路径参数、正则路由以及注册在路由上的 middleware 依旧生效。
+// middleware 在握手之前执行，例如鉴权的 middleware 可以直接拒绝握手
+func (s *HTTPServer) WebSocket(path string, handler WebSocketHandler) {
+	s.Get(path, func(ctx *Context) {
+		conn, err := s.wsUpgrader.Upgrade(ctx)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handler(ctx, conn)
+	})
+}
+
+// ServerWithWebSocketUpgrader 设置 WebSocket 路由使用的 WebSocketUpgrader
+func ServerWithWebSocketUpgrader(upgrader *WebSocketUpgrader) ServerOption {
+	return func(server *HTTPServer) {
+		server.wsUpgrader = upgrader
+	}
+}
+
+// WebSocketUpgrader 负责将 HTTP 请求升级为 WebSocket 连接
+type WebSocketUpgrader struct {
+	// ReadLimit 单条消息的最大字节数，超过了会以 1009 关闭连接
+	// 默认 32KB
+	ReadLimit int64
+	// CheckOrigin 校验 Origin，返回 false 会拒绝握手
+	// 为 nil 的时候，要求 Origin 和 Host 一致，没有 Origin 的请求会放行
+	CheckOrigin func(req *http.Request) bool
+	// Subprotocols 服务端支持的子协议，按照优先级排列
+	Subprotocols []string
+}
+
+// Upgrade 执行 RFC 6455 的握手
+// 握手失败的时候，会设置好 RespStatusCode 并返回 error，调用者直接返回就可以
+func (u *WebSocketUpgrader) Upgrade(ctx *Context) (*WebSocketConn, error) {
+	req := ctx.Req
+	if req.Method != http.MethodGet ||
+		!headerContainsToken(req.Header, "Connection", "upgrade") ||
+		!headerContainsToken(req.Header, "Upgrade", "websocket") {
+		ctx.RespStatusCode = http.StatusBadRequest
+		return nil, errWSNotWebSocket
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		ctx.Resp.Header().Set("Sec-WebSocket-Version", "13")
+		ctx.RespStatusCode = http.StatusUpgradeRequired
+		return nil, errWSBadVersion
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		ctx.RespStatusCode = http.StatusBadRequest
+		return nil, errWSNotWebSocket
+	}
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(req) {
+		ctx.RespStatusCode = http.StatusForbidden
+		return nil, errWSBadOrigin
+	}
+	if ctx.detached {
+		ctx.RespStatusCode = http.StatusInternalServerError
+		return nil, errCtxDetached
+	}
+	hj, ok := ctx.Resp.(http.Hijacker)
+	if !ok {
+		ctx.RespStatusCode = http.StatusInternalServerError
+		return nil, errWSHijackNotSupported
+	}
+	subprotocol := u.selectSubprotocol(req)
+
+	netConn, brw, err := hj.Hijack()
+	if err != nil {
+		ctx.RespStatusCode = http.StatusInternalServerError
+		return nil, err
+	}
+	// 从这里开始，连接已经不归 http.Server 管了
+	ctx.hijacked = true
+	ctx.RespStatusCode = http.StatusSwitchingProtocols
+
+	var sb strings.Builder
+	sb.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	sb.WriteString("Upgrade: websocket\r\n")
+	sb.WriteString("Connection: Upgrade\r\n")
+	sb.WriteString("Sec-WebSocket-Accept: " + computeAcceptKey(key) + "\r\n")
+	if subprotocol != "" {
+		sb.WriteString("Sec-WebSocket-Protocol: " + subprotocol + "\r\n")
+	}
+	sb.WriteString("\r\n")
+	if _, err = netConn.Write([]byte(sb.String())); err != nil {
+		_ = netConn.Close()
+		return nil, err
+	}
+
+	readLimit := u.ReadLimit
+	if readLimit <= 0 {
+		readLimit = defaultWSReadLimit
+	}
+	return &WebSocketConn{
+		conn:        netConn,
+		br:          brw.Reader,
+		readLimit:   readLimit,
+		Subprotocol: subprotocol,
+	}, nil
+}
+
+func (u *WebSocketUpgrader) selectSubprotocol(req *http.Request) string {
+	requested := headerTokens(req.Header, "Sec-WebSocket-Protocol")
+	for _, p := range u.Subprotocols {
+		for _, r := range requested {
+			if p == r {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+func sameOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, req.Host)
+}
+
+func computeAcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func headerTokens(header http.Header, name string) []string {
+	var res []string
+	for _, val := range header.Values(name) {
+		for _, token := range strings.Split(val, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				res = append(res, token)
+			}
+		}
+	}
+	return res
+}
+
+func headerContainsToken(header http.Header, name string, token string) bool {
+	for _, t := range headerTokens(header, name) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// WebSocketCloseError 对端关闭了连接，或者因为协议错误而关闭了连接
+type WebSocketCloseError struct {
+	Code int
+	Text string
+}
+
+func (e *WebSocketCloseError) Error() string {
+	return fmt.Sprintf("web: WebSocket 连接关闭 %d %s", e.Code, e.Text)
+}
+
+// WebSocketConn 消息级别的 WebSocket 连接
+// - 读操作不是并发安全的，同一时间只能有一个 goroutine 调用 ReadMessage
+// - 写操作是并发安全的
+// - Ping 会自动回复 Pong，Close 会自动回复 Close
+type WebSocketConn struct {
+	conn      net.Conn
+	br        *bufio.Reader
+	readLimit int64
+	// Subprotocol 握手协商出来的子协议
+	Subprotocol string
+
+	writeMutex sync.Mutex
+	closeSent  bool
+
+	pongHandler func(data []byte)
+}
+
+// SetReadLimit 设置单条消息的最大字节数
+func (c *WebSocketConn) SetReadLimit(limit int64) {
+	c.readLimit = limit
+}
+
+// SetPongHandler 设置收到 Pong 时候的回调，一般用于实现心跳检测
+func (c *WebSocketConn) SetPongHandler(h func(data []byte)) {
+	c.pongHandler = h
+}
+
+func (c *WebSocketConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *WebSocketConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// ReadMessage 读取一条完整的消息，分片的消息会被拼接起来
+// 返回的消息类型是 WSTextMessage 或者 WSBinaryMessage
+// 对端关闭连接的时候，返回 *WebSocketCloseError
+func (c *WebSocketConn) ReadMessage() (int, []byte, error) {
+	var (
+		msgType int
+		msg     []byte
+	)
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch opcode {
+		case WSPingMessage:
+			if err = c.writeFrame(WSPongMessage, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case WSPongMessage:
+			if c.pongHandler != nil {
+				c.pongHandler(payload)
+			}
+			continue
+		case WSCloseMessage:
+			return 0, nil, c.handleClose(payload)
+		case WSTextMessage, WSBinaryMessage:
+			if msgType != 0 {
+				return 0, nil, c.failConnection(WSCloseProtocolError, "分片消息还没有结束")
+			}
+			msgType = opcode
+		case WSContinuationFrame:
+			if msgType == 0 {
+				return 0, nil, c.failConnection(WSCloseProtocolError, "没有起始帧的分片")
+			}
+		default:
+			return 0, nil, c.failConnection(WSCloseProtocolError, "未知的 opcode")
+		}
+		if int64(len(msg)+len(payload)) > c.readLimit {
+			return 0, nil, c.failConnection(WSCloseMessageTooBig, "消息太大")
+		}
+		msg = append(msg, payload...)
+		if fin {
+			if msgType == WSTextMessage && !utf8.Valid(msg) {
+				return 0, nil, c.failConnection(WSCloseInvalidPayload, "文本消息不是合法的 UTF-8")
+			}
+			return msgType, msg, nil
+		}
+	}
+}
+
+// readFrame 读取一个帧，客户端发过来的帧必须有掩码
+func (c *WebSocketConn) readFrame() (bool, int, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := header[0]&0x80 != 0
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, c.failConnection(WSCloseProtocolError, "不支持扩展")
+	}
+	opcode := int(header[0] & 0x0f)
+	masked := header[1]&0x80 != 0
+	if !masked {
+		return false, 0, nil, c.failConnection(WSCloseProtocolError, "客户端的帧必须有掩码")
+	}
+	length := int64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+	if opcode >= WSCloseMessage && (!fin || length > 125) {
+		return false, 0, nil, c.failConnection(WSCloseProtocolError, "非法的控制帧")
+	}
+	if length < 0 || length > c.readLimit {
+		return false, 0, nil, c.failConnection(WSCloseMessageTooBig, "消息太大")
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+func (c *WebSocketConn) handleClose(payload []byte) error {
+	closeErr := &WebSocketCloseError{Code: WSCloseNoStatusReceived}
+	if len(payload) >= 2 {
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Text = string(payload[2:])
+	}
+	// 回复一个 Close 帧，完成关闭握手
+	// 对端没有带状态码的话，回复一个空的 Close 帧，WriteClose 会处理 1005 的情况
+	_ = c.WriteClose(closeErr.Code, "")
+	return closeErr
+}
+
+// failConnection 因为对端违反协议而关闭连接
+func (c *WebSocketConn) failConnection(code int, text string) error {
+	_ = c.WriteClose(code, text)
+	return &WebSocketCloseError{Code: code, Text: text}
+}
+
+// WriteMessage 发送一条消息，messageType 是 WSTextMessage 或者 WSBinaryMessage
+func (c *WebSocketConn) WriteMessage(messageType int, data []byte) error {
+	if messageType != WSTextMessage && messageType != WSBinaryMessage {
+		return fmt.Errorf("web: 非法的 WebSocket 消息类型 %d", messageType)
+	}
+	return c.writeFrame(messageType, data)
+}
+
+// WritePing 发送 Ping
+func (c *WebSocketConn) WritePing(data []byte) error {
+	return c.writeFrame(WSPingMessage, data)
+}
+
+// WriteClose 发送 Close 帧，之后就不能再发送任何消息了
+// 1005 和 1006 只能在本地使用，RFC 6455 7.4.1 禁止出现在 Close 帧里面，
+// 这时候会发送一个不带状态码的 Close 帧
+func (c *WebSocketConn) WriteClose(code int, text string) error {
+	if code == WSCloseNoStatusReceived || code == WSCloseAbnormal {
+		return c.writeFrame(WSCloseMessage, nil)
+	}
+	// 控制帧最多 125 字节，去掉状态码的 2 个字节
+	text = truncateUTF8(text, 123)
+	payload := make([]byte, 2, 2+len(text))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, text...)
+	return c.writeFrame(WSCloseMessage, payload)
+}
+
+// truncateUTF8 截断到最多 n 个字节，不会把一个 UTF-8 字符截成两半
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// writeFrame 服务端发送的帧不需要掩码
+func (c *WebSocketConn) writeFrame(opcode int, payload []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	if c.closeSent {
+		return errWSConnClosed
+	}
+	if opcode == WSCloseMessage {
+		c.closeSent = true
+	}
+	frame := make([]byte, 0, len(payload)+10)
+	frame = append(frame, 0x80|byte(opcode))
+	switch length := len(payload); {
+	case length <= 125:
+		frame = append(frame, byte(length))
+	case length <= 0xffff:
+		frame = append(frame, 126, byte(length>>8), byte(length))
+	default:
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(length))
+		frame = append(frame, 127)
+		frame = append(frame, ext[:]...)
+	}
+	frame = append(frame, payload...)
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// Close 发送正常关闭的 Close 帧，并且关闭底层连接
+func (c *WebSocketConn) Close() error {
+	_ = c.WriteClose(WSCloseNormal, "")
+	return c.conn.Close()
+}
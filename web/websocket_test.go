@@ -0,0 +1,354 @@
+package web
+
+import (
+	"bufio"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestComputeAcceptKey(t *testing.T) {
+	// RFC 6455 1.3 中的例子
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=",
+		computeAcceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func TestHTTPServer_WebSocket(t *testing.T) {
+	s := NewHTTPServer()
+	s.Use(http.MethodGet, "/ws", func(next HandleFunc) HandleFunc {
+		return func(ctx *Context) {
+			if ctx.Req.Header.Get("X-Token") != "abc" {
+				ctx.RespStatusCode = http.StatusUnauthorized
+				return
+			}
+			next(ctx)
+		}
+	})
+	s.WebSocket("/ws/:room", func(ctx *Context, conn *WebSocketConn) {
+		room := ctx.PathValue("room").val
+		for {
+			typ, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			_ = conn.WriteMessage(typ, append([]byte(room+":"), msg...))
+		}
+	})
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	t.Run("echo", func(t *testing.T) {
+		c := dialWebSocket(t, server.URL, "/ws/golang", http.Header{"X-Token": []string{"abc"}})
+		defer c.conn.Close()
+
+		c.writeFrame(t, true, WSTextMessage, []byte("hello"))
+		opcode, payload := c.readFrame(t)
+		assert.Equal(t, WSTextMessage, opcode)
+		assert.Equal(t, "golang:hello", string(payload))
+
+		// 分片的二进制消息，中间夹着一个 Ping
+		c.writeFrame(t, false, WSBinaryMessage, []byte{1, 2})
+		c.writeFrame(t, true, WSPingMessage, []byte("ping"))
+		c.writeFrame(t, true, WSContinuationFrame, []byte{3})
+		opcode, payload = c.readFrame(t)
+		assert.Equal(t, WSPongMessage, opcode)
+		assert.Equal(t, "ping", string(payload))
+		opcode, payload = c.readFrame(t)
+		assert.Equal(t, WSBinaryMessage, opcode)
+		assert.Equal(t, append([]byte("golang:"), 1, 2, 3), payload)
+
+		// 关闭握手
+		closePayload := make([]byte, 2)
+		binary.BigEndian.PutUint16(closePayload, WSCloseGoingAway)
+		c.writeFrame(t, true, WSCloseMessage, closePayload)
+		opcode, payload = c.readFrame(t)
+		assert.Equal(t, WSCloseMessage, opcode)
+		assert.Equal(t, WSCloseGoingAway, int(binary.BigEndian.Uint16(payload)))
+	})
+
+	t.Run("close without status", func(t *testing.T) {
+		c := dialWebSocket(t, server.URL, "/ws/golang", http.Header{"X-Token": []string{"abc"}})
+		defer c.conn.Close()
+		c.writeFrame(t, true, WSCloseMessage, nil)
+		// 不能回复 1005，只能回复一个空的 Close 帧
+		opcode, payload := c.readFrame(t)
+		assert.Equal(t, WSCloseMessage, opcode)
+		assert.Empty(t, payload)
+	})
+
+	t.Run("message too big", func(t *testing.T) {
+		c := dialWebSocket(t, server.URL, "/ws/golang", http.Header{"X-Token": []string{"abc"}})
+		defer c.conn.Close()
+		c.writeFrame(t, true, WSBinaryMessage, make([]byte, defaultWSReadLimit+1))
+		opcode, payload := c.readFrame(t)
+		assert.Equal(t, WSCloseMessage, opcode)
+		assert.Equal(t, WSCloseMessageTooBig, int(binary.BigEndian.Uint16(payload)))
+	})
+
+	t.Run("middleware reject", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/ws/golang", nil)
+		require.NoError(t, err)
+		setWebSocketHeaders(req.Header)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+}
+
+func TestWebSocketConn_WriteClose(t *testing.T) {
+	testCases := []struct {
+		name string
+		code int
+		text string
+
+		wantPayload []byte
+	}{
+		{
+			name:        "normal",
+			code:        WSCloseNormal,
+			text:        "bye",
+			wantPayload: []byte{0x03, 0xe8, 'b', 'y', 'e'},
+		},
+		{
+			name: "no status",
+			code: WSCloseNoStatusReceived,
+			text: "bye",
+		},
+		{
+			name: "abnormal",
+			code: WSCloseAbnormal,
+		},
+		{
+			// 每个汉字 3 个字节，123 个字节刚好 41 个汉字
+			name:        "truncate",
+			code:        WSCloseNormal,
+			text:        strings.Repeat("关", 50),
+			wantPayload: append([]byte{0x03, 0xe8}, strings.Repeat("关", 41)...),
+		},
+		{
+			// 122 个字节之后是一个汉字，截断的时候要把整个汉字去掉
+			name:        "truncate rune boundary",
+			code:        WSCloseNormal,
+			text:        strings.Repeat("a", 122) + "关闭",
+			wantPayload: append([]byte{0x03, 0xe8}, strings.Repeat("a", 122)...),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer client.Close()
+			conn := &WebSocketConn{conn: server}
+			go func() {
+				_ = conn.WriteClose(tc.code, tc.text)
+			}()
+			header := make([]byte, 2)
+			_, err := io.ReadFull(client, header)
+			require.NoError(t, err)
+			assert.Equal(t, byte(0x80|WSCloseMessage), header[0])
+			payload := make([]byte, int(header[1]))
+			_, err = io.ReadFull(client, payload)
+			require.NoError(t, err)
+			if len(tc.wantPayload) == 0 {
+				assert.Empty(t, payload)
+				return
+			}
+			assert.Equal(t, tc.wantPayload, payload)
+			assert.True(t, utf8.Valid(payload[2:]))
+		})
+	}
+}
+
+func TestWebSocketUpgrader_Upgrade(t *testing.T) {
+	testCases := []struct {
+		name     string
+		upgrader *WebSocketUpgrader
+		req      func() *http.Request
+		wantCode int
+		wantErr  error
+	}{
+		{
+			name:     "not websocket",
+			upgrader: &WebSocketUpgrader{},
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/ws", nil)
+			},
+			wantCode: http.StatusBadRequest,
+			wantErr:  errWSNotWebSocket,
+		},
+		{
+			name:     "bad version",
+			upgrader: &WebSocketUpgrader{},
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+				setWebSocketHeaders(req.Header)
+				req.Header.Set("Sec-WebSocket-Version", "8")
+				return req
+			},
+			wantCode: http.StatusUpgradeRequired,
+			wantErr:  errWSBadVersion,
+		},
+		{
+			name:     "bad key",
+			upgrader: &WebSocketUpgrader{},
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+				setWebSocketHeaders(req.Header)
+				req.Header.Set("Sec-WebSocket-Key", "abc")
+				return req
+			},
+			wantCode: http.StatusBadRequest,
+			wantErr:  errWSNotWebSocket,
+		},
+		{
+			name:     "cross origin",
+			upgrader: &WebSocketUpgrader{},
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "http://example.com/ws", nil)
+				setWebSocketHeaders(req.Header)
+				req.Header.Set("Origin", "http://evil.com")
+				return req
+			},
+			wantCode: http.StatusForbidden,
+			wantErr:  errWSBadOrigin,
+		},
+		{
+			name: "custom check origin",
+			upgrader: &WebSocketUpgrader{CheckOrigin: func(req *http.Request) bool {
+				return true
+			}},
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "http://example.com/ws", nil)
+				setWebSocketHeaders(req.Header)
+				req.Header.Set("Origin", "http://evil.com")
+				return req
+			},
+			// httptest.ResponseRecorder 不支持 Hijack
+			wantCode: http.StatusInternalServerError,
+			wantErr:  errWSHijackNotSupported,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := &Context{Req: tc.req(), Resp: httptest.NewRecorder()}
+			_, err := tc.upgrader.Upgrade(ctx)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantCode, ctx.RespStatusCode)
+		})
+	}
+}
+
+func TestWebSocketUpgrader_Subprotocol(t *testing.T) {
+	s := NewHTTPServer(ServerWithWebSocketUpgrader(&WebSocketUpgrader{
+		Subprotocols: []string{"chat.v2", "chat.v1"},
+	}))
+	s.WebSocket("/ws", func(ctx *Context, conn *WebSocketConn) {
+		_ = conn.WriteMessage(WSTextMessage, []byte(conn.Subprotocol))
+	})
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	c := dialWebSocket(t, server.URL, "/ws",
+		http.Header{"Sec-WebSocket-Protocol": []string{"chat.v1, chat.v2"}})
+	defer c.conn.Close()
+	assert.Equal(t, "chat.v2", c.resp.Header.Get("Sec-WebSocket-Protocol"))
+	_, payload := c.readFrame(t)
+	assert.Equal(t, "chat.v2", string(payload))
+	// handler 返回之后，框架会正常关闭连接
+	opcode, payload := c.readFrame(t)
+	assert.Equal(t, WSCloseMessage, opcode)
+	assert.Equal(t, WSCloseNormal, int(binary.BigEndian.Uint16(payload)))
+}
+
+type testWSClient struct {
+	conn net.Conn
+	br   *bufio.Reader
+	resp *http.Response
+}
+
+func setWebSocketHeaders(header http.Header) {
+	header.Set("Connection", "Upgrade")
+	header.Set("Upgrade", "websocket")
+	header.Set("Sec-WebSocket-Version", "13")
+	header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+}
+
+func dialWebSocket(t *testing.T, serverURL string, path string, header http.Header) *testWSClient {
+	addr := strings.TrimPrefix(serverURL, "http://")
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	require.NoError(t, conn.SetDeadline(time.Now().Add(3*time.Second)))
+	req, err := http.NewRequest(http.MethodGet, serverURL+path, nil)
+	require.NoError(t, err)
+	for key, vals := range header {
+		req.Header[key] = vals
+	}
+	setWebSocketHeaders(req.Header)
+	require.NoError(t, req.Write(conn))
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	require.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"))
+	return &testWSClient{conn: conn, br: br, resp: resp}
+}
+
+// writeFrame 客户端发送的帧必须有掩码
+func (c *testWSClient) writeFrame(t *testing.T, fin bool, opcode int, payload []byte) {
+	b0 := byte(opcode)
+	if fin {
+		b0 |= 0x80
+	}
+	frame := []byte{b0}
+	switch length := len(payload); {
+	case length <= 125:
+		frame = append(frame, 0x80|byte(length))
+	case length <= 0xffff:
+		frame = append(frame, 0x80|126, byte(length>>8), byte(length))
+	default:
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(length))
+		frame = append(frame, 0x80|127)
+		frame = append(frame, ext[:]...)
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := c.conn.Write(frame)
+	require.NoError(t, err)
+}
+
+func (c *testWSClient) readFrame(t *testing.T) (int, []byte) {
+	header := make([]byte, 2)
+	_, err := io.ReadFull(c.br, header)
+	require.NoError(t, err)
+	// 服务端发送的帧不应该有掩码
+	require.Zero(t, header[1]&0x80)
+	length := int(header[1] & 0x7f)
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		_, err = io.ReadFull(c.br, ext)
+		require.NoError(t, err)
+		length = int(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		_, err = io.ReadFull(c.br, ext)
+		require.NoError(t, err)
+		length = int(binary.BigEndian.Uint64(ext))
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(c.br, payload)
+	require.NoError(t, err)
+	return int(header[0] & 0x0f), payload
+}